@@ -4,4 +4,4 @@
 # go mod tidy
 
 # Run the program
-mpirun -np 3 go run cmd/main.go -mpi "$@"
+mpirun -np 3 go run ./cmd run -mode mpi "$@"
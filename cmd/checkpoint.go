@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"pchpc_next/streets"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointQueryInterval is the time between two polls of the leaves while waiting for them to hold all vehicles
+const checkpointQueryInterval = 100 * time.Millisecond
+
+// checkpointFlags are the flags for checkpoint and restart of MPI runs
+type checkpointFlags struct {
+	dir    *string
+	every  *time.Duration
+	resume *string
+}
+
+func addCheckpointFlags(fs *flag.FlagSet) *checkpointFlags {
+	return &checkpointFlags{
+		dir:    fs.String("checkpoint-dir", "", "Directory for checkpoints, SIGTERM or SIGINT write a checkpoint and end the run, SIGUSR1 writes one and continues"),
+		every:  fs.Duration("checkpoint-every", 0, "Write a checkpoint to -checkpoint-dir periodically, e.g. 10m"),
+		resume: fs.String("resume", "", "Resume the run from this checkpoint directory, the vehicle flags are ignored"),
+	}
+}
+
+// enabled reports whether checkpoints can be taken during the run
+func (cf *checkpointFlags) enabled() bool {
+	return *cf.dir != ""
+}
+
+// resumeVehicles loads a checkpoint and places its vehicles on the root graph
+func resumeVehicles(dir string, rootGraph *streets.StreetGraph) (*streets.Checkpoint, []*streets.Vehicle, error) {
+	cp, err := streets.LoadCheckpoint(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("checkpoint %s: %w", dir, err)
+	}
+
+	vehicleList := make([]*streets.Vehicle, len(cp.Vehicles))
+	for i := range cp.Vehicles {
+		cp.Vehicles[i].StreetGraph = rootGraph
+		vehicleList[i] = &cp.Vehicles[i]
+	}
+	log.Info().Msgf("Resuming %d vehicles at %f, %d vehicles were parked", len(vehicleList), cp.Root.Clock, cp.Root.Parked)
+	return cp, vehicleList, nil
+}
+
+// releaseTracker remembers which vehicles the root has emitted. Emission is paused while a checkpoint is taken.
+type releaseTracker struct {
+	mu       sync.Mutex
+	released map[string]bool
+	emitted  atomic.Int64
+}
+
+func newReleaseTracker() *releaseTracker {
+	return &releaseTracker{released: make(map[string]bool)}
+}
+
+// wrap returns an emit function for streets.ReleaseVehicles that records the emitted vehicles
+func (rt *releaseTracker) wrap(emit func(*streets.Vehicle) error) func(*streets.Vehicle) error {
+	return func(vehicle *streets.Vehicle) error {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+
+		if err := emit(vehicle); err != nil {
+			return err
+		}
+		rt.released[vehicle.ID] = true
+		rt.emitted.Add(1)
+		return nil
+	}
+}
+
+// count returns the number of emitted vehicles
+func (rt *releaseTracker) count() int {
+	return int(rt.emitted.Load())
+}
+
+// rootCheckpointer coordinates the checkpoints of all ranks from the root
+type rootCheckpointer struct {
+	m          *streets.MPI
+	dir        string
+	leaves     int
+	seed       int64
+	clock      *streets.Clock
+	vehicles   []*streets.Vehicle
+	release    *releaseTracker
+	tripWriter *streets.TripWriter
+
+	// parked counts the trip records received in this run, previouslyParked those of the resumed runs
+	parked           atomic.Int64
+	previouslyParked int
+	// edgeStats are the edge stats carried over from the resumed runs
+	edgeStats []streets.EdgeInterval
+
+	mu       sync.Mutex
+	finished bool
+	exited   chan struct{}
+}
+
+// checkpoint pauses all leaves until every vehicle is held or parked, writes the checkpoint and
+// either resumes the leaves or ends the run
+func (c *rootCheckpointer) checkpoint(exit bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.finished {
+		return errors.New("run has already finished")
+	}
+
+	c.release.mu.Lock()
+	defer c.release.mu.Unlock()
+
+	for leaf := 1; leaf <= c.leaves; leaf++ {
+		if err := c.m.SendCheckpointCommand(leaf, streets.CheckpointCommand{Kind: streets.CHECKPOINT_PAUSE}); err != nil {
+			return err
+		}
+	}
+
+	err := c.write()
+
+	kind := streets.CHECKPOINT_RESUME
+	if exit && err == nil {
+		kind = streets.CHECKPOINT_EXIT
+	}
+	for leaf := 1; leaf <= c.leaves; leaf++ {
+		if sendErr := c.m.SendCheckpointCommand(leaf, streets.CheckpointCommand{Kind: kind}); sendErr != nil {
+			return sendErr
+		}
+	}
+	if kind == streets.CHECKPOINT_EXIT {
+		c.finished = true
+		close(c.exited)
+	}
+	return err
+}
+
+// write waits for the paused leaves and writes the checkpoint of every rank
+func (c *rootCheckpointer) write() error {
+	unreleased := make([]streets.Vehicle, 0)
+	for _, vehicle := range c.vehicles {
+		if !c.release.released[vehicle.ID] {
+			unreleased = append(unreleased, *vehicle)
+		}
+	}
+
+	// every vehicle is parked, not released yet or held by a leaf, none is driving or in flight
+	for {
+		held, active := 0, 0
+		for leaf := 1; leaf <= c.leaves; leaf++ {
+			reply, err := c.query(leaf, streets.CheckpointCommand{Kind: streets.CHECKPOINT_QUERY})
+			if err != nil {
+				return err
+			}
+			held += reply.Held
+			active += reply.Active
+		}
+		parked := int(c.parked.Load())
+		if active == 0 && parked+len(unreleased)+held == len(c.vehicles) {
+			log.Info().Msgf("[0] Checkpoint holds %d vehicles, %d unreleased, %d parked", held, len(unreleased), parked)
+			break
+		}
+		time.Sleep(checkpointQueryInterval)
+	}
+
+	if err := streets.PrepareCheckpointDir(c.dir); err != nil {
+		return err
+	}
+	for leaf := 1; leaf <= c.leaves; leaf++ {
+		reply, err := c.query(leaf, streets.CheckpointCommand{Kind: streets.CHECKPOINT_WRITE, Dir: c.dir})
+		if err != nil {
+			return err
+		}
+		if reply.Err != "" {
+			return fmt.Errorf("leaf %d: %s", leaf, reply.Err)
+		}
+	}
+
+	// the trip records up to Parked must be on disk before the checkpoint is complete
+	if c.tripWriter != nil {
+		if err := c.tripWriter.Flush(); err != nil {
+			return err
+		}
+	}
+
+	rc := streets.RootCheckpoint{
+		WorldSize: c.leaves + 1,
+		Seed:      c.seed,
+		Clock:     c.clock.Now(),
+		Parked:    c.previouslyParked + int(c.parked.Load()),
+		EdgeStats: c.edgeStats,
+	}
+	return streets.WriteRootCheckpoint(c.dir, rc, unreleased)
+}
+
+// query sends a command to a leaf and waits for its reply
+func (c *rootCheckpointer) query(leaf int, cmd streets.CheckpointCommand) (streets.CheckpointReply, error) {
+	if err := c.m.SendCheckpointCommand(leaf, cmd); err != nil {
+		return streets.CheckpointReply{}, err
+	}
+	return c.m.ReceiveCheckpointReply(leaf)
+}
+
+// run takes checkpoints on signals and every interval until the run ends
+func (c *rootCheckpointer) run(every time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	var tick <-chan time.Time
+	if every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		exit := false
+		select {
+		case sig := <-signals:
+			exit = sig != syscall.SIGUSR1
+			log.Info().Msgf("[0] Received %s, writing checkpoint to %s", sig, c.dir)
+		case <-tick:
+			log.Info().Msgf("[0] Writing checkpoint to %s", c.dir)
+		}
+
+		if err := c.checkpoint(exit); err != nil {
+			log.Error().Err(err).Msg("Failed to write checkpoint")
+			c.mu.Lock()
+			finished := c.finished
+			c.mu.Unlock()
+			if finished {
+				return
+			}
+			continue
+		}
+		log.Info().Msgf("[0] Wrote checkpoint to %s", c.dir)
+		if exit {
+			return
+		}
+	}
+}
+
+// finish waits for a running checkpoint and stops the checkpoint listeners of the leaves.
+// It reports whether the run ended with a checkpoint.
+func (c *rootCheckpointer) finish() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.exited:
+		return true, nil
+	default:
+	}
+
+	c.finished = true
+	for leaf := 1; leaf <= c.leaves; leaf++ {
+		if err := c.m.SendCheckpointCommand(leaf, streets.CheckpointCommand{Kind: streets.CHECKPOINT_FINISH}); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// listenForCheckpoint answers the checkpoint commands of the root on a leaf.
+// Held vehicles are entered into the leaf again when the root resumes the run.
+func listenForCheckpoint(m *streets.MPI, gate *streets.CheckpointGate, taskID int, edgeStats func() ([]streets.EdgeInterval, error), parking *streets.ParkingRegistry, enter func(streets.Vehicle) error) {
+	for {
+		cmd, err := m.ReceiveCheckpointCommand()
+		if err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to receive checkpoint command", taskID)
+			return
+		}
+		log.Debug().Msgf("[%d] Received checkpoint command %s", taskID, cmd.Kind)
+
+		reply := streets.CheckpointReply{Rank: taskID}
+		switch cmd.Kind {
+		case streets.CHECKPOINT_PAUSE:
+			gate.Pause()
+			continue
+		case streets.CHECKPOINT_QUERY:
+			reply.Held = gate.Held()
+			reply.Active = gate.Active()
+		case streets.CHECKPOINT_WRITE:
+			rows, err := edgeStats()
+			if err == nil {
+				err = gate.WriteRankCheckpoint(cmd.Dir, taskID, rows, parking.Events())
+			}
+			if err != nil {
+				reply.Err = err.Error()
+			}
+		case streets.CHECKPOINT_RESUME:
+			for _, vehicle := range gate.Resume() {
+				if err := enter(vehicle); err != nil {
+					log.Error().Err(err).Msgf("[%d] Failed to resume vehicle %s", taskID, vehicle.ID)
+				}
+			}
+			continue
+		case streets.CHECKPOINT_EXIT:
+			gate.Exit()
+			return
+		case streets.CHECKPOINT_FINISH:
+			return
+		default:
+			reply.Err = "unknown checkpoint command " + cmd.Kind
+		}
+
+		if err := m.SendCheckpointReply(reply); err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send checkpoint reply", taskID)
+			return
+		}
+	}
+}
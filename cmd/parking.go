@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"pchpc_next/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// loadParking reads the parking facilities, nil if no path is given
+func loadParking(path string, reportPath string) (*streets.Parking, error) {
+	if path == "" {
+		if reportPath != "" {
+			return nil, errors.New("-parking-report requires -parking")
+		}
+		return nil, nil
+	}
+	parking, err := streets.LoadParkingFile(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Loaded %d parking facilities from %s", len(parking.Facilities), path)
+	return &parking, nil
+}
+
+func writeParkingReport(path string, facilities []streets.ParkingFacility, events []streets.ParkingEvent, interval float64) {
+	if path == "" {
+		return
+	}
+	rows := streets.ParkingOccupancy(facilities, events, interval)
+	if err := streets.WriteParkingFile(path, rows, interval); err != nil {
+		log.Error().Err(err).Msg("Failed to write parking report")
+		return
+	}
+	log.Info().Msgf("Wrote %d parking intervals", len(rows))
+}
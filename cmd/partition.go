@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"pchpc_next/streets"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog"
+)
+
+// partitionCommand handles 'partition', which shows the leaves an MPI run with -leaves+1 ranks would use
+func partitionCommand(args []string) int {
+	fs := newFlagSet("partition", "Divide the graph into the rectangles of the leaves of an MPI run and show their size and the edges between them.")
+	jsonPath := fs.String("jsonPath", "assets/out.json", "Path to the json containing the graph data")
+	leaves := fs.Int("leaves", 2, "Number of leaves, an MPI run with n ranks has n-1 leaves")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *leaves < 1 {
+		return usageError(fs, "-leaves must be at least 1")
+	}
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	rootGraph, err := loadRootGraph(*jsonPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *jsonPath, err)
+		return 1
+	}
+
+	leafList := make([]*streets.StreetGraph, 0, *leaves)
+	for rank := 1; rank <= *leaves; rank++ {
+		l, err := setupLeaf(jsonPath, rootGraph, *leaves, rank, rank)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leaf %d: %v\n", rank, err)
+			return 1
+		}
+		leafList = append(leafList, l)
+	}
+
+	leafLookup, err := buildLeafLookup(rootGraph, leafList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	edges, err := rootGraph.Graph.Edges()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	vertices, err := rootGraph.GetVertices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	crossing := make(map[int]int)
+	totalCrossing := 0
+	for _, edge := range edges {
+		from, to := leafLookup[edge.Source], leafLookup[edge.Target]
+		if from != to {
+			crossing[from]++
+			totalCrossing++
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "leaf\trank\tvertices\tedges\tedges out\tmin x\tmin y\tmax x\tmax y\t")
+	for _, l := range leafList {
+		order, err := l.Graph.Order()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leaf %d: %v\n", l.ID, err)
+			return 1
+		}
+		size, err := l.Graph.Size()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leaf %d: %v\n", l.ID, err)
+			return 1
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%.5f\t%.5f\t%.5f\t%.5f\t\n", l.ID-1, l.ID, order, size, crossing[l.ID],
+			l.Bounds.MinX, l.Bounds.MinY, l.Bounds.MaxX, l.Bounds.MaxY)
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+
+	unassigned := 0
+	for _, v := range vertices {
+		if _, ok := leafLookup[v]; !ok {
+			unassigned++
+		}
+	}
+	fmt.Printf("\n%d of %d edges (%.1f%%) cross between leaves, vehicles on them are handed over by the root\n",
+		totalCrossing, len(edges), 100*float64(totalCrossing)/float64(len(edges)))
+	if unassigned > 0 {
+		fmt.Printf("%d vertices lie in no leaf\n", unassigned)
+	}
+	return 0
+}
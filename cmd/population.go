@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"pchpc_next/streets"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// vehicleFlags are the flags describing how the vehicle population is created
+type vehicleFlags struct {
+	n              *int
+	minSpeed       *float64
+	maxSpeed       *float64
+	demandPath     *string
+	departures     *string
+	populationPath *string
+	seed           *int64
+	classes        *string
+
+	fs *flag.FlagSet
+}
+
+func addVehicleFlags(fs *flag.FlagSet) *vehicleFlags {
+	return &vehicleFlags{
+		n:              fs.Int("n", 100, "Number of vehicles"),
+		minSpeed:       fs.Float64("min-speed", 5.5, "Minimum speed"),
+		maxSpeed:       fs.Float64("max-speed", 8.5, "Maximum speed"),
+		demandPath:     fs.String("demand", "", "Path to a json origin-destination matrix, replaces -n"),
+		departures:     fs.String("departures", "", "Departure profile for -n vehicles, e.g. uniform:0,3600 or peak:0,7200,3600,900"),
+		populationPath: fs.String("population", "", "Path to a population file written by 'population export', replaces -n and -demand"),
+		seed:           fs.Int64("seed", 0, "Seed for all random streams, a new seed is picked if not given"),
+		classes:        fs.String("classes", "", "Vehicle class mix, e.g. car:0.8,truck:0.1,bus:0.05,motorcycle:0.05, empty creates vehicles without a class"),
+		fs:             fs,
+	}
+}
+
+// seedGiven reports whether -seed was set on the command line or by a scenario, so that 0 is a seed like any other
+func (vf *vehicleFlags) seedGiven() bool {
+	given := false
+	vf.fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			given = true
+		}
+	})
+	return given
+}
+
+// createVehicles loads or generates the vehicle population on the root graph
+func (vf *vehicleFlags) createVehicles(rootGraph *streets.StreetGraph) ([]*streets.Vehicle, error) {
+	if !vf.seedGiven() {
+		*vf.seed = streets.NewSeed()
+	}
+	log.Info().Msgf("Using seed %d", *vf.seed)
+	// the population is generated with the streams of the root, independent of the world size
+	rootGraph.Random = streets.NewRandomSource(*vf.seed, 0)
+	if *vf.classes != "" {
+		mix, err := streets.ParseClassMix(*vf.classes)
+		if err != nil {
+			return nil, err
+		}
+		rootGraph.Classes = mix
+	}
+
+	if *vf.populationPath != "" {
+		vehicleList, err := rootGraph.LoadPopulationFile(*vf.populationPath)
+		if err != nil {
+			return nil, fmt.Errorf("population %s: %w", *vf.populationPath, err)
+		}
+		log.Info().Msgf("Starting vehicles %d from population %s", len(vehicleList), *vf.populationPath)
+		return vehicleList, nil
+	}
+
+	if *vf.demandPath != "" {
+		return vehiclesFromDemand(*vf.demandPath, rootGraph, vf.minSpeed, vf.maxSpeed)
+	}
+
+	ns := strconv.Itoa(*vf.n)
+	log.Info().Msg("Starting vehicles " + ns)
+
+	vehicleList := make([]*streets.Vehicle, *vf.n)
+
+	if connectVehiclesToGraph(vf.n, rootGraph, vf.minSpeed, vf.maxSpeed, vehicleList) {
+		return nil, errors.New("failed to add vehicle")
+	}
+
+	if *vf.departures != "" {
+		profile, err := streets.ParseDepartureProfile(*vf.departures)
+		if err != nil {
+			return nil, err
+		}
+		for _, vehicle := range vehicleList {
+			vehicle.SetDeparture(profile.Sample(rootGraph.Random.Departure))
+		}
+	}
+
+	return vehicleList, nil
+}
+
+func connectVehiclesToGraph(n *int, rootGraph *streets.StreetGraph, minSpeed *float64, maxSpeed *float64, vehicleList []*streets.Vehicle) bool {
+	for i := 0; i < *n; i++ {
+		v, err := rootGraph.AddVehicle(*minSpeed, *maxSpeed)
+		if err != nil {
+			return true
+		}
+		vehicleList[i] = v
+	}
+	return false
+}
+
+func vehiclesFromDemand(demandPath string, rootGraph *streets.StreetGraph, minSpeed *float64, maxSpeed *float64) ([]*streets.Vehicle, error) {
+	demand, err := streets.LoadDemandFile(demandPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Starting vehicles %d from demand %s", demand.TotalTrips(), demandPath)
+	return rootGraph.AddVehiclesFromDemand(demand, *minSpeed, *maxSpeed)
+}
+
+// populationCommand handles 'population export', which writes a generated population to a file
+func populationCommand(args []string) int {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprintf(os.Stderr, "usage: %s population export [flags]\n", os.Args[0])
+		return 2
+	}
+
+	fs := newFlagSet("population export", "Generate a vehicle population and write it to a file, which 'run -population' reads.")
+	vf := addVehicleFlags(fs)
+	jsonPath := fs.String("jsonPath", "assets/out.json", "Path to the json containing the graph data")
+	out := fs.String("o", "population.jsonl", "Path of the population file to write")
+	debug := fs.Bool("debug", false, "Enable debug mode")
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+
+	setupLogging(debug)
+
+	rootGraph, err := loadRootGraph(*jsonPath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build graph")
+		return 1
+	}
+
+	vehicleList, err := vf.createVehicles(rootGraph)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create vehicles")
+		return 1
+	}
+
+	if err := streets.WritePopulationFile(*out, vehicleList); err != nil {
+		log.Error().Err(err).Msg("Failed to write population")
+		return 1
+	}
+	log.Info().Msgf("Wrote %d vehicles to %s", len(vehicleList), *out)
+	return 0
+}
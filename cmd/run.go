@@ -0,0 +1,1088 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	mpi "github.com/sbromberger/gompi"
+	"os"
+	"os/signal"
+	"pchpc_next/streets"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// modes of the run command
+const (
+	modeSequential = "sequential"
+	modeGoroutines = "goroutines"
+	modeMPI        = "mpi"
+)
+
+// runFlags are the flags of the run command besides the population, the checkpoints and the scenario
+type runFlags struct {
+	mode              *string
+	jsonPath          *string
+	debug             *bool
+	timeScale         *float64
+	lookahead         *float64
+	tripsPath         *string
+	edgeStatsPath     *string
+	statsInterval     *float64
+	vizPort           *int
+	vizRate           *float64
+	transitPath       *string
+	transitReportPath *string
+	parkingPath       *string
+	parkingReportPath *string
+	metricsPort       *int
+	batchSize         *int
+	batchBytes        *int
+	batchDelay        *time.Duration
+	workers           *int
+	enginePoll        *time.Duration
+	phasesPath        *string
+}
+
+func addRunFlags(fs *flag.FlagSet) *runFlags {
+	return &runFlags{
+		mode:              fs.String("mode", modeSequential, "How vehicles are driven: sequential, goroutines or mpi (start with mpirun)"),
+		jsonPath:          fs.String("jsonPath", "assets/out.json", "Path to the json containing the graph data"),
+		debug:             fs.Bool("debug", false, "Enable debug mode"),
+		timeScale:         fs.Float64("time-scale", 0, "Simulated seconds per wall second, 0 releases vehicles as fast as possible"),
+		lookahead:         fs.Float64("lookahead", 60, "With -time-scale 0 vehicles step at most this many simulated seconds ahead of the slowest vehicle"),
+		tripsPath:         fs.String("trips", "", "Write a trip record per vehicle to this file, CSV if it ends in .csv, JSON Lines otherwise"),
+		edgeStatsPath:     fs.String("edge-stats", "", "Write flow, density, space occupancy and mean speed per edge and interval to this CSV file"),
+		statsInterval:     fs.Float64("stats-interval", 60, "Length of an -edge-stats interval in simulated seconds"),
+		vizPort:           fs.Int("viz-port", 0, "Serve a live visualisation of the run on this port of rank 0, 0 disables it"),
+		vizRate:           fs.Float64("viz-rate", 2, "Position updates per second of the visualisation"),
+		transitPath:       fs.String("transit", "", "Path to a json file of public transport lines, emitted in addition to the other vehicles"),
+		transitReportPath: fs.String("transit-report", "", "Write punctuality and run times per transit line to this CSV file"),
+		parkingPath:       fs.String("parking", "", "Path to a json file of parking facilities vehicles look for a spot in at their destination"),
+		parkingReportPath: fs.String("parking-report", "", "Write the occupancy per parking facility and -stats-interval to this CSV file"),
+		metricsPort:       fs.Int("metrics-port", 0, "Serve Prometheus metrics at /metrics on this port plus the rank, 0 disables the endpoint"),
+		batchSize:         fs.Int("batch-size", streets.DefaultBatchOptions.MaxVehicles, "Vehicles crossing between leaves are sent in batches of up to this many per destination, 1 sends each on its own"),
+		batchBytes:        fs.Int("batch-bytes", streets.DefaultBatchOptions.MaxBytes, "Send a batch once its vehicles take this many bytes, 0 ignores the size"),
+		batchDelay:        fs.Duration("batch-delay", streets.DefaultBatchOptions.MaxDelay, "Send every batch at least this often"),
+		workers:           fs.Int("workers", 0, "Vehicles of the goroutines mode and of every leaf are stepped by this many workers, 0 uses GOMAXPROCS"),
+		enginePoll:        fs.Duration("engine-poll", streets.DefaultEnginePoll, "Two goroutines per rank own the communicator, one sends and one probes for messages this often when idle, 0 calls MPI from every goroutine"),
+		phasesPath:        fs.String("phases", "", "Write the time of every phase and the time blocked in MPI calls per rank to this JSON file"),
+	}
+}
+
+// validate checks the flags that do not depend on each other's files
+func (rf *runFlags) validate(cf *checkpointFlags) error {
+	if *rf.mode != modeSequential && *rf.mode != modeGoroutines && *rf.mode != modeMPI {
+		return fmt.Errorf("unknown mode %q", *rf.mode)
+	}
+	if err := rf.batching().Validate(); err != nil {
+		return err
+	}
+	if *rf.enginePoll < 0 {
+		return errors.New("-engine-poll must not be negative")
+	}
+	if *rf.vizRate <= 0 || *rf.vizRate > maxVizRate {
+		return fmt.Errorf("-viz-rate must be positive and at most %g", maxVizRate)
+	}
+	if *rf.lookahead < 0 {
+		return errors.New("-lookahead must not be negative")
+	}
+	if (cf.enabled() || *cf.resume != "") && *rf.mode != modeMPI {
+		return errors.New("checkpoints require -mode mpi")
+	}
+	return nil
+}
+
+func (rf *runFlags) batching() streets.BatchOptions {
+	return streets.BatchOptions{MaxVehicles: *rf.batchSize, MaxBytes: *rf.batchBytes, MaxDelay: *rf.batchDelay}
+}
+
+// simulation is what every mode and rank of a run starts from
+type simulation struct {
+	*runFlags
+	fs *flag.FlagSet
+	vf *vehicleFlags
+	cf *checkpointFlags
+	sf *scenarioFlags
+
+	timer         *streets.PhaseTimer
+	halt          context.Context
+	clock         *streets.Clock
+	rootGraph     *streets.StreetGraph
+	vehicleList   []*streets.Vehicle
+	resumed       *streets.Checkpoint
+	transitReport *streets.TransitReport
+	parking       *streets.Parking
+}
+
+// runCommand handles 'run', which drives the vehicles sequentially, in goroutines or distributed over MPI ranks
+func runCommand(args []string) (code int) {
+	fs := newFlagSet("run", "Simulate the vehicles on the street graph.")
+	s := &simulation{
+		fs:       fs,
+		vf:       addVehicleFlags(fs),
+		cf:       addCheckpointFlags(fs),
+		sf:       addScenarioFlags(fs),
+		runFlags: addRunFlags(fs),
+	}
+	defer func() {
+		if code == 0 && s.sf.exceededWallLimit() {
+			code = 1
+		}
+	}()
+
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := s.sf.load(fs); err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if err := s.validate(s.cf); err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	setupLogging(s.debug)
+	s.timer = streets.NewPhaseTimer()
+	if err := s.sf.placeOutputs(fs, "trips", "edge-stats", "transit-report", "parking-report", "phases"); err != nil {
+		log.Error().Err(err).Msg("Failed to create the output directory")
+		return 1
+	}
+	s.halt = s.sf.startWallLimit()
+	if err := s.setup(); err != nil {
+		log.Error().Err(err).Msg("Failed to set up the run")
+		return 1
+	}
+
+	if *s.mode != modeMPI {
+		return s.runLocal()
+	}
+	return s.runMPI()
+}
+
+// setup loads the graph, creates or resumes the vehicles and loads the parking facilities
+func (s *simulation) setup() error {
+	s.timer.Start(streets.PhaseGraphLoad)
+	rootGraph, err := loadRootGraph(*s.jsonPath)
+	if err != nil {
+		return fmt.Errorf("build graph: %w", err)
+	}
+	rootGraph.Until = *s.sf.until
+	rootGraph.Halt = s.halt
+	s.rootGraph = rootGraph
+	s.timer.Stop(streets.PhaseGraphLoad)
+
+	// Create vehicles and drive
+	s.timer.Start(streets.PhaseVehicles)
+	s.clock = streets.NewClock(*s.timeScale)
+	if *s.cf.resume != "" {
+		s.resumed, s.vehicleList, err = resumeVehicles(*s.cf.resume, rootGraph)
+		if err != nil {
+			return fmt.Errorf("resume: %w", err)
+		}
+		*s.vf.seed = s.resumed.Root.Seed
+		s.clock = streets.NewClockAt(*s.timeScale, s.resumed.Root.Clock)
+	} else {
+		s.vehicleList, err = s.vf.createVehicles(rootGraph)
+		if err != nil {
+			return fmt.Errorf("create vehicles: %w", err)
+		}
+		if *s.transitPath != "" {
+			transitVehicles, err := addTransitVehicles(*s.transitPath, rootGraph)
+			if err != nil {
+				return fmt.Errorf("create transit vehicles: %w", err)
+			}
+			s.vehicleList = append(s.vehicleList, transitVehicles...)
+		}
+	}
+	s.vehicleList = s.sf.dropLateDepartures(s.vehicleList)
+	s.timer.Stop(streets.PhaseVehicles)
+	s.transitReport = newTransitReport(*s.transitReportPath, s.resumed != nil)
+
+	if *s.edgeStatsPath != "" {
+		if err := rootGraph.EnableEdgeStats(*s.statsInterval); err != nil {
+			return fmt.Errorf("enable edge stats: %w", err)
+		}
+	}
+
+	s.parking, err = loadParking(*s.parkingPath, *s.parkingReportPath)
+	if err != nil {
+		return fmt.Errorf("load parking facilities: %w", err)
+	}
+	return nil
+}
+
+// runLocal drives the vehicles sequentially or in goroutines of a single process
+func (s *simulation) runLocal() int {
+	log.Info().Msgf("Running %s without MPI", *s.mode)
+	if err := s.sf.writeResolved(s.fs); err != nil {
+		log.Warn().Err(err).Msg("Skipped writing the resolved scenario")
+	}
+	if s.parking != nil {
+		if err := s.rootGraph.EnableParking(*s.parking); err != nil {
+			log.Error().Err(err).Msg("Failed to enable parking")
+			return 1
+		}
+	}
+	metrics := streets.NewMetrics(0)
+	metrics.SetClock(s.clock)
+	serveMetrics(*s.metricsPort, metrics, 0)
+	tracker := newVehicleTracker(*s.vizPort != 0, 0, s.clock)
+	serveViz(*s.vizPort, *s.vizRate, s.rootGraph, nil, tracker)
+	tripWriter, err := openTripWriter(*s.tripsPath)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create trip record file")
+		return 1
+	}
+	s.timer.Start(streets.PhaseSimulation)
+	var utilisation []float64
+	if *s.mode == modeGoroutines {
+		utilisation = runWithGoRoutines(s.halt, s.clock, *s.lookahead, s.vehicleList, tripWriter, s.transitReport, metrics, tracker, s.timer, *s.workers)
+		logUtilisation(0, utilisation)
+	} else {
+		runSequentially(s.vehicleList, tripWriter, s.transitReport, metrics, tracker)
+	}
+	s.timer.Stop(streets.PhaseSimulation)
+	s.timer.Start(streets.PhaseShutdown)
+	closeTripWriter(tripWriter)
+	writeTransitReport(*s.transitReportPath, s.transitReport)
+
+	if *s.edgeStatsPath != "" {
+		rows, err := s.rootGraph.EdgeStatsTable()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to collect edge stats")
+			return 1
+		}
+		writeEdgeStats(*s.edgeStatsPath, rows, *s.statsInterval)
+	}
+	writeParkingReport(*s.parkingReportPath, s.rootGraph.Parking.Facilities(), s.rootGraph.Parking.Events(), *s.statsInterval)
+	s.timer.Stop(streets.PhaseShutdown)
+	rp := s.timer.Rank(0)
+	rp.Workers = utilisation
+	writePhaseReport(*s.phasesPath, streets.NewPhaseReport(*s.mode, 1, len(s.vehicleList), []streets.RankPhases{rp}))
+	return 0
+}
+
+// runMPI partitions the graph on every rank and runs the root on rank 0 and a leaf on every other rank
+func (s *simulation) runMPI() int {
+	mpi.Start(true)
+	defer mpi.Stop()
+
+	comm := mpi.NewCommunicator(nil)
+
+	taskID := comm.Rank()
+
+	if mpi.WorldSize() < 2 {
+		log.Error().Msg("World size is less than 2")
+		return 1
+	}
+
+	// I.3 every process will divide the graph into rectangles
+	s.timer.Start(streets.PhasePartition)
+	leafList, err := s.partition(taskID, mpi.WorldSize()-1)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%d] Failed to setup leaf", taskID)
+		return 1
+	}
+
+	log.Info().Msgf("[%d] Leaf list length: %d", taskID, len(leafList))
+	leafLookup, err := buildLeafLookup(s.rootGraph, leafList)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges")
+		return 1
+	}
+	s.timer.Stop(streets.PhasePartition)
+
+	comm.Barrier()
+	log.Info().Msgf("[%d] PID: %d", taskID, os.Getpid())
+	if taskID == 0 {
+		return s.runRoot(comm, leafList, leafLookup)
+	}
+	return s.runLeaf(comm, taskID, leafList)
+}
+
+// partition splits the graph into a leaf per rank other than the root
+func (s *simulation) partition(taskID int, rectangularSplits int) ([]*streets.StreetGraph, error) {
+	leafList := make([]*streets.StreetGraph, 0)
+	for rank := 1; rank <= rectangularSplits; rank++ {
+		log.Debug().Msgf("[%d] Setting up leaf (WorldSize: %d)", taskID, rectangularSplits+1)
+
+		// rank means taskID
+		l, err := setupLeaf(s.jsonPath, s.rootGraph, rectangularSplits, rank, rank)
+		if err != nil {
+			return nil, err
+		}
+		l.Random = streets.NewRandomSource(*s.vf.seed, rank)
+		l.Until = *s.sf.until
+		if *s.edgeStatsPath != "" {
+			if err := l.EnableEdgeStats(*s.statsInterval); err != nil {
+				return nil, fmt.Errorf("enable edge stats: %w", err)
+			}
+		}
+		if s.parking != nil {
+			if err := l.EnableParking(*s.parking); err != nil {
+				return nil, fmt.Errorf("enable parking: %w", err)
+			}
+			if s.resumed != nil {
+				l.Parking.Restore(s.resumed.Parking)
+			}
+		}
+		leafList = append(leafList, l)
+	}
+	return leafList, nil
+}
+
+// newRankMPI sets up the messaging of a rank and starts its communication engine if -engine-poll is set
+func (s *simulation) newRankMPI(comm *mpi.Communicator, taskID int, metrics *streets.Metrics) (*streets.MPI, error) {
+	m := streets.NewMPI(taskID, *comm, s.rootGraph)
+	metrics.SetClock(s.clock)
+	m.SetMetrics(metrics)
+	m.SetPhaseTimer(s.timer)
+	if err := m.SetBatching(s.batching()); err != nil {
+		return nil, fmt.Errorf("batch vehicles: %w", err)
+	}
+	if *s.enginePoll > 0 {
+		if err := m.StartEngine(*s.enginePoll); err != nil {
+			return nil, fmt.Errorf("start the communication engine: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// logPID keeps logging the process id of the rank
+func logPID(taskID int) {
+	for {
+		pid := os.Getpid()
+		log.Info().Msgf("[%d] PID: %d", taskID, pid)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// runRoot releases the vehicles to the leaves, forwards them between leaves and collects their trip records
+func (s *simulation) runRoot(comm *mpi.Communicator, leafList []*streets.StreetGraph, leafLookup map[int]int) int {
+	const taskID = 0
+	size, err := s.rootGraph.Graph.Size()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get size of graph")
+		return 1
+	}
+	log.Info().Msgf("[0] Number of vertices: %d", size)
+	if err := s.sf.writeResolved(s.fs); err != nil {
+		log.Warn().Err(err).Msg("Skipped writing the resolved scenario")
+	}
+	metrics := streets.NewMetrics(taskID)
+	// the listeners, the release and the checkpoints of the root share the communicator
+	m, err := s.newRankMPI(comm, taskID, metrics)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%d] Failed to set up the root", taskID)
+		return 1
+	}
+
+	go logPID(taskID)
+
+	// I.5 root process will listen for incoming requests
+	go func() {
+		err, _ := ListenForLengthRequest(nil, m)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to listen for length request")
+		}
+	}()
+
+	log.Info().Msgf("[%d] Waiting for length request", taskID)
+
+	// vehicles between leaves are lost once forwarding stops, the trip records are not waited for anymore
+	forwardFailed := make(chan struct{})
+	go func() {
+		if ListenForReceiveAndSendRequest(nil, m, leafLookup) {
+			close(forwardFailed)
+		}
+	}()
+	log.Info().Msgf("[%d] Waiting for receive and send request", taskID)
+
+	// I.6 root process collects the trip records until every vehicle is parked
+	var tripWriter *streets.TripWriter
+	if s.resumed != nil && *s.tripsPath != "" {
+		tripWriter, err = streets.ResumeTripWriter(*s.tripsPath, s.resumed.Root.Parked)
+	} else {
+		tripWriter, err = openTripWriter(*s.tripsPath)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create trip record file")
+		return 1
+	}
+
+	release := newReleaseTracker()
+	metrics.Queue("release", func() int {
+		return len(s.vehicleList) - release.count()
+	})
+	serveMetrics(*s.metricsPort, metrics, taskID)
+	if tracker := newVehicleTracker(*s.vizPort != 0, taskID, s.clock); tracker != nil {
+		serveViz(*s.vizPort, *s.vizRate, s.rootGraph, leafList, tracker)
+		go listenForPositions(m, tracker)
+	}
+	cp := &rootCheckpointer{
+		m:          m,
+		dir:        *s.cf.dir,
+		leaves:     len(leafList),
+		seed:       *s.vf.seed,
+		clock:      s.clock,
+		vehicles:   s.vehicleList,
+		release:    release,
+		tripWriter: tripWriter,
+		exited:     make(chan struct{}),
+	}
+	if s.resumed != nil {
+		cp.previouslyParked = s.resumed.Root.Parked
+		cp.edgeStats = s.resumed.EdgeStats
+	}
+	if s.cf.enabled() {
+		go cp.run(*s.cf.every)
+	}
+
+	// I.4 root process will emit vehicles as the clock reaches their departure
+	s.timer.Start(streets.PhaseEmission)
+	s.timer.Start(streets.PhaseSimulation)
+	go s.releaseVehicles(m, metrics, release, leafLookup)
+
+	collected := make(chan error, 1)
+	var dropped atomic.Int64
+	go func() {
+		collected <- collectTripRecords(m, tripWriter, s.transitReport, len(s.vehicleList), &cp.parked, &dropped)
+	}()
+	select {
+	case err = <-collected:
+	case <-forwardFailed:
+		err = errVehiclesLost
+	case <-cp.exited:
+	}
+	s.timer.Stop(streets.PhaseSimulation)
+	s.timer.Start(streets.PhaseShutdown)
+	checkpointed := false
+	if s.cf.enabled() {
+		var finishErr error
+		checkpointed, finishErr = cp.finish()
+		if finishErr != nil {
+			log.Error().Err(finishErr).Msg("Failed to finish checkpoints")
+			return 1
+		}
+	}
+	closeTripWriter(tripWriter)
+	exitCode := 0
+	if checkpointed {
+		log.Info().Msgf("[%d] Run ended with a checkpoint in %s", taskID, *s.cf.dir)
+	} else {
+		if err != nil && !errors.Is(err, errVehiclesLost) {
+			log.Error().Err(err).Msg("Failed to receive trip record")
+			return 1
+		}
+		if missing := len(s.vehicleList) - int(cp.parked.Load()); missing > 0 {
+			log.Error().Msgf("[%d] %d of %d trips are missing, %d vehicles were dropped by the leaves", taskID, missing, len(s.vehicleList), dropped.Load())
+			exitCode = 1
+		} else {
+			log.Info().Msgf("[%d] All %d vehicles are parked", taskID, len(s.vehicleList))
+		}
+		writeTransitReport(*s.transitReportPath, s.transitReport)
+	}
+
+	if err := m.StopLeaves(); err != nil {
+		log.Error().Err(err).Msg("Failed to stop leaves")
+		return 1
+	}
+	// the reports of the leaves and the collectives of the shutdown use the communicator directly
+	m.StopEngine()
+
+	if code := s.receiveReports(m, cp, checkpointed); code != 0 {
+		return code
+	}
+	return exitCode
+}
+
+// releaseVehicles emits the vehicles to their leaves as the clock reaches their departure
+func (s *simulation) releaseVehicles(m *streets.MPI, metrics *streets.Metrics, release *releaseTracker, leafLookup map[int]int) {
+	defer s.timer.Stop(streets.PhaseEmission)
+	err := streets.ReleaseVehicles(s.halt, s.clock, s.vehicleList, release.wrap(func(vehicle *streets.Vehicle) error {
+		if err := m.EmitVehicle(*vehicle, leafLookup); err != nil {
+			return err
+		}
+		metrics.VehicleReleased()
+		return nil
+	}))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to emit vehicle")
+		return
+	}
+	// the last vehicles do not wait for the periodic flush of their batches
+	m.FlushVehicles()
+	log.Info().Msgf("[0] Released all %d vehicles", len(s.vehicleList))
+}
+
+// receiveReports collects the edge stats, parking events and phase timings of the leaves and writes them
+func (s *simulation) receiveReports(m *streets.MPI, cp *rootCheckpointer, checkpointed bool) int {
+	if *s.edgeStatsPath != "" && !checkpointed {
+		rows, err := m.ReceiveEdgeStats()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to receive edge stats")
+			return 1
+		}
+		writeEdgeStats(*s.edgeStatsPath, streets.MergeEdgeIntervals(rows, cp.edgeStats), *s.statsInterval)
+	}
+	if *s.parkingReportPath != "" && !checkpointed {
+		events, err := m.ReceiveParking()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to receive parking events")
+			return 1
+		}
+		writeParkingReport(*s.parkingReportPath, s.parking.Facilities, events, *s.statsInterval)
+	}
+	m.BCastDone() // IV
+	s.timer.Stop(streets.PhaseShutdown)
+	if *s.phasesPath != "" {
+		ranks, err := m.ReceivePhases()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to receive phase timings")
+			return 1
+		}
+		ranks = append(ranks, s.timer.Rank(0))
+		writePhaseReport(*s.phasesPath, streets.NewPhaseReport(*s.mode, mpi.WorldSize(), len(s.vehicleList), ranks))
+	}
+	return 0
+}
+
+// leafRun is the state of a leaf while it drives the vehicles that entered it
+type leafRun struct {
+	*simulation
+	taskID  int
+	leaf    *streets.StreetGraph
+	m       *streets.MPI
+	metrics *streets.Metrics
+	sched   *streets.Scheduler
+	gate    *streets.CheckpointGate
+	tracker *vehicleTracker
+	// internalWG counts the vehicles on the leaf
+	internalWG    sync.WaitGroup
+	receiveFailed atomic.Bool
+}
+
+// runLeaf drives the vehicles of a leaf until the root stops it, then sends the reports of the leaf
+func (s *simulation) runLeaf(comm *mpi.Communicator, taskID int, leafList []*streets.StreetGraph) int {
+	log.Info().Msgf("[%d] Starting leaf", taskID)
+	metrics := streets.NewMetrics(taskID)
+	m, err := s.newRankMPI(comm, taskID, metrics)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%d] Failed to set up the leaf", taskID)
+		return 1
+	}
+	l := &leafRun{
+		simulation: s,
+		taskID:     taskID,
+		leaf:       leafList[taskID-1],
+		m:          m,
+		metrics:    metrics,
+		sched:      streets.NewScheduler(*s.workers),
+		gate:       &streets.CheckpointGate{},
+	}
+	metrics.Queue("run_queue", l.sched.Queued)
+	metrics.Queue("paced", l.sched.Waiting)
+	l.leaf.Halt = s.halt
+	// TODO: barrier leafs here
+	// new comm with ranks > 0
+	size, err := l.leaf.Graph.Size()
+	if err != nil {
+		log.Error().Err(err).Msgf("[%d] Failed to get size of graph", taskID)
+		return 1
+	}
+	log.Info().Msgf("[%d] Starting leaf size: %d", taskID, size)
+
+	metrics.Queue("checkpoint_held", l.gate.Held)
+	serveMetrics(*s.metricsPort, metrics, taskID)
+
+	l.tracker = newVehicleTracker(*s.vizPort != 0, taskID, s.clock)
+	paceVehicles(l.sched, s.clock, *s.lookahead, l.tracker)
+	stopSampling := make(chan struct{})
+	var samplingWG sync.WaitGroup
+	if l.tracker != nil {
+		samplingWG.Add(1)
+		go samplePositions(m, l.tracker, *s.vizRate, stopSampling, &samplingWG)
+	}
+
+	go logPID(taskID)
+
+	if s.cf.enabled() {
+		// the root coordinates the checkpoint, the leaves must keep running until it is written
+		signal.Ignore(syscall.SIGTERM, syscall.SIGINT)
+		go listenForCheckpoint(m, l.gate, taskID, func() ([]streets.EdgeInterval, error) {
+			return leafEdgeStats(l.leaf, s.rootGraph)
+		}, l.leaf.Parking, l.enter)
+	}
+
+	s.timer.Start(streets.PhaseSimulation)
+	l.receive()
+	// the vehicles on the leaf are done once it stopped receiving, any other vehicle is reported as dropped
+	for _, id := range l.sched.Close() {
+		log.Error().Msgf("[%d] Vehicle %s was not done when the leaf stopped", taskID, id)
+		dropVehicle(m, taskID, streets.TripRecord{ID: id})
+	}
+	logUtilisation(taskID, l.sched.Utilisation())
+	m.CloseBatching()
+	s.timer.Stop(streets.PhaseSimulation)
+	s.timer.Start(streets.PhaseShutdown)
+	close(stopSampling)
+	samplingWG.Wait()
+	m.StopEngine()
+
+	if code := l.sendReports(); code != 0 {
+		return code
+	}
+	if l.receiveFailed.Load() {
+		// the run is finished with the other ranks, so that the root does not wait for this leaf
+		return 1
+	}
+	return 0
+}
+
+// enter starts a vehicle the leaf received on the edge it crossed into the leaf with
+func (l *leafRun) enter(vehicleOnLeaf streets.Vehicle) error {
+	vehicleOnLeaf.StreetGraph = l.leaf // II.5.1
+	// a vehicle held by a checkpoint enters the same leaf again
+	if n := len(vehicleOnLeaf.Leaves); n == 0 || vehicleOnLeaf.Leaves[n-1] != l.taskID {
+		vehicleOnLeaf.Leaves = append(vehicleOnLeaf.Leaves, l.taskID)
+	}
+
+	log.Debug().Msgf("[%d] Received vehicle on leaf: %s, %d->%d", l.taskID, vehicleOnLeaf.ID, vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID)
+	vehicleOnLeaf.MarkedForDeletion = false // II.3
+
+	length, err := l.m.AskRootForEdgeLength(vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID) // II.4
+	if err != nil {
+		return err
+	}
+	// II.5 the vehicle entered the edge at the time it was sent with and drives it up to the leaf
+	enterTime, exitTime := vehicleOnLeaf.CrossEdge(length)
+	// the edge between two leaves only exists in the root graph
+	_ = l.rootGraph.RecordTraversal(vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID, enterTime, exitTime, vehicleOnLeaf.Length())
+	l.internalWG.Add(1)
+	l.gate.Started()
+	l.metrics.VehicleStarted()
+	step, at := leafVehicleStep(vehicleOnLeaf, l.taskID, l.m, l.gate, l.tracker, &l.internalWG)
+	l.sched.SubmitAt(vehicleOnLeaf.ID, step, at)
+	return nil
+}
+
+// receive starts the vehicles sent to the leaf until the root stops it. Every vehicle that cannot be started
+// is reported as dropped, by its ID where it is known. Once receiving fails the leaf waits for its vehicles.
+func (l *leafRun) receive() {
+	defer l.internalWG.Wait()
+	for {
+		vehicles, err := l.m.ReceiveVehiclesOnLeaf() // II.1 & II.2
+		if errors.Is(err, streets.ErrStopped) {
+			log.Info().Msgf("[%d] II Received stop signal", l.taskID)
+			return
+		}
+		var batchErr *streets.BatchError
+		if errors.As(err, &batchErr) {
+			log.Error().Err(err).Msgf("[%d] Failed to decode vehicles on leaf", l.taskID)
+			l.receiveFailed.Store(true)
+			for _, id := range batchErr.Lost {
+				// without an ID the root learns that it cannot wait for every vehicle
+				dropVehicle(l.m, l.taskID, streets.TripRecord{ID: id})
+			}
+		} else if err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to receive vehicle on leaf", l.taskID)
+			l.receiveFailed.Store(true)
+			// the vehicles of the message are unknown, the root cannot wait for them
+			dropVehicle(l.m, l.taskID, streets.TripRecord{})
+			if errors.Is(err, streets.ErrMalformedInput) {
+				continue
+			}
+			return
+		}
+		for _, vehicleOnLeaf := range vehicles {
+			if l.gate.IsPaused() {
+				l.gate.Hold(vehicleOnLeaf)
+				continue
+			}
+			if err := l.enter(vehicleOnLeaf); err != nil {
+				log.Error().Err(err).Msgf("[%d] Failed to ask root for edge length", l.taskID)
+				dropVehicle(l.m, l.taskID, vehicleOnLeaf.TripRecord())
+				if !errors.Is(err, streets.ErrMissingEdge) {
+					l.receiveFailed.Store(true)
+				}
+			}
+		}
+	}
+}
+
+// sendReports sends the edge stats, parking events and phase timings of the leaf to the root
+func (l *leafRun) sendReports() int {
+	if *l.edgeStatsPath != "" && !l.gate.IsExiting() {
+		if err := sendEdgeStats(l.m, l.leaf, l.rootGraph); err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send edge stats", l.taskID)
+			return 1
+		}
+	}
+	if *l.parkingReportPath != "" && !l.gate.IsExiting() {
+		if err := l.m.SendParkingToRoot(l.leaf.Parking.Events()); err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send parking events", l.taskID)
+			return 1
+		}
+	}
+
+	log.Debug().Msgf("[%d] Waiting for stop signal", l.taskID)
+	l.m.BCastDone() // IV
+	log.Debug().Msgf("[%d] I Received stop signal", l.taskID)
+	l.timer.Stop(streets.PhaseShutdown)
+	if *l.phasesPath != "" {
+		rp := l.timer.Rank(l.taskID)
+		rp.Workers = l.sched.Utilisation()
+		if err := l.m.SendPhasesToRoot(rp); err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send phase timings", l.taskID)
+			return 1
+		}
+	}
+	return 0
+}
+
+func ListenForParking(m *streets.MPI, incrementor *int, taskID int) {
+	for {
+		m.ReceiveDoneFromLeaf(incrementor)
+		log.Info().Msgf("[%d] Received done from leaf", taskID)
+		m.BCastDone()
+	}
+}
+
+func ListenForReceiveAndSendRequest(err error, m *streets.MPI, lookupTable map[int]int) bool {
+	for {
+		// I.5.b root process will listen for incoming vehicles and send them to the leaf
+		err = m.ReceiveAndSendVehicleOverRoot(lookupTable)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to receive vehicle on root from leaf")
+			return true
+		}
+	}
+}
+
+// ListenForLengthRequest answers edge length requests of the leaves. A request for a missing edge or a malformed
+// request is logged and the next one is answered, any other error stops listening and is returned.
+func ListenForLengthRequest(err error, m *streets.MPI) (error, bool) {
+	//for i := 1; i < mpi.WorldSize(); i++ {
+	//	go func(fromID int) {
+	for {
+		// I.5.a root process will listen for incoming requests for edge length
+		err = m.RespondToEdgeLengthRequest()
+		if errors.Is(err, streets.ErrMissingEdge) || errors.Is(err, streets.ErrMalformedInput) {
+			log.Error().Err(err).Msg("Failed to respond to edge length request")
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to respond to edge length request")
+			return err, true
+		}
+	}
+	//	}(i)
+	//}
+	//select {}
+}
+
+// leafVehicleStep returns the step and the time of a vehicle that entered the leaf for the scheduler of the leaf
+func leafVehicleStep(vehicleOnLeaf streets.Vehicle, taskID int, m *streets.MPI, gate *streets.CheckpointGate, tracker *vehicleTracker, wg *sync.WaitGroup) (streets.StepFunc, streets.TimeFunc) {
+	// update nodes after graph transition II.5.2 -> shift the array
+	log.Debug().Msgf("[%d] I driveVehicle() Driving vehicle %s %d->%d ", taskID, vehicleOnLeaf.ID, vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID)
+	// the vehicle parks if the edge into the leaf was the last one of the path
+	vehicleOnLeaf.AdvanceToNext()
+	log.Debug().Msgf("[%d] II driveVehicle() Driving vehicle %s %d->%d ", taskID, vehicleOnLeaf.ID, vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID)
+
+	step := func() bool {
+		if !stepLeafVehicle(&vehicleOnLeaf, taskID, m, gate, tracker) {
+			return false
+		}
+		m.Metrics().VehicleFinished()
+		tracker.remove(vehicleOnLeaf.ID)
+		gate.Finished()
+		wg.Done()
+		return true
+	}
+	return step, func() float64 {
+		return vehicleOnLeaf.Time
+	}
+}
+
+// stepLeafVehicle drives one step of a vehicle on a leaf and reports whether it has left the leaf
+func stepLeafVehicle(vehicleOnLeaf *streets.Vehicle, taskID int, m *streets.MPI, gate *streets.CheckpointGate, tracker *vehicleTracker) bool {
+	if vehicleOnLeaf.IsParked { // II.7.1
+		log.Info().Msgf("[%d]-II.10 Vehicle %s is parked", taskID, vehicleOnLeaf.ID) // II.10
+		m.Metrics().VehicleParked()
+		err := m.SendTripRecordToRoot(vehicleOnLeaf.TripRecord())
+		if err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send trip record to root", taskID)
+			dropVehicle(m, taskID, streets.TripRecord{ID: vehicleOnLeaf.ID})
+			return true
+		}
+		log.Debug().Msgf("[%d] Sent trip record to root", taskID)
+		return true
+	} else if vehicleOnLeaf.MarkedForDeletion { // II.7.2
+		log.Debug().Msgf("[%d] Vehicle %s is marked for deletion", taskID, vehicleOnLeaf.ID)
+		err := m.SendVehicleToRoot(*vehicleOnLeaf) // II.9
+		log.Debug().Msgf("[%d] Sent vehicle %s to root %d->%d", taskID, vehicleOnLeaf.ID, vehicleOnLeaf.PrevID, vehicleOnLeaf.NextID)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to send vehicle to root", taskID)
+			dropVehicle(m, taskID, vehicleOnLeaf.TripRecord())
+		}
+		return true
+	} else if gate.IsPaused() {
+		// a checkpoint is taken, the vehicle is emitted again from its current edge
+		log.Debug().Msgf("[%d] Holding vehicle %s for checkpoint", taskID, vehicleOnLeaf.ID)
+		gate.Hold(*vehicleOnLeaf)
+		return true
+	} else if vehicleOnLeaf.StopAtLimit() {
+		// parked where it is, the trip record is sent in the next step
+		return false
+	}
+	if err := vehicleOnLeaf.Step(); err != nil { // II.8
+		// the vehicle cannot drive on, it is parked where it is and its trip record is sent in the next step
+		log.Error().Err(err).Msgf("[%d] Failed to step vehicle %s", taskID, vehicleOnLeaf.ID)
+		vehicleOnLeaf.IsParked = true
+		return false
+	}
+	tracker.step(vehicleOnLeaf)
+	return false
+}
+
+// dropVehicle tells the root that a vehicle is lost, so that it does not wait for its trip record.
+// A record without an ID stands for vehicles the leaf lost without knowing them.
+func dropVehicle(m *streets.MPI, taskID int, record streets.TripRecord) {
+	record.Dropped = true
+	if record.Leaves == nil {
+		record.Leaves = []int{}
+	}
+	if err := m.SendTripRecordToRoot(record); err != nil {
+		log.Error().Err(err).Msgf("[%d] Failed to report dropped vehicle %s to root", taskID, record.ID)
+	}
+}
+
+// buildLeafLookup maps every vertex of an edge to the leaf it lies in
+func buildLeafLookup(rootGraph *streets.StreetGraph, leafList []*streets.StreetGraph) (map[int]int, error) {
+	var leafLookup = make(map[int]int) // [vertexID] => leafID
+	edges, err := rootGraph.Graph.Edges()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, graph := range leafList {
+		for _, edge := range edges {
+			src := edge.Source
+			dest := edge.Target
+			if graph.VertexExists(src) {
+				leafLookup[src] = graph.ID
+			}
+			if graph.VertexExists(dest) {
+				leafLookup[dest] = graph.ID
+			}
+		}
+	}
+	return leafLookup, nil
+}
+
+func setupLeaf(jsonPath *string, rootGraph *streets.StreetGraph, rectangularSplits int, i int, taskID int) (*streets.StreetGraph, error) {
+	log.Debug().Msgf("[%d] i=%d", taskID, i)
+	gb := streets.NewGraphBuilder().FromJsonFile(*jsonPath).IsLeaf(rootGraph, taskID).NumberOfRects(rectangularSplits)
+	gb = gb.PickRect(i - 1).DivideGraphsIntoRects().FilterForRect()
+	gb = gb.SetTopRightBottomLeftVertices()
+	leafGraph, err := gb.Build()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build graph")
+		return nil, err
+	}
+	size, err := leafGraph.Graph.Size()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get size of graph")
+		return nil, err
+	}
+	log.Info().Msgf("[%d] Number of vertices: %d", taskID, size)
+	return leafGraph, nil
+}
+
+// runWithGoRoutines steps the released vehicles on a pool of workers and returns their utilisation
+func runWithGoRoutines(halt context.Context, clock *streets.Clock, lookahead float64, vehicleList []*streets.Vehicle, tripWriter *streets.TripWriter, transitReport *streets.TransitReport, metrics *streets.Metrics, tracker *vehicleTracker, timer *streets.PhaseTimer, workers int) []float64 {
+	sched := streets.NewScheduler(workers)
+	paceVehicles(sched, clock, lookahead, tracker)
+	metrics.Queue("run_queue", sched.Queued)
+	metrics.Queue("paced", sched.Waiting)
+	timer.Start(streets.PhaseEmission)
+	metrics.Queue("release", func() int {
+		return len(vehicleList) - int(metrics.Released())
+	})
+	onStep := tracker.onStep()
+	err := streets.ReleaseVehicles(halt, clock, vehicleList, func(vehicle *streets.Vehicle) error {
+		metrics.VehicleReleased()
+		metrics.VehicleStarted()
+		sched.SubmitAt(vehicle.ID, func() bool {
+			done, err := vehicle.DriveStep(onStep)
+			if err != nil {
+				// the vehicle cannot drive on, it is parked where it is
+				log.Error().Err(err).Msgf("Failed to step vehicle %s", vehicle.ID)
+				vehicle.IsParked = true
+			} else if !done {
+				return false
+			}
+			metrics.VehicleFinished()
+			metrics.VehicleParked()
+			writeTripRecord(tripWriter, vehicle)
+			transitReport.Add(vehicle.TripRecord())
+			return true
+		}, func() float64 {
+			return vehicle.Time
+		})
+		return nil
+	})
+	timer.Stop(streets.PhaseEmission)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to release vehicles")
+	}
+	sched.Wait()
+	sched.Close()
+	return sched.Utilisation()
+}
+
+// paceVehicles makes the vehicles of sched follow the clock. A virtual clock always applies the departure
+// times, a realtime clock only paces vehicles that are followed in the browser.
+func paceVehicles(sched *streets.Scheduler, clock *streets.Clock, lookahead float64, tracker *vehicleTracker) {
+	if clock.IsRealtime() && tracker == nil {
+		return
+	}
+	sched.Pace(clock, lookahead)
+}
+
+// logUtilisation logs the share of time every worker of a rank spent stepping vehicles
+func logUtilisation(taskID int, utilisation []float64) {
+	shares := make([]string, len(utilisation))
+	for i, u := range utilisation {
+		shares[i] = fmt.Sprintf("%.0f%%", 100*u)
+	}
+	log.Info().Msgf("[%d] Utilisation of %d workers: %s", taskID, len(utilisation), strings.Join(shares, " "))
+}
+
+func runSequentially(vehicleList []*streets.Vehicle, tripWriter *streets.TripWriter, transitReport *streets.TransitReport, metrics *streets.Metrics, tracker *vehicleTracker) {
+	// every vehicle keeps its own time, so driving them one after another in departure order is enough
+	queue := make([]*streets.Vehicle, len(vehicleList))
+	copy(queue, vehicleList)
+	streets.SortByDeparture(queue)
+	for _, vehicle := range queue {
+		metrics.VehicleReleased()
+		metrics.VehicleStarted()
+		if err := vehicle.DriveWith(tracker.onPacedStep()); err != nil {
+			// the vehicle cannot drive on, it is parked where it is
+			log.Error().Err(err).Msgf("Failed to drive vehicle %s", vehicle.ID)
+			vehicle.IsParked = true
+		}
+		metrics.VehicleFinished()
+		metrics.VehicleParked()
+		writeTripRecord(tripWriter, vehicle)
+		transitReport.Add(vehicle.TripRecord())
+	}
+}
+
+// openTripWriter creates the trip record file, nil if no path is given
+func openTripWriter(path string) (*streets.TripWriter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return streets.NewTripWriter(path)
+}
+
+func writeTripRecord(tripWriter *streets.TripWriter, vehicle *streets.Vehicle) {
+	if tripWriter == nil {
+		return
+	}
+	if err := tripWriter.Write(vehicle.TripRecord()); err != nil {
+		log.Error().Err(err).Msgf("Failed to write trip record of %s", vehicle.ID)
+	}
+}
+
+func closeTripWriter(tripWriter *streets.TripWriter) {
+	if tripWriter == nil {
+		return
+	}
+	if err := tripWriter.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close trip record file")
+		return
+	}
+	log.Info().Msgf("Wrote %d trip records", tripWriter.Count())
+}
+
+func writeEdgeStats(path string, rows []streets.EdgeInterval, interval float64) {
+	if err := streets.WriteEdgeStatsFile(path, rows, interval); err != nil {
+		log.Error().Err(err).Msg("Failed to write edge stats")
+		return
+	}
+	log.Info().Msgf("Wrote %d edge intervals", len(rows))
+}
+
+// leafEdgeStats returns the edges driven on the leaf and the edges crossed into it
+func leafEdgeStats(leaf *streets.StreetGraph, rootGraph *streets.StreetGraph) ([]streets.EdgeInterval, error) {
+	leafRows, err := leaf.EdgeStatsTable()
+	if err != nil {
+		return nil, err
+	}
+	crossingRows, err := rootGraph.EdgeStatsTable()
+	if err != nil {
+		return nil, err
+	}
+	return streets.MergeEdgeIntervals(leafRows, crossingRows), nil
+}
+
+// sendEdgeStats sends the edge stats of the leaf to the root
+func sendEdgeStats(m *streets.MPI, leaf *streets.StreetGraph, rootGraph *streets.StreetGraph) error {
+	rows, err := leafEdgeStats(leaf, rootGraph)
+	if err != nil {
+		return err
+	}
+	return m.SendEdgeStatsToRoot(rows)
+}
+
+// errVehiclesLost ends the collection of trip records once a leaf lost vehicles it cannot name
+var errVehiclesLost = errors.New("a leaf lost vehicles")
+
+// collectTripRecords receives the trip records of all n vehicles from the leaves and counts them in parked.
+// The vehicles a leaf dropped are counted in dropped and have no trip record.
+func collectTripRecords(m *streets.MPI, tripWriter *streets.TripWriter, transitReport *streets.TransitReport, n int, parked, dropped *atomic.Int64) error {
+	for received := 0; received < n; received++ {
+		record, err := m.ReceiveTripRecord()
+		if err != nil {
+			return err
+		}
+		if record.Dropped {
+			if record.ID == "" {
+				return errVehiclesLost
+			}
+			log.Error().Msgf("Vehicle %s was dropped by a leaf", record.ID)
+			dropped.Add(1)
+			continue
+		}
+		if tripWriter != nil {
+			if err := tripWriter.Write(record); err != nil {
+				return err
+			}
+		}
+		transitReport.Add(record)
+		parked.Add(1)
+		m.Metrics().VehicleParked()
+	}
+	return nil
+}
+
+// serveMetrics serves the metrics of a rank on port+rank, a port of 0 disables the endpoint
+func serveMetrics(port int, metrics *streets.Metrics, rank int) {
+	if port == 0 {
+		return
+	}
+	addr := fmt.Sprintf(":%d", port+rank)
+	go func() {
+		log.Info().Msgf("[%d] Serving metrics on %s/metrics", rank, addr)
+		if err := streets.ServeMetrics(addr, metrics); err != nil {
+			log.Error().Err(err).Msgf("[%d] Failed to serve metrics", rank)
+		}
+	}()
+}
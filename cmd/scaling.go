@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pchpc_next/streets"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// writePhaseReport writes the phase timings of a run, nothing if no path is given
+func writePhaseReport(path string, report streets.PhaseReport) {
+	if path == "" {
+		return
+	}
+	if err := streets.WritePhaseReportFile(path, report); err != nil {
+		log.Error().Err(err).Msg("Failed to write phase timings")
+		return
+	}
+	log.Info().Msgf("Wrote phase timings of %d ranks to %s", len(report.Ranks), path)
+}
+
+// parseWorldSizes parses a comma separated list of world sizes, 1 runs sequentially without MPI
+func parseWorldSizes(s string) ([]int, error) {
+	sizes := make([]int, 0)
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid world size %q", field)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// newScalingGrid is the grid every scaling run is started from, only -n changes between runs
+func newScalingGrid(scenarioPath string, seed int64) sweepGrid {
+	return sweepGrid{
+		Scenario:   scenarioPath,
+		Args:       []string{"-seed", strconv.FormatInt(seed, 10), "-phases", "phases.json"},
+		Parameters: []sweepParameter{{Flag: "n"}},
+	}
+}
+
+// newScalingRun is the run of a scaling series at a world size, the mode is always set so that a scenario cannot change it
+func newScalingRun(kind string, size, n int, outDir string) *sweepRun {
+	vehicles := n
+	if kind == streets.WeakScaling {
+		vehicles *= streets.Workers(size)
+	}
+	run := &sweepRun{values: []string{strconv.Itoa(vehicles)}, mode: modeMPI, setMode: true, ranks: size}
+	if size == 1 {
+		run.mode = modeSequential
+	}
+	run.dir = filepath.Join(outDir, fmt.Sprintf("%s-%d", kind, size))
+	return run
+}
+
+// scalingCommand handles 'scaling', which runs the simulation at several world sizes and reports speedup and efficiency
+func scalingCommand(args []string) int {
+	fs := newFlagSet("scaling", "Run the simulation at several world sizes and report speedup, efficiency and communication share.\n"+
+		"Strong scaling keeps -n vehicles, weak scaling drives -n vehicles per leaf. A world size of 1 runs sequentially.")
+	worldSizes := fs.String("ranks", "1,2,3,5", "Comma separated world sizes")
+	kinds := fs.String("kinds", "strong,weak", "Comma separated scaling series: strong, weak or both")
+	n := fs.Int("n", 1000, "Vehicles of a strong run, vehicles per leaf of a weak run")
+	seed := fs.Int64("seed", 42, "Seed of every run, so that runs of the same size drive the same population")
+	scenarioPath := fs.String("scenario", "", "Scenario passed to every run")
+	outDir := fs.String("out-dir", "scaling", "Directory for the outputs of the runs, every run writes to a directory of its own")
+	resultsPath := fs.String("o", "", "Write the scaling report to this CSV file, defaults to scaling.csv in -out-dir")
+	timeout := fs.Duration("timeout", 0, "Mark a run as failed after this wall time, 0 lets runs take as long as they need")
+	mpirun := fs.String("mpirun", "mpirun", "Program that starts the MPI runs")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	sizes, err := parseWorldSizes(*worldSizes)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	series := strings.Split(*kinds, ",")
+	for _, kind := range series {
+		if kind != streets.StrongScaling && kind != streets.WeakScaling {
+			return usageError(fs, "unknown scaling series %q", kind)
+		}
+	}
+	if *n < 1 {
+		return usageError(fs, "-n must be positive")
+	}
+	if *resultsPath == "" {
+		*resultsPath = filepath.Join(*outDir, "scaling.csv")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find the simulation binary: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(*outDir, 0o775); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *outDir, err)
+		return 1
+	}
+
+	grid := newScalingGrid(*scenarioPath, *seed)
+	rows := make([]streets.ScalingRow, 0)
+	failed := 0
+	for _, kind := range series {
+		reports := make([]streets.PhaseReport, 0)
+		for _, size := range sizes {
+			run := newScalingRun(kind, size, *n, *outDir)
+			fmt.Fprintf(os.Stderr, "%s scaling: world size %d, %s vehicles\n", kind, size, run.values[0])
+			executeSweepRun(grid, run, self, *mpirun, *timeout)
+			if run.status != "ok" {
+				failed++
+				fmt.Fprintf(os.Stderr, "world size %d %s: %v, see %s\n", size, run.status, run.err, filepath.Join(run.dir, "run.log"))
+				continue
+			}
+			report, err := streets.LoadPhaseReportFile(filepath.Join(run.dir, "phases.json"))
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "world size %d: %v\n", size, err)
+				continue
+			}
+			reports = append(reports, report)
+		}
+		rows = append(rows, streets.ScalingTable(kind, reports)...)
+	}
+
+	if err := streets.WriteScalingFile(*resultsPath, rows); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *resultsPath, err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "kind\tworld size\tworkers\tvehicles\twall\tspeedup\tefficiency\tcommunication\tsimulation")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.3fs\t%.2f\t%.2f\t%.2f\t%.3fs\n", r.Kind, r.WorldSize, r.Workers, r.Vehicles,
+			r.Wall, r.Speedup, r.Efficiency, r.CommunicationShare, r.Phases[streets.PhaseSimulation])
+	}
+	_ = w.Flush()
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d runs failed, the report leaves them out\n", failed)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalingRun_Command(t *testing.T) {
+	grid := newScalingGrid("scenario.json", 42)
+
+	run := newScalingRun("strong", 3, 100, "out")
+	program, args := grid.command(run, "/bin/sim", "mpirun")
+	assert.Equal(t, "mpirun", program)
+	assert.Equal(t, []string{"-np", "3", "/bin/sim", "run", "-scenario", "scenario.json", "-seed", "42", "-phases", "phases.json",
+		"-n=100", "-mode", modeMPI, "-out-dir", filepath.Join("out", "strong-3"), "-trips", "trips.jsonl"}, args)
+
+	// a world size of 1 runs sequentially even if the scenario sets another mode
+	run = newScalingRun("weak", 1, 100, "out")
+	program, args = grid.command(run, "/bin/sim", "mpirun")
+	assert.Equal(t, "/bin/sim", program)
+	assert.Equal(t, []string{"run", "-scenario", "scenario.json", "-seed", "42", "-phases", "phases.json",
+		"-n=100", "-mode", modeSequential, "-out-dir", filepath.Join("out", "weak-1"), "-trips", "trips.jsonl"}, args)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pchpc_next/streets"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// resolvedScenarioFile is the name of the resolved scenario written to -out-dir, it differs from the usual name
+// of a scenario so that the scenario a run was started with is not replaced
+const resolvedScenarioFile = "scenario.resolved.json"
+
+// wallLimitGrace is the time a run has to shut down after -wall-limit before the process is ended
+const wallLimitGrace = time.Minute
+
+// Scenario describes a run. Every field is tagged with the flag of the run command it sets,
+// fields left out of the file keep the default of their flag.
+type Scenario struct {
+	Graph       *string            `json:"graph,omitempty" flag:"jsonPath"`
+	Partitioner *string            `json:"partitioner,omitempty" flag:"partitioner"`
+	Mode        *string            `json:"mode,omitempty" flag:"mode"`
+	TimeScale   *float64           `json:"time_scale,omitempty" flag:"time-scale"`
+	Lookahead   *float64           `json:"lookahead,omitempty" flag:"lookahead"`
+	Vehicles    ScenarioVehicles   `json:"vehicles"`
+	Transit     *string            `json:"transit,omitempty" flag:"transit"`
+	Parking     *string            `json:"parking,omitempty" flag:"parking"`
+	Outputs     ScenarioOutputs    `json:"outputs"`
+	Stop        ScenarioStop       `json:"stop"`
+	Checkpoint  ScenarioCheckpoint `json:"checkpoint"`
+}
+
+// ScenarioVehicles is the vehicle population of a scenario
+type ScenarioVehicles struct {
+	N          *int     `json:"n,omitempty" flag:"n"`
+	Demand     *string  `json:"demand,omitempty" flag:"demand"`
+	Population *string  `json:"population,omitempty" flag:"population"`
+	Departures *string  `json:"departures,omitempty" flag:"departures"`
+	Classes    *string  `json:"classes,omitempty" flag:"classes"`
+	MinSpeed   *float64 `json:"min_speed,omitempty" flag:"min-speed"`
+	MaxSpeed   *float64 `json:"max_speed,omitempty" flag:"max-speed"`
+	Seed       *int64   `json:"seed,omitempty" flag:"seed"`
+}
+
+// ScenarioOutputs are the result files of a scenario, relative paths are placed in Dir
+type ScenarioOutputs struct {
+	Dir           *string  `json:"dir,omitempty" flag:"out-dir"`
+	Trips         *string  `json:"trips,omitempty" flag:"trips"`
+	EdgeStats     *string  `json:"edge_stats,omitempty" flag:"edge-stats"`
+	StatsInterval *float64 `json:"stats_interval,omitempty" flag:"stats-interval"`
+	TransitReport *string  `json:"transit_report,omitempty" flag:"transit-report"`
+	ParkingReport *string  `json:"parking_report,omitempty" flag:"parking-report"`
+}
+
+// ScenarioStop are the conditions that end a run before every vehicle is parked
+type ScenarioStop struct {
+	Until     *float64 `json:"until,omitempty" flag:"until"`
+	WallLimit *string  `json:"wall_limit,omitempty" flag:"wall-limit"`
+}
+
+// ScenarioCheckpoint are the checkpoint settings of an MPI scenario
+type ScenarioCheckpoint struct {
+	Dir   *string `json:"dir,omitempty" flag:"checkpoint-dir"`
+	Every *string `json:"every,omitempty" flag:"checkpoint-every"`
+}
+
+// LoadScenarioFile reads a scenario from a JSON file
+func LoadScenarioFile(path string) (Scenario, error) {
+	var s Scenario
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jBytes))
+	// a misspelled key would silently fall back to the default of its flag
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&s)
+	return s, err
+}
+
+// scenarioFields calls fn for every flag tagged field of the scenario
+func scenarioFields(v reflect.Value, fn func(name string, field reflect.Value) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := scenarioFields(field, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		name := v.Type().Field(i).Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		if err := fn(name, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyScenario sets the flags to the values of the scenario, flags given on the command line take precedence
+func applyScenario(fs *flag.FlagSet, s Scenario) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	return scenarioFields(reflect.ValueOf(&s).Elem(), func(name string, field reflect.Value) error {
+		if field.IsNil() || given[name] {
+			return nil
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("scenario sets unknown flag -%s", name)
+		}
+		if err := fs.Set(name, fmt.Sprint(field.Elem().Interface())); err != nil {
+			return fmt.Errorf("scenario value of -%s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// resolveScenario returns the scenario with the values of all flags after the file and the command line are applied
+func resolveScenario(fs *flag.FlagSet) (Scenario, error) {
+	var s Scenario
+	err := scenarioFields(reflect.ValueOf(&s).Elem(), func(name string, field reflect.Value) error {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("scenario field of unknown flag -%s", name)
+		}
+		value := reflect.New(field.Type().Elem())
+		getter, ok := f.Value.(flag.Getter)
+		if !ok {
+			return fmt.Errorf("flag -%s has no value", name)
+		}
+		got := reflect.ValueOf(getter.Get())
+		switch {
+		case value.Elem().Kind() == reflect.String:
+			// durations are written as strings, e.g. 10m0s
+			value.Elem().SetString(f.Value.String())
+		case got.Type().ConvertibleTo(value.Elem().Type()):
+			value.Elem().Set(got.Convert(value.Elem().Type()))
+		default:
+			return fmt.Errorf("flag -%s of type %s does not fit the scenario", name, got.Type())
+		}
+		field.Set(value)
+		return nil
+	})
+	return s, err
+}
+
+// scenarioFlags are the flags to load a scenario and place its results
+type scenarioFlags struct {
+	path        *string
+	outDir      *string
+	partitioner *string
+	until       *float64
+	wallLimit   *time.Duration
+
+	// relative are the output paths as given, before they were placed in -out-dir
+	relative map[string]string
+	// exceeded is set once the run took longer than -wall-limit
+	exceeded atomic.Bool
+}
+
+func addScenarioFlags(fs *flag.FlagSet) *scenarioFlags {
+	return &scenarioFlags{
+		path:        fs.String("scenario", "", "Path to a json scenario, flags given on the command line override its values"),
+		outDir:      fs.String("out-dir", "", "Directory for the output files given with relative paths, the resolved scenario is written to it"),
+		partitioner: fs.String("partitioner", "rect", "How the graph is divided among the leaves, only rect is supported"),
+		until:       fs.Float64("until", 0, "Stop every vehicle at this simulated time and release none after it, 0 runs until all vehicles are parked"),
+		wallLimit:   fs.Duration("wall-limit", 0, "Stop every vehicle where it is after this wall time and end the run with exit code 1, e.g. 30m"),
+	}
+}
+
+// load applies the scenario file to the flags and checks the values only a scenario knows about
+func (sf *scenarioFlags) load(fs *flag.FlagSet) error {
+	if *sf.path != "" {
+		s, err := LoadScenarioFile(*sf.path)
+		if err != nil {
+			return fmt.Errorf("scenario %s: %w", *sf.path, err)
+		}
+		if err := applyScenario(fs, s); err != nil {
+			return fmt.Errorf("scenario %s: %w", *sf.path, err)
+		}
+	}
+	if *sf.partitioner != "rect" {
+		return fmt.Errorf("unknown partitioner %q", *sf.partitioner)
+	}
+	if *sf.until < 0 || *sf.wallLimit < 0 {
+		return fmt.Errorf("stop conditions must not be negative")
+	}
+	return nil
+}
+
+// dropLateDepartures removes the vehicles departing at or after -until
+func (sf *scenarioFlags) dropLateDepartures(vehicleList []*streets.Vehicle) []*streets.Vehicle {
+	if *sf.until <= 0 {
+		return vehicleList
+	}
+	kept := vehicleList[:0]
+	for _, vehicle := range vehicleList {
+		if vehicle.Departure < *sf.until {
+			kept = append(kept, vehicle)
+		}
+	}
+	if dropped := len(vehicleList) - len(kept); dropped > 0 {
+		log.Info().Msgf("Dropped %d vehicles departing after %g", dropped, *sf.until)
+	}
+	return kept
+}
+
+// startWallLimit returns a context that is done once the run has taken longer than -wall-limit. The vehicles
+// are stopped where they are and the run shuts down as usual, a run that has not ended wallLimitGrace later
+// is aborted.
+func (sf *scenarioFlags) startWallLimit() context.Context {
+	if *sf.wallLimit <= 0 {
+		return context.Background()
+	}
+	limit := *sf.wallLimit
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(limit, func() {
+		log.Error().Msgf("Run exceeded the wall limit of %s, stopping the vehicles", limit)
+		sf.exceeded.Store(true)
+		cancel()
+		time.AfterFunc(wallLimitGrace, func() {
+			log.Error().Msgf("Run did not shut down within %s of the wall limit", wallLimitGrace)
+			os.Exit(1)
+		})
+	})
+	return ctx
+}
+
+// exceededWallLimit reports whether the run was stopped by -wall-limit
+func (sf *scenarioFlags) exceededWallLimit() bool {
+	return sf.exceeded.Load()
+}
+
+// placeOutputs moves the relative paths of the named output flags into -out-dir and creates it
+func (sf *scenarioFlags) placeOutputs(fs *flag.FlagSet, names ...string) error {
+	if *sf.outDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*sf.outDir, 0o775); err != nil {
+		return err
+	}
+	sf.relative = make(map[string]string)
+	for _, name := range names {
+		path := fs.Lookup(name).Value.String()
+		if path == "" || filepath.IsAbs(path) {
+			continue
+		}
+		sf.relative[name] = path
+		if err := fs.Set(name, filepath.Join(*sf.outDir, path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeResolved writes the resolved scenario to -out-dir, so that a run can be repeated from its results.
+// Nothing is written without -out-dir or if it would replace the scenario the run was started with.
+func (sf *scenarioFlags) writeResolved(fs *flag.FlagSet) error {
+	if *sf.outDir == "" {
+		return nil
+	}
+	path := filepath.Join(*sf.outDir, resolvedScenarioFile)
+	if *sf.path != "" && samePath(path, *sf.path) {
+		return fmt.Errorf("%s is the scenario of the run, it is not replaced", path)
+	}
+	s, err := resolveScenario(fs)
+	if err != nil {
+		return err
+	}
+	// the outputs keep their paths relative to -out-dir, so that the scenario can be run again elsewhere
+	_ = scenarioFields(reflect.ValueOf(&s).Elem(), func(name string, field reflect.Value) error {
+		if path, ok := sf.relative[name]; ok {
+			field.Set(reflect.ValueOf(&path))
+		}
+		return nil
+	})
+	jBytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*sf.outDir, 0o775); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(jBytes, '\n'), 0o664)
+}
+
+// samePath reports whether two paths name the same file
+func samePath(a, b string) bool {
+	aInfo, aErr := os.Stat(a)
+	bInfo, bErr := os.Stat(b)
+	if aErr == nil && bErr == nil {
+		return os.SameFile(aInfo, bInfo)
+	}
+	absA, aErr := filepath.Abs(a)
+	absB, bErr := filepath.Abs(b)
+	return aErr == nil && bErr == nil && absA == absB
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRunFlagSet returns the flags of the run command
+func newRunFlagSet() (*flag.FlagSet, *scenarioFlags) {
+	fs := newFlagSet("run", "")
+	addVehicleFlags(fs)
+	addCheckpointFlags(fs)
+	addRunFlags(fs)
+	return fs, addScenarioFlags(fs)
+}
+
+func TestScenarioFlags_WriteResolved(t *testing.T) {
+	dir := t.TempDir()
+	scenarioPath := filepath.Join(dir, "scenario.json")
+	scenario := []byte(`{"vehicles": {"n": 10}}`)
+	assert.NoError(t, os.WriteFile(scenarioPath, scenario, 0o664))
+
+	// the resolved scenario is written next to the scenario of the run without replacing it
+	fs, sf := newRunFlagSet()
+	assert.NoError(t, fs.Parse([]string{"-scenario", scenarioPath, "-out-dir", dir}))
+	assert.NoError(t, sf.load(fs))
+	assert.NoError(t, sf.writeResolved(fs))
+	got, err := os.ReadFile(scenarioPath)
+	assert.NoError(t, err)
+	assert.Equal(t, scenario, got)
+	resolved, err := LoadScenarioFile(filepath.Join(dir, resolvedScenarioFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, *resolved.Vehicles.N)
+
+	// a run started from a resolved scenario does not replace it
+	resolvedPath := filepath.Join(dir, resolvedScenarioFile)
+	before, err := os.ReadFile(resolvedPath)
+	assert.NoError(t, err)
+	fs, sf = newRunFlagSet()
+	assert.NoError(t, fs.Parse([]string{"-scenario", resolvedPath, "-out-dir", dir, "-n", "20"}))
+	assert.NoError(t, sf.load(fs))
+	assert.Error(t, sf.writeResolved(fs))
+	after, err := os.ReadFile(resolvedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// a missing -out-dir is created, nothing is written without one
+	outDir := filepath.Join(dir, "missing", "out")
+	fs, sf = newRunFlagSet()
+	assert.NoError(t, fs.Parse([]string{"-out-dir", outDir}))
+	assert.NoError(t, sf.writeResolved(fs))
+	assert.FileExists(t, filepath.Join(outDir, resolvedScenarioFile))
+
+	fs, sf = newRunFlagSet()
+	assert.NoError(t, fs.Parse(nil))
+	assert.NoError(t, sf.writeResolved(fs))
+}
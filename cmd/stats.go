@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"pchpc_next/streets"
+	"sort"
+	"text/tabwriter"
+)
+
+// statsCommand handles 'stats', which prints the size, street lengths and road types of a graph
+func statsCommand(args []string) int {
+	fs := newFlagSet("stats", "Print the size, street lengths, degrees and road types of a graph file.")
+	jsonPath := fs.String("jsonPath", "assets/out.json", "Path to the json containing the graph data")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	jGraph, err := streets.LoadGraphFile(*jsonPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *jsonPath, err)
+		return 1
+	}
+	if len(jGraph.Graph.Edges) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: graph has no edges\n", *jsonPath)
+		return 1
+	}
+
+	vertices := make(map[int]streets.JVertex)
+	for _, v := range jGraph.Graph.Vertices {
+		vertices[v.ID] = v
+	}
+
+	outDegree := make(map[int]int)
+	inDegree := make(map[int]int)
+	highways := make(map[string]int)
+	total, shortest, longest := 0.0, math.Inf(1), 0.0
+	for _, e := range jGraph.Graph.Edges {
+		outDegree[e.From]++
+		inDegree[e.To]++
+		total += e.Length
+		shortest = math.Min(shortest, e.Length)
+		longest = math.Max(longest, e.Length)
+		highway := e.Highway
+		if highway == "" {
+			highway = "(none)"
+		}
+		highways[highway]++
+	}
+
+	maxDegree, deadEnds := 0, 0
+	for id := range vertices {
+		if outDegree[id] > maxDegree {
+			maxDegree = outDegree[id]
+		}
+		if outDegree[id] == 0 && inDegree[id] > 0 {
+			deadEnds++
+		}
+	}
+
+	edges := len(jGraph.Graph.Edges)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "file\t%s\n", *jsonPath)
+	fmt.Fprintf(w, "vertices\t%d\n", len(vertices))
+	fmt.Fprintf(w, "edges\t%d\n", edges)
+	fmt.Fprintf(w, "street length\t%.2f km\n", total/1000)
+	fmt.Fprintf(w, "edge length\tmin %.2f m, mean %.2f m, max %.2f m\n", shortest, total/float64(edges), longest)
+	fmt.Fprintf(w, "out degree\tmean %.2f, max %d\n", float64(edges)/float64(len(vertices)), maxDegree)
+	fmt.Fprintf(w, "dead ends\t%d\n", deadEnds)
+
+	names := make([]string, 0, len(highways))
+	for name := range highways {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if highways[names[i]] != highways[names[j]] {
+			return highways[names[i]] > highways[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(w, "highway %s\t%d edges\n", name, highways[name])
+	}
+	if err := w.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"pchpc_next/streets"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sweepRanksParameter is the grid parameter of the world size of MPI runs, it is not a flag of 'run'
+const sweepRanksParameter = "ranks"
+
+// sweepGrid is the parameter grid of a sweep. Every combination of the parameter values is run once.
+type sweepGrid struct {
+	// Scenario is passed to every run, the parameters override its values
+	Scenario string `json:"scenario"`
+	// Args are passed to every run after the scenario
+	Args []string `json:"args"`
+	// Mode is the -mode of runs without a mode parameter, empty keeps the mode of the scenario
+	Mode string `json:"mode"`
+	// Ranks is the world size of MPI runs without a ranks parameter
+	Ranks      int              `json:"ranks"`
+	Parameters []sweepParameter `json:"parameters"`
+
+	// scenarioMode is the mode of runs the grid sets no mode for, the one of the scenario or the default of 'run'
+	scenarioMode string
+}
+
+// sweepParameter is a flag of 'run' and the values it takes in the sweep
+type sweepParameter struct {
+	Flag   string        `json:"flag"`
+	Values []json.Number `json:"values"`
+}
+
+// sweepRun is a combination of the grid and its outcome
+type sweepRun struct {
+	index  int
+	values []string
+	mode   string
+	// setMode is set if the grid sets the mode, otherwise the run keeps the mode of its scenario
+	setMode bool
+	ranks   int
+	dir     string
+
+	status   string
+	exitCode int
+	wallTime time.Duration
+	summary  streets.TripSummary
+	err      error
+}
+
+func loadSweepGrid(path string) (sweepGrid, error) {
+	grid := sweepGrid{Ranks: 3}
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return grid, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&grid); err != nil {
+		return grid, err
+	}
+	if len(grid.Parameters) == 0 {
+		return grid, errors.New("grid has no parameters")
+	}
+	grid.scenarioMode = modeSequential
+	if grid.Scenario != "" {
+		s, err := LoadScenarioFile(grid.Scenario)
+		if err != nil {
+			return grid, fmt.Errorf("scenario %s: %w", grid.Scenario, err)
+		}
+		if s.Mode != nil {
+			grid.scenarioMode = *s.Mode
+		}
+	}
+	seen := make(map[string]bool)
+	for _, p := range grid.Parameters {
+		if p.Flag == "" || len(p.Values) == 0 {
+			return grid, fmt.Errorf("parameter %q needs a flag and at least one value", p.Flag)
+		}
+		if seen[p.Flag] {
+			return grid, fmt.Errorf("parameter %q is listed twice", p.Flag)
+		}
+		seen[p.Flag] = true
+	}
+	return grid, nil
+}
+
+// UnmarshalJSON accepts numbers and strings as parameter values
+func (p *sweepParameter) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Flag   string            `json:"flag"`
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.Flag = raw.Flag
+	p.Values = make([]json.Number, len(raw.Values))
+	for i, v := range raw.Values {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			p.Values[i] = json.Number(s)
+			continue
+		}
+		var n json.Number
+		if err := json.Unmarshal(v, &n); err != nil {
+			return fmt.Errorf("parameter %q: value %s is neither a string nor a number", raw.Flag, v)
+		}
+		p.Values[i] = n
+	}
+	return nil
+}
+
+// combinations returns every combination of the parameter values, the last parameter changes fastest.
+// The world size only matters to MPI runs, other modes are run once for all of its values.
+func (g sweepGrid) combinations() []*sweepRun {
+	runs := make([]*sweepRun, 0)
+	seen := make(map[string]bool)
+	indices := make([]int, len(g.Parameters))
+	for {
+		run := &sweepRun{values: make([]string, len(g.Parameters)), mode: g.Mode, setMode: g.Mode != "", ranks: g.Ranks}
+		if !run.setMode {
+			run.mode = g.scenarioMode
+		}
+		for i, p := range g.Parameters {
+			run.values[i] = p.Values[indices[i]].String()
+			if p.Flag == "mode" {
+				run.mode = run.values[i]
+				run.setMode = true
+			}
+		}
+		for i, p := range g.Parameters {
+			if p.Flag != sweepRanksParameter {
+				continue
+			}
+			if run.mode != modeMPI {
+				run.values[i] = ""
+			} else if ranks, err := strconv.Atoi(run.values[i]); err == nil {
+				run.ranks = ranks
+			} else {
+				run.ranks = 0
+			}
+		}
+		if key := strings.Join(run.values, "\x00"); !seen[key] {
+			seen[key] = true
+			run.index = len(runs)
+			runs = append(runs, run)
+		}
+
+		i := len(indices) - 1
+		for ; i >= 0; i-- {
+			indices[i]++
+			if indices[i] < len(g.Parameters[i].Values) {
+				break
+			}
+			indices[i] = 0
+		}
+		if i < 0 {
+			return runs
+		}
+	}
+}
+
+// command returns the program and arguments of a run, MPI runs are started with mpirun
+func (g sweepGrid) command(run *sweepRun, self, mpirun string) (string, []string) {
+	args := []string{"run"}
+	if g.Scenario != "" {
+		args = append(args, "-scenario", g.Scenario)
+	}
+	args = append(args, g.Args...)
+	for i, p := range g.Parameters {
+		if p.Flag == sweepRanksParameter || p.Flag == "mode" {
+			continue
+		}
+		args = append(args, "-"+p.Flag+"="+run.values[i])
+	}
+	if run.setMode {
+		args = append(args, "-mode", run.mode)
+	}
+	// the trips of every run are summarised in the results table
+	args = append(args, "-out-dir", run.dir, "-trips", "trips.jsonl")
+	if run.mode == modeMPI {
+		return mpirun, append([]string{"-np", strconv.Itoa(run.ranks), self}, args...)
+	}
+	return self, args
+}
+
+// sweepCommand handles 'sweep', which runs the simulation for every combination of a parameter grid
+func sweepCommand(args []string) int {
+	fs := newFlagSet("sweep", "Run the simulation for every combination of a parameter grid and collect the results in one table.\n"+
+		"The grid is a json file, e.g. {\"mode\": \"goroutines\", \"parameters\": [{\"flag\": \"n\", \"values\": [100, 1000]}]}.\n"+
+		"The parameter \"ranks\" sets the world size of MPI runs.")
+	gridPath := fs.String("grid", "", "Path to the json parameter grid")
+	outDir := fs.String("out-dir", "sweep", "Directory for the outputs of the runs, every run writes to a directory of its own")
+	resultsPath := fs.String("o", "", "Write the results table to this CSV file, defaults to results.csv in -out-dir")
+	timeout := fs.Duration("timeout", 0, "Mark a run as failed after this wall time, 0 lets runs take as long as they need")
+	mpirun := fs.String("mpirun", "mpirun", "Program that starts the MPI runs")
+	dryRun := fs.Bool("dry-run", false, "Print the commands of the runs without running them")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *gridPath == "" {
+		return usageError(fs, "-grid is required")
+	}
+	grid, err := loadSweepGrid(*gridPath)
+	if err != nil {
+		return usageError(fs, "grid %s: %v", *gridPath, err)
+	}
+	if *resultsPath == "" {
+		*resultsPath = filepath.Join(*outDir, "results.csv")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find the simulation binary: %v\n", err)
+		return 1
+	}
+
+	runs := grid.combinations()
+	for _, run := range runs {
+		run.dir = filepath.Join(*outDir, fmt.Sprintf("run-%03d", run.index))
+	}
+	if *dryRun {
+		for _, run := range runs {
+			name, runArgs := grid.command(run, self, *mpirun)
+			fmt.Println(name, strings.Join(runArgs, " "))
+		}
+		return 0
+	}
+
+	if err := os.MkdirAll(*outDir, 0o775); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *outDir, err)
+		return 1
+	}
+	failed := 0
+	for _, run := range runs {
+		fmt.Fprintf(os.Stderr, "run %d/%d: %s\n", run.index+1, len(runs), describeSweepRun(grid, run))
+		executeSweepRun(grid, run, self, *mpirun, *timeout)
+		if run.status != "ok" {
+			failed++
+			fmt.Fprintf(os.Stderr, "run %d %s: %v, see %s\n", run.index+1, run.status, run.err, filepath.Join(run.dir, "run.log"))
+		}
+	}
+
+	if err := writeSweepResults(*resultsPath, grid, runs); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *resultsPath, err)
+		return 1
+	}
+	printSweepResults(grid, runs)
+	fmt.Fprintf(os.Stderr, "%d of %d runs failed, results in %s\n", failed, len(runs), *resultsPath)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func describeSweepRun(grid sweepGrid, run *sweepRun) string {
+	parts := make([]string, 0, len(grid.Parameters))
+	for i, p := range grid.Parameters {
+		if run.values[i] != "" {
+			parts = append(parts, p.Flag+"="+run.values[i])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// executeSweepRun runs a combination and records its outcome, a failed run is marked and never aborts the sweep
+func executeSweepRun(grid sweepGrid, run *sweepRun, self, mpirun string, timeout time.Duration) {
+	run.status = "failed"
+	if err := os.MkdirAll(run.dir, 0o775); err != nil {
+		run.err = err
+		return
+	}
+	logFile, err := os.Create(filepath.Join(run.dir, "run.log"))
+	if err != nil {
+		run.err = err
+		return
+	}
+	defer logFile.Close()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	name, args := grid.command(run, self, mpirun)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	start := time.Now()
+	err = cmd.Run()
+	run.wallTime = time.Since(start)
+	if cmd.ProcessState != nil {
+		run.exitCode = cmd.ProcessState.ExitCode()
+	} else {
+		// the program could not be started
+		run.exitCode = -1
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		run.status = "timeout"
+		run.err = fmt.Errorf("exceeded %s", timeout)
+		return
+	}
+	if err != nil {
+		run.err = err
+		return
+	}
+
+	records, err := streets.LoadTripRecordsFile(filepath.Join(run.dir, "trips.jsonl"))
+	if err != nil {
+		run.err = err
+		return
+	}
+	run.summary = streets.SummarizeTrips(records)
+	run.status = "ok"
+}
+
+// throughput is the number of vehicles simulated per wall second
+func (run *sweepRun) throughput() float64 {
+	if run.wallTime <= 0 {
+		return 0
+	}
+	return float64(run.summary.Vehicles) / run.wallTime.Seconds()
+}
+
+func sweepHeader(grid sweepGrid) []string {
+	header := []string{"run"}
+	for _, p := range grid.Parameters {
+		header = append(header, p.Flag)
+	}
+	return append(header, "status", "exit_code", "wall_time", "vehicles", "stopped", "throughput", "mean_travel_time", "mean_distance", "last_arrival", "dir")
+}
+
+func (run *sweepRun) row() []string {
+	row := []string{strconv.Itoa(run.index + 1)}
+	row = append(row, run.values...)
+	return append(row,
+		run.status,
+		strconv.Itoa(run.exitCode),
+		strconv.FormatFloat(run.wallTime.Seconds(), 'f', 3, 64),
+		strconv.Itoa(run.summary.Vehicles),
+		strconv.Itoa(run.summary.Stopped),
+		strconv.FormatFloat(run.throughput(), 'f', 2, 64),
+		strconv.FormatFloat(run.summary.MeanTravelTime, 'f', 2, 64),
+		strconv.FormatFloat(run.summary.MeanDistance, 'f', 2, 64),
+		strconv.FormatFloat(run.summary.LastArrival, 'f', 2, 64),
+		run.dir,
+	)
+}
+
+func writeSweepResults(path string, grid sweepGrid, runs []*sweepRun) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write(sweepHeader(grid))
+	for _, run := range runs {
+		_ = w.Write(run.row())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printSweepResults(grid sweepGrid, runs []*sweepRun) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := sweepHeader(grid)
+	// the directories are in the CSV file
+	fmt.Fprintln(w, strings.Join(header[:len(header)-1], "\t"))
+	for _, run := range runs {
+		row := run.row()
+		fmt.Fprintln(w, strings.Join(row[:len(row)-1], "\t"))
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"pchpc_next/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// addTransitVehicles creates a vehicle for every departure of the transit lines in path
+func addTransitVehicles(path string, rootGraph *streets.StreetGraph) ([]*streets.Vehicle, error) {
+	transit, err := streets.LoadTransitFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vehicles, err := rootGraph.AddTransitVehicles(transit)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("Starting %d transit vehicles on %d lines from %s", len(vehicles), len(transit.Lines), path)
+	return vehicles, nil
+}
+
+// newTransitReport returns the report collecting transit trips, nil if no report is written
+func newTransitReport(path string, resumed bool) *streets.TransitReport {
+	if path == "" {
+		return nil
+	}
+	if resumed {
+		log.Warn().Msg("The transit report of a resumed run only covers the trips finished after the checkpoint")
+	}
+	return streets.NewTransitReport()
+}
+
+func writeTransitReport(path string, transitReport *streets.TransitReport) {
+	if transitReport == nil {
+		return
+	}
+	lines := transitReport.Lines()
+	if err := streets.WriteTransitReportFile(path, lines); err != nil {
+		log.Error().Err(err).Msg("Failed to write transit report")
+		return
+	}
+	log.Info().Msgf("Wrote transit report of %d lines", len(lines))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"pchpc_next/streets"
+)
+
+// validateGraphCommand handles 'validate-graph', which reports errors and warnings of a graph file.
+// It fails if the graph has errors, or warnings with -strict.
+func validateGraphCommand(args []string) int {
+	fs := newFlagSet("validate-graph", "Check a graph file for missing vertices, invalid lengths and unreachable parts.")
+	jsonPath := fs.String("jsonPath", "assets/out.json", "Path to the json containing the graph data")
+	strict := fs.Bool("strict", false, "Fail on warnings as well")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	jGraph, err := streets.LoadGraphFile(*jsonPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *jsonPath, err)
+		return 1
+	}
+
+	issues := streets.ValidateGraph(jGraph)
+	for _, issue := range issues {
+		fmt.Printf("%s: %s\n", *jsonPath, issue)
+	}
+	if streets.HasErrors(issues) || (*strict && len(issues) > 0) {
+		return 1
+	}
+	vertices := make(map[int]bool)
+	for _, v := range jGraph.Graph.Vertices {
+		vertices[v.ID] = true
+	}
+	fmt.Printf("%s: ok, %d vertices and %d edges\n", *jsonPath, len(vertices), len(jGraph.Graph.Edges))
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"pchpc_next/streets"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// vehicleTracker reports the positions of driven vehicles to the visualisation. A nil tracker does nothing.
+type vehicleTracker struct {
+	board *streets.PositionBoard
+	clock *streets.Clock
+}
+
+// newVehicleTracker returns a tracker if the visualisation is enabled, nil otherwise
+func newVehicleTracker(enabled bool, rank int, clock *streets.Clock) *vehicleTracker {
+	if !enabled {
+		return nil
+	}
+	return &vehicleTracker{board: streets.NewPositionBoard(rank), clock: clock}
+}
+
+// step records the position after a step
+func (t *vehicleTracker) step(vehicle *streets.Vehicle) {
+	if t == nil {
+		return
+	}
+	if vehicle.IsParked {
+		t.board.Remove(vehicle.ID)
+		return
+	}
+	t.board.Update(vehicle)
+}
+
+// remove forgets a vehicle that is parked or has left the rank
+func (t *vehicleTracker) remove(id string) {
+	if t == nil {
+		return
+	}
+	t.board.Remove(id)
+}
+
+// onStep returns the step function for streets.Vehicle.DriveStep of vehicles paced by a scheduler
+func (t *vehicleTracker) onStep() func(*streets.Vehicle) {
+	if t == nil {
+		return nil
+	}
+	return t.step
+}
+
+// onPacedStep returns the step function for streets.Vehicle.DriveWith, the driving goroutine waits for the clock
+// after every step
+func (t *vehicleTracker) onPacedStep() func(*streets.Vehicle) {
+	if t == nil {
+		return nil
+	}
+	return func(vehicle *streets.Vehicle) {
+		t.step(vehicle)
+		t.clock.WaitUntil(vehicle.Time)
+	}
+}
+
+// serveViz serves the visualisation of the board on port
+func serveViz(port int, rate float64, rootGraph *streets.StreetGraph, leaves []*streets.StreetGraph, tracker *vehicleTracker) {
+	if tracker == nil {
+		return
+	}
+	server, err := streets.NewVizServer(rootGraph, leaves, tracker.board, rate)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create visualisation")
+		return
+	}
+
+	addr := fmt.Sprintf(":%d", port)
+	go func() {
+		log.Info().Msgf("Serving visualisation on http://localhost%s", addr)
+		if err := streets.ServeViz(addr, server); err != nil {
+			log.Error().Err(err).Msg("Failed to serve visualisation")
+		}
+	}()
+}
+
+// listenForPositions collects the positions sampled by the leaves on the root
+func listenForPositions(m *streets.MPI, tracker *vehicleTracker) {
+	for {
+		rank, positions, err := m.ReceivePositions()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to receive positions")
+			return
+		}
+		tracker.board.SetRank(rank, positions)
+	}
+}
+
+// maxVizRate is the largest -viz-rate, higher rates round the sampling interval down to zero
+const maxVizRate = 1e9
+
+// samplePositions sends the positions of the leaf to the root rate times per second until stop is closed
+func samplePositions(m *streets.MPI, tracker *vehicleTracker, rate float64, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			// clear the vehicles of the leaf on the page
+			if err := m.SendPositionsToRoot([]streets.VehiclePosition{}); err != nil {
+				log.Error().Err(err).Msg("Failed to send positions")
+			}
+			return
+		case <-ticker.C:
+			if err := m.SendPositionsToRoot(tracker.board.Sample()); err != nil {
+				log.Error().Err(err).Msg("Failed to send positions")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package streets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Checkpoint commands sent from the root to the leaves
+const (
+	CHECKPOINT_PAUSE  = "pause"  // stop stepping vehicles and hold them
+	CHECKPOINT_QUERY  = "query"  // report held and active vehicles
+	CHECKPOINT_WRITE  = "write"  // write the held vehicles to the checkpoint directory
+	CHECKPOINT_RESUME = "resume" // drive the held vehicles again
+	CHECKPOINT_EXIT   = "exit"   // the run ends after the checkpoint
+	CHECKPOINT_FINISH = "finish" // the run has ended, no more checkpoints follow
+)
+
+// rootCheckpointFile is the file the root writes into the checkpoint directory
+const rootCheckpointFile = "root.json"
+
+// CheckpointCommand is sent by the root to coordinate a checkpoint
+type CheckpointCommand struct {
+	Kind string
+	Dir  string
+}
+
+// CheckpointReply is the answer of a leaf to a checkpoint command
+type CheckpointReply struct {
+	Rank   int
+	Held   int
+	Active int
+	Err    string
+}
+
+func (c *CheckpointCommand) Marshal() ([]byte, error) {
+	return seal(MsgCheckpointCommand, func(e *encoder) {
+		e.str(c.Kind)
+		e.str(c.Dir)
+	}), nil
+}
+
+func UnmarshalCheckpointCommand(data []byte) (CheckpointCommand, error) {
+	var r CheckpointCommand
+	d, err := openEnvelope(data, MsgCheckpointCommand)
+	if err != nil {
+		return r, err
+	}
+	r.Kind = d.str()
+	r.Dir = d.str()
+	return r, d.close()
+}
+
+func (c *CheckpointReply) Marshal() ([]byte, error) {
+	return seal(MsgCheckpointReply, func(e *encoder) {
+		e.int(c.Rank)
+		e.int(c.Held)
+		e.int(c.Active)
+		e.str(c.Err)
+	}), nil
+}
+
+func UnmarshalCheckpointReply(data []byte) (CheckpointReply, error) {
+	var r CheckpointReply
+	d, err := openEnvelope(data, MsgCheckpointReply)
+	if err != nil {
+		return r, err
+	}
+	r.Rank = d.int()
+	r.Held = d.int()
+	r.Active = d.int()
+	r.Err = d.str()
+	return r, d.close()
+}
+
+// CheckpointGate holds the vehicles of a leaf while a checkpoint is taken.
+// Vehicles are held between two steps, so that they can be emitted again like new vehicles.
+type CheckpointGate struct {
+	paused  atomic.Bool
+	exiting atomic.Bool
+	active  atomic.Int64
+
+	mu   sync.Mutex
+	held []Vehicle
+}
+
+// Pause makes driving vehicles stop at their next step
+func (c *CheckpointGate) Pause() {
+	c.paused.Store(true)
+}
+
+// IsPaused reports whether vehicles should be held
+func (c *CheckpointGate) IsPaused() bool {
+	return c.paused.Load()
+}
+
+// Exit marks that the run ends after the checkpoint
+func (c *CheckpointGate) Exit() {
+	c.exiting.Store(true)
+}
+
+// IsExiting reports whether the run ends after the checkpoint
+func (c *CheckpointGate) IsExiting() bool {
+	return c.exiting.Load()
+}
+
+// Hold keeps a vehicle until the checkpoint is written
+func (c *CheckpointGate) Hold(v Vehicle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = append(c.held, v)
+}
+
+// Held returns the number of held vehicles
+func (c *CheckpointGate) Held() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.held)
+}
+
+// Started counts a vehicle that is being driven
+func (c *CheckpointGate) Started() {
+	c.active.Add(1)
+}
+
+// Finished counts a vehicle that is not driven anymore
+func (c *CheckpointGate) Finished() {
+	c.active.Add(-1)
+}
+
+// Active returns the number of vehicles being driven
+func (c *CheckpointGate) Active() int {
+	return int(c.active.Load())
+}
+
+// Resume stops holding vehicles and returns the vehicles held so far
+func (c *CheckpointGate) Resume() []Vehicle {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused.Store(false)
+	held := c.held
+	c.held = nil
+	return held
+}
+
+// RankCheckpoint is the checkpoint file of a leaf
+type RankCheckpoint struct {
+	Rank      int            `json:"rank"`
+	Vehicles  []rawVehicle   `json:"vehicles"`
+	EdgeStats []EdgeInterval `json:"edge_stats"`
+	Parking   []ParkingEvent `json:"parking"`
+}
+
+// RootCheckpoint is the checkpoint file of the root
+type RootCheckpoint struct {
+	WorldSize int     `json:"world_size"`
+	Seed      int64   `json:"seed"`
+	Clock     float64 `json:"clock"`
+	// Parked is the number of vehicles whose trip record has been written
+	Parked int `json:"parked"`
+	// Vehicles are the vehicles that have not been released yet
+	Vehicles  []rawVehicle   `json:"vehicles"`
+	EdgeStats []EdgeInterval `json:"edge_stats"`
+}
+
+func rawVehicles(vehicles []Vehicle) []rawVehicle {
+	raw := make([]rawVehicle, len(vehicles))
+	for i := range vehicles {
+		raw[i] = vehicles[i].raw()
+	}
+	return raw
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	jBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, a preempted write must not destroy the previous checkpoint
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, jBytes, 0o664); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// PrepareCheckpointDir creates the checkpoint directory and removes an earlier checkpoint, which may have
+// been taken with a larger world size. The root file is written last, a checkpoint without it is incomplete.
+func PrepareCheckpointDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o775); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "rank-*.json"))
+	if err != nil {
+		return err
+	}
+	files = append(files, filepath.Join(dir, rootCheckpointFile))
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRankCheckpoint writes the held vehicles, the edge stats and the parking events of a leaf
+func (c *CheckpointGate) WriteRankCheckpoint(dir string, rank int, edgeStats []EdgeInterval, parking []ParkingEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o775); err != nil {
+		return err
+	}
+	rc := RankCheckpoint{Rank: rank, Vehicles: rawVehicles(c.held), EdgeStats: edgeStats, Parking: parking}
+	return writeJSONFile(filepath.Join(dir, fmt.Sprintf("rank-%d.json", rank)), rc)
+}
+
+// WriteRootCheckpoint writes the state of the root
+func WriteRootCheckpoint(dir string, rc RootCheckpoint, unreleased []Vehicle) error {
+	if err := os.MkdirAll(dir, 0o775); err != nil {
+		return err
+	}
+	rc.Vehicles = rawVehicles(unreleased)
+	return writeJSONFile(filepath.Join(dir, rootCheckpointFile), rc)
+}
+
+// Checkpoint is a complete checkpoint of all ranks
+type Checkpoint struct {
+	Root RootCheckpoint
+	// Vehicles are the unreleased and the held vehicles of all ranks
+	Vehicles  []Vehicle
+	EdgeStats []EdgeInterval
+	// Parking are the parking events of all ranks, every leaf restores those of its facilities
+	Parking []ParkingEvent
+}
+
+// LoadCheckpoint reads the checkpoint files of all ranks. The world size may differ from the
+// checkpointed run, held vehicles are emitted again on the new partitioning.
+func LoadCheckpoint(dir string) (*Checkpoint, error) {
+	jBytes, err := os.ReadFile(filepath.Join(dir, rootCheckpointFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(jBytes, &cp.Root); err != nil {
+		return nil, fmt.Errorf("%s: %w", rootCheckpointFile, err)
+	}
+
+	tables := [][]EdgeInterval{cp.Root.EdgeStats}
+	for _, r := range cp.Root.Vehicles {
+		cp.Vehicles = append(cp.Vehicles, r.vehicle())
+	}
+
+	rankFiles, err := filepath.Glob(filepath.Join(dir, "rank-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rankFiles)
+	if len(rankFiles) != cp.Root.WorldSize-1 {
+		return nil, fmt.Errorf("expected %d rank files, found %d", cp.Root.WorldSize-1, len(rankFiles))
+	}
+
+	for _, rankFile := range rankFiles {
+		jBytes, err := os.ReadFile(rankFile)
+		if err != nil {
+			return nil, err
+		}
+		var rc RankCheckpoint
+		if err := json.Unmarshal(jBytes, &rc); err != nil {
+			return nil, fmt.Errorf("%s: %w", rankFile, err)
+		}
+		for _, r := range rc.Vehicles {
+			cp.Vehicles = append(cp.Vehicles, r.vehicle())
+		}
+		tables = append(tables, rc.EdgeStats)
+		cp.Parking = append(cp.Parking, rc.Parking...)
+	}
+
+	cp.EdgeStats = MergeEdgeIntervals(tables...)
+	return &cp, nil
+}
@@ -0,0 +1,116 @@
+package streets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointGate(t *testing.T) {
+	var gate CheckpointGate
+	assert.False(t, gate.IsPaused())
+
+	gate.Started()
+	gate.Pause()
+	assert.True(t, gate.IsPaused())
+	gate.Hold(Vehicle{ID: "a"})
+	gate.Finished()
+	assert.Equal(t, 1, gate.Held())
+	assert.Equal(t, 0, gate.Active())
+
+	held := gate.Resume()
+	assert.False(t, gate.IsPaused())
+	assert.Equal(t, 0, gate.Held())
+	assert.Len(t, held, 1)
+	assert.Equal(t, "a", held[0].ID)
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	g := lineGraph(t)
+	dir := t.TempDir()
+
+	v, err := NewVehicleBuilder().WithID("a").WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).WithDeparture(5).Build()
+	assert.NoError(t, err)
+	v.Step()
+	v.Leaves = []int{1}
+
+	unreleased, err := NewVehicleBuilder().WithID("b").WithGraph(g).WithPathIDs([]int{2, 3}).WithSpeed(3).
+		WithLastID(2).WithNextID(3).WithDeparture(50).Build()
+	assert.NoError(t, err)
+
+	var gate CheckpointGate
+	gate.Pause()
+	gate.Hold(v)
+
+	rows := []EdgeInterval{{From: 1, To: 2, Interval: 0, Entries: 1, Exits: 1}}
+	assert.NoError(t, PrepareCheckpointDir(dir))
+	assert.NoError(t, gate.WriteRankCheckpoint(dir, 1, rows, nil))
+	assert.NoError(t, WriteRootCheckpoint(dir, RootCheckpoint{WorldSize: 2, Seed: 42, Clock: 50, Parked: 3, EdgeStats: rows},
+		[]Vehicle{unreleased}))
+
+	cp, err := LoadCheckpoint(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), cp.Root.Seed)
+	assert.Equal(t, 50., cp.Root.Clock)
+	assert.Equal(t, 3, cp.Root.Parked)
+	assert.Len(t, cp.Vehicles, 2)
+
+	assert.Equal(t, "b", cp.Vehicles[0].ID)
+	resumed := cp.Vehicles[1]
+	assert.Equal(t, v.ID, resumed.ID)
+	assert.Equal(t, v.PrevID, resumed.PrevID)
+	assert.Equal(t, v.NextID, resumed.NextID)
+	assert.Equal(t, v.Delta, resumed.Delta)
+	assert.Equal(t, v.Time, resumed.Time)
+	assert.Equal(t, v.Distance, resumed.Distance)
+	assert.Equal(t, []int{1}, resumed.Leaves)
+
+	assert.Len(t, cp.EdgeStats, 1)
+	assert.Equal(t, 2, cp.EdgeStats[0].Entries)
+
+	// a new checkpoint removes the old one, an interrupted checkpoint is not loaded
+	assert.NoError(t, PrepareCheckpointDir(dir))
+	_, err = LoadCheckpoint(dir)
+	assert.Error(t, err)
+}
+
+func TestResumeTripWriter(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"trips.csv", "trips.jsonl"} {
+		path := filepath.Join(dir, name)
+		tw, err := NewTripWriter(path)
+		assert.NoError(t, err)
+		for _, id := range []string{"a", "b", "c"} {
+			assert.NoError(t, tw.Write(TripRecord{ID: id}))
+		}
+		assert.NoError(t, tw.Close())
+
+		// the checkpoint was taken after two records
+		tw, err = ResumeTripWriter(path, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, tw.Count())
+		assert.NoError(t, tw.Write(TripRecord{ID: "d"}))
+		assert.NoError(t, tw.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if strings.HasSuffix(name, ".csv") {
+			assert.Len(t, lines, 4)
+			assert.True(t, strings.HasPrefix(lines[0], "id,"))
+			lines = lines[1:]
+		}
+		assert.Len(t, lines, 3)
+		assert.Contains(t, lines[1], "b")
+		assert.Contains(t, lines[2], "d")
+		assert.NotContains(t, string(content), `"c"`)
+	}
+
+	_, err := ResumeTripWriter(filepath.Join(dir, "trips.jsonl"), 10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package streets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the simulated clock. One simulated second is one vehicle step tick.
+// With a scale > 0 simulated time follows wall time, scale simulated seconds per wall second.
+// With a scale of 0 the clock is virtual and jumps to whatever time is waited for.
+type Clock struct {
+	start  time.Time
+	offset float64
+	scale  float64
+
+	mu  sync.Mutex
+	now float64
+}
+
+// NewClock returns a clock starting at simulated time 0
+func NewClock(scale float64) *Clock {
+	return NewClockAt(scale, 0)
+}
+
+// NewClockAt returns a clock starting at the simulated time start, e.g. when resuming a checkpoint
+func NewClockAt(scale, start float64) *Clock {
+	return &Clock{start: time.Now(), offset: start, scale: scale, now: start}
+}
+
+// IsRealtime reports whether the clock is bound to wall time
+func (c *Clock) IsRealtime() bool {
+	return c.scale > 0
+}
+
+// Now returns the current simulated time in seconds
+func (c *Clock) Now() float64 {
+	if c.IsRealtime() {
+		return c.offset + time.Since(c.start).Seconds()*c.scale
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// WaitUntil blocks until the simulated time t is reached
+func (c *Clock) WaitUntil(t float64) {
+	if !c.IsRealtime() {
+		c.mu.Lock()
+		if t > c.now {
+			c.now = t
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	if wait := c.WallUntil(t); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// WaitUntilDone blocks until the simulated time t is reached or ctx is done
+func (c *Clock) WaitUntilDone(ctx context.Context, t float64) {
+	if !c.IsRealtime() {
+		c.WaitUntil(t)
+		return
+	}
+
+	wait := c.WallUntil(t)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// WallUntil returns the wall time until the simulated time t is reached, 0 for a virtual clock
+func (c *Clock) WallUntil(t float64) time.Duration {
+	if !c.IsRealtime() {
+		return 0
+	}
+	return time.Duration((t - c.Now()) / c.scale * float64(time.Second))
+}
@@ -0,0 +1,573 @@
+package streets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CodecVersion is the version of the binary message layout, it changes with every change of a message
+const CodecVersion = 3
+
+// MessageType identifies the payload of a message
+type MessageType uint8
+
+// types of the messages exchanged between ranks
+const (
+	MsgVehicle MessageType = iota + 1
+	MsgVehicleBatch
+	MsgStop
+	MsgEdgeRequest
+	MsgEdgeLength
+	MsgTripRecord
+	MsgEdgeStats
+	MsgCheckpointCommand
+	MsgCheckpointReply
+	MsgParkingEvents
+	MsgPositions
+	MsgPhases
+)
+
+// envelopeSize is the size of the header of every message: type, version and the length of the body
+const envelopeSize = 1 + 1 + 4
+
+var (
+	// ErrMessageType is returned when a message of another type is decoded
+	ErrMessageType = errors.New("unexpected message type")
+	// ErrCodecVersion is returned for messages written by another version of the codec
+	ErrCodecVersion = errors.New("unsupported message version")
+	// errShortMessage is returned when a message ends before its last field
+	errShortMessage = fmt.Errorf("%w: message is too short", ErrMalformedInput)
+)
+
+// encoder appends fields in a fixed little endian layout. Variable length fields are prefixed by their count.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) boolean(v bool) {
+	if v {
+		e.u8(1)
+	} else {
+		e.u8(0)
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) i64(v int64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) int(v int) {
+	e.i64(int64(v))
+}
+
+func (e *encoder) f64(v float64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.u32(uint32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) str(v string) {
+	e.u32(uint32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) ints(v []int) {
+	e.u32(uint32(len(v)))
+	for _, i := range v {
+		e.int(i)
+	}
+}
+
+func (e *encoder) floats(v []float64) {
+	e.u32(uint32(len(v)))
+	for _, f := range v {
+		e.f64(f)
+	}
+}
+
+// route writes a path as zigzag varint deltas, neighbouring vertices mostly have close IDs
+func (e *encoder) route(v []int) {
+	e.u32(uint32(len(v)))
+	prev := 0
+	for _, id := range v {
+		e.buf = binary.AppendVarint(e.buf, int64(id-prev))
+		prev = id
+	}
+}
+
+func (e *encoder) strs(v []string) {
+	e.u32(uint32(len(v)))
+	for _, s := range v {
+		e.str(s)
+	}
+}
+
+// decoder reads the fields written by encoder. The first error sticks, later reads return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) boolean() bool {
+	b := d.u8()
+	if b > 1 && d.err == nil {
+		d.err = fmt.Errorf("%w: invalid bool %d", ErrMalformedInput, b)
+	}
+	return b == 1
+}
+
+func (d *decoder) u32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) i64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func (d *decoder) int() int {
+	return int(d.i64())
+}
+
+func (d *decoder) f64() float64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// count reads the number of elements of a slice, each at least size bytes long
+func (d *decoder) count(size int) int {
+	n := int(d.u32())
+	if d.err == nil && n > len(d.data)/size {
+		// checked before allocating, so that a corrupt count cannot allocate gigabytes
+		d.err = errShortMessage
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count(1)
+	return d.take(n)
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) ints() []int {
+	n := d.count(8)
+	if n == 0 {
+		return nil
+	}
+	v := make([]int, n)
+	for i := range v {
+		v[i] = d.int()
+	}
+	return v
+}
+
+func (d *decoder) floats() []float64 {
+	n := d.count(8)
+	if n == 0 {
+		return nil
+	}
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = d.f64()
+	}
+	return v
+}
+
+func (d *decoder) route() []int {
+	n := d.count(1)
+	if n == 0 {
+		return nil
+	}
+	v := make([]int, n)
+	prev := 0
+	for i := range v {
+		if d.err != nil {
+			return nil
+		}
+		delta, size := binary.Varint(d.data)
+		var canonical [binary.MaxVarintLen64]byte
+		if size <= 0 || size != binary.PutVarint(canonical[:], delta) {
+			d.err = fmt.Errorf("%w: delta of vertex %d of the route", ErrMalformedInput, i)
+			return nil
+		}
+		d.data = d.data[size:]
+		prev += int(delta)
+		v[i] = prev
+	}
+	return v
+}
+
+func (d *decoder) strs() []string {
+	n := d.count(4)
+	if n == 0 {
+		return nil
+	}
+	v := make([]string, n)
+	for i := range v {
+		v[i] = d.str()
+	}
+	return v
+}
+
+// seal writes the envelope and the body of a message
+func seal(t MessageType, body func(e *encoder)) []byte {
+	e := &encoder{buf: make([]byte, envelopeSize, 256)}
+	body(e)
+	e.buf[0] = uint8(t)
+	e.buf[1] = CodecVersion
+	binary.LittleEndian.PutUint32(e.buf[2:envelopeSize], uint32(len(e.buf)-envelopeSize))
+	return e.buf
+}
+
+// openEnvelope checks the envelope of a message and returns a decoder of its body
+func openEnvelope(data []byte, t MessageType) (*decoder, error) {
+	if len(data) < envelopeSize {
+		return nil, errShortMessage
+	}
+	if MessageType(data[0]) != t {
+		return nil, fmt.Errorf("%w: %d instead of %d", ErrMessageType, data[0], t)
+	}
+	if data[1] != CodecVersion {
+		return nil, fmt.Errorf("%w: %d", ErrCodecVersion, data[1])
+	}
+	length := binary.LittleEndian.Uint32(data[2:envelopeSize])
+	if uint64(length) != uint64(len(data)-envelopeSize) {
+		return nil, fmt.Errorf("%w: message body has %d bytes, the envelope announces %d", ErrMalformedInput, len(data)-envelopeSize, length)
+	}
+	return &decoder{data: data[envelopeSize:]}, nil
+}
+
+// close returns the first error of the decoder or an error if bytes are left over
+func (d *decoder) close() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("%w: %d bytes left after the message", ErrMalformedInput, len(d.data))
+	}
+	return nil
+}
+
+// messageType returns the type of a message without decoding it
+func messageType(data []byte) MessageType {
+	if len(data) == 0 {
+		return 0
+	}
+	return MessageType(data[0])
+}
+
+func (e *encoder) stopTimes(stops []StopTime) {
+	e.u32(uint32(len(stops)))
+	for _, s := range stops {
+		e.int(s.Vertex)
+		e.f64(s.Dwell)
+		e.f64(s.Scheduled)
+		e.f64(s.Arrival)
+		e.boolean(s.Reached)
+	}
+}
+
+func (d *decoder) stopTimes() []StopTime {
+	// vertex, three times and reached
+	n := d.count(8 + 3*8 + 1)
+	if n == 0 {
+		return nil
+	}
+	stops := make([]StopTime, n)
+	for i := range stops {
+		stops[i] = StopTime{Vertex: d.int(), Dwell: d.f64(), Scheduled: d.f64(), Arrival: d.f64(), Reached: d.boolean()}
+	}
+	return stops
+}
+
+func (e *encoder) vehicle(r *rawVehicle) {
+	e.str(r.ID)
+	e.route(r.PathIDs)
+	e.f64(r.Speed)
+	e.str(r.Class)
+	e.f64(r.Acceleration)
+	e.f64(r.CurrentSpeed)
+	e.f64(r.Delta)
+	e.int(r.NextID)
+	e.int(r.PrevID)
+	e.int(r.EdgeFrom)
+	e.int(r.EdgeTo)
+	e.boolean(r.IsParked)
+	e.f64(r.Departure)
+	e.f64(r.Time)
+	e.int(r.Origin)
+	e.int(r.Destination)
+	e.f64(r.Distance)
+	e.int(r.Edges)
+	e.ints(r.Leaves)
+	e.int(r.Handoffs)
+	e.f64(r.DistanceRemaining)
+	e.str(r.Line)
+	e.stopTimes(r.Stops)
+	e.int(r.NextStop)
+	e.boolean(r.Parking.Searching)
+	e.f64(r.Parking.Start)
+	e.f64(r.Parking.From)
+	e.strs(r.Parking.Tried)
+	e.str(r.Parking.Facility)
+	e.boolean(r.Stopped)
+}
+
+func (d *decoder) vehicle() rawVehicle {
+	var r rawVehicle
+	r.ID = d.str()
+	r.PathIDs = d.route()
+	r.Speed = d.f64()
+	r.Class = d.str()
+	r.Acceleration = d.f64()
+	r.CurrentSpeed = d.f64()
+	r.Delta = d.f64()
+	r.NextID = d.int()
+	r.PrevID = d.int()
+	r.EdgeFrom = d.int()
+	r.EdgeTo = d.int()
+	r.IsParked = d.boolean()
+	r.Departure = d.f64()
+	r.Time = d.f64()
+	r.Origin = d.int()
+	r.Destination = d.int()
+	r.Distance = d.f64()
+	r.Edges = d.int()
+	r.Leaves = d.ints()
+	r.Handoffs = d.int()
+	r.DistanceRemaining = d.f64()
+	r.Line = d.str()
+	r.Stops = d.stopTimes()
+	r.NextStop = d.int()
+	r.Parking.Searching = d.boolean()
+	r.Parking.Start = d.f64()
+	r.Parking.From = d.f64()
+	r.Parking.Tried = d.strs()
+	r.Parking.Facility = d.str()
+	r.Stopped = d.boolean()
+	return r
+}
+
+func (e *encoder) tripRecord(r *TripRecord) {
+	e.str(r.ID)
+	e.int(r.Origin)
+	e.int(r.Destination)
+	e.f64(r.Departure)
+	e.f64(r.Arrival)
+	e.f64(r.Distance)
+	e.int(r.Edges)
+	e.ints(r.Leaves)
+	e.int(r.Handoffs)
+	e.str(r.Class)
+	e.str(r.Line)
+	e.str(r.Facility)
+	e.f64(r.SearchTime)
+	e.f64(r.SearchDistance)
+	e.boolean(r.Stopped)
+	e.stopTimes(r.Stops)
+	e.boolean(r.Dropped)
+}
+
+func (d *decoder) tripRecord() TripRecord {
+	var r TripRecord
+	r.ID = d.str()
+	r.Origin = d.int()
+	r.Destination = d.int()
+	r.Departure = d.f64()
+	r.Arrival = d.f64()
+	r.Distance = d.f64()
+	r.Edges = d.int()
+	r.Leaves = d.ints()
+	r.Handoffs = d.int()
+	r.Class = d.str()
+	r.Line = d.str()
+	r.Facility = d.str()
+	r.SearchTime = d.f64()
+	r.SearchDistance = d.f64()
+	r.Stopped = d.boolean()
+	r.Stops = d.stopTimes()
+	r.Dropped = d.boolean()
+	return r
+}
+
+// encodeEdgeIntervals and the other slice encoders write a count followed by fixed layout elements
+func encodeEdgeIntervals(rows []EdgeInterval) []byte {
+	return seal(MsgEdgeStats, func(e *encoder) {
+		e.u32(uint32(len(rows)))
+		for _, r := range rows {
+			e.str(r.EdgeID)
+			e.int(r.From)
+			e.int(r.To)
+			e.f64(r.Length)
+			e.int(r.Interval)
+			e.int(r.Entries)
+			e.int(r.Exits)
+			e.f64(r.Occupancy)
+			e.f64(r.Distance)
+			e.f64(r.Covered)
+		}
+	})
+}
+
+func decodeEdgeIntervals(data []byte) ([]EdgeInterval, error) {
+	d, err := openEnvelope(data, MsgEdgeStats)
+	if err != nil {
+		return nil, err
+	}
+	var rows []EdgeInterval
+	// id and nine numbers
+	if n := d.count(4 + 9*8); n > 0 {
+		rows = make([]EdgeInterval, n)
+		for i := range rows {
+			rows[i] = EdgeInterval{EdgeID: d.str(), From: d.int(), To: d.int(), Length: d.f64(), Interval: d.int(),
+				Entries: d.int(), Exits: d.int(), Occupancy: d.f64(), Distance: d.f64(), Covered: d.f64()}
+		}
+	}
+	return rows, d.close()
+}
+
+func encodeParkingEvents(events []ParkingEvent) []byte {
+	return seal(MsgParkingEvents, func(e *encoder) {
+		e.u32(uint32(len(events)))
+		for _, ev := range events {
+			e.str(ev.Facility)
+			e.str(ev.Vehicle)
+			e.f64(ev.Time)
+			e.f64(ev.Until)
+			e.boolean(ev.Rejected)
+		}
+	})
+}
+
+func decodeParkingEvents(data []byte) ([]ParkingEvent, error) {
+	d, err := openEnvelope(data, MsgParkingEvents)
+	if err != nil {
+		return nil, err
+	}
+	var events []ParkingEvent
+	// facility, vehicle, two times and rejected
+	if n := d.count(4 + 4 + 2*8 + 1); n > 0 {
+		events = make([]ParkingEvent, n)
+		for i := range events {
+			events[i] = ParkingEvent{Facility: d.str(), Vehicle: d.str(), Time: d.f64(), Until: d.f64(), Rejected: d.boolean()}
+		}
+	}
+	return events, d.close()
+}
+
+func encodePositions(positions []VehiclePosition) []byte {
+	return seal(MsgPositions, func(e *encoder) {
+		e.u32(uint32(len(positions)))
+		for _, p := range positions {
+			e.str(p.ID)
+			e.f64(p.X)
+			e.f64(p.Y)
+			e.f64(p.Time)
+			e.int(p.Rank)
+		}
+	})
+}
+
+func decodePositions(data []byte) ([]VehiclePosition, error) {
+	d, err := openEnvelope(data, MsgPositions)
+	if err != nil {
+		return nil, err
+	}
+	var positions []VehiclePosition
+	// id, three coordinates and rank
+	if n := d.count(4 + 4*8); n > 0 {
+		positions = make([]VehiclePosition, n)
+		for i := range positions {
+			positions[i] = VehiclePosition{ID: d.str(), X: d.f64(), Y: d.f64(), Time: d.f64(), Rank: d.int()}
+		}
+	}
+	return positions, d.close()
+}
+
+func encodeRankPhases(rp *RankPhases) []byte {
+	return seal(MsgPhases, func(e *encoder) {
+		e.int(rp.Rank)
+		phases := make([]string, 0, len(rp.Phases))
+		for phase := range rp.Phases {
+			phases = append(phases, phase)
+		}
+		sort.Strings(phases)
+		e.u32(uint32(len(phases)))
+		for _, phase := range phases {
+			e.str(phase)
+			e.f64(rp.Phases[phase])
+		}
+		e.f64(rp.Communication)
+		e.f64(rp.Total)
+		e.floats(rp.Workers)
+	})
+}
+
+func decodeRankPhases(data []byte) (RankPhases, error) {
+	var rp RankPhases
+	d, err := openEnvelope(data, MsgPhases)
+	if err != nil {
+		return rp, err
+	}
+	rp.Rank = d.int()
+	n := d.count(4 + 8)
+	rp.Phases = make(map[string]float64, n)
+	for i := 0; i < n; i++ {
+		phase := d.str()
+		rp.Phases[phase] = d.f64()
+	}
+	rp.Communication = d.f64()
+	rp.Total = d.f64()
+	rp.Workers = d.floats()
+	return rp, d.close()
+}
@@ -0,0 +1,202 @@
+package streets
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// codecVehicle is a vehicle with every field of the wire format set
+func codecVehicle() Vehicle {
+	return Vehicle{
+		ID: "bus-1", Route: NewRoute([]int{1, 2, 3, 4, 5, 6, 7, 8}), Speed: 13.9, Class: "bus", Acceleration: 1.2,
+		CurrentSpeed: 8.5, Delta: 0.5, NextID: 3, PrevID: 2, EdgeFrom: 2, EdgeTo: 3, Departure: 30, Time: 95.5,
+		Origin: 1, Destination: 8, Distance: 420, Edges: 2, Leaves: []int{1, 3}, Handoffs: 1, DistanceRemaining: 12,
+		Line: "L1", Stops: []StopTime{{Vertex: 4, Dwell: 20, Scheduled: 120, Arrival: 118, Reached: true}}, NextStop: 1,
+		Parking: ParkingSearch{Searching: true, Start: 90, From: 400, Tried: []string{"P1"}, Facility: "P2"},
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	v := codecVehicle()
+	vBytes, err := v.Marshal()
+	assert.NoError(t, err)
+	decoded, err := UnmarshalVehicle(vBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, v, decoded)
+
+	edge := EdgePackage{Src: 3, Dest: 9}
+	eBytes, _ := edge.Marshal()
+	decodedEdge, err := UnmarshalEdgePackage(eBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, edge, decodedEdge)
+
+	length := LengthFloat{Length: 12.25}
+	lBytes, _ := length.Marshal()
+	decodedLength, err := UnmarshalLengthFloat(lBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, length, decodedLength)
+
+	reply := CheckpointReply{Rank: 2, Held: 5, Active: 1, Err: "disk full"}
+	rBytes, _ := reply.Marshal()
+	decodedReply, err := UnmarshalCheckpointReply(rBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, reply, decodedReply)
+
+	rows := []EdgeInterval{{EdgeID: "a", From: 1, To: 2, Length: 10, Interval: 3, Entries: 4, Exits: 2, Occupancy: 7.5, Distance: 30}}
+	sBytes, _ := MarshalEdgeIntervals(rows)
+	decodedRows, err := UnmarshalEdgeIntervals(sBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, rows, decodedRows)
+
+	phases := RankPhases{Rank: 1, Phases: map[string]float64{PhaseSimulation: 2.5, PhaseShutdown: 0.1}, Communication: 0.4, Total: 3, Workers: []float64{0.9, 0.7}}
+	pBytes, _ := phases.Marshal()
+	decodedPhases, err := UnmarshalRankPhases(pBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, phases, decodedPhases)
+}
+
+func TestVehicle_Remaining(t *testing.T) {
+	// the ring is driven once and a half, vertices 1 and 2 are passed twice
+	g := ringGraph(t)
+	v, err := g.newVehicleOnPath([]int{1, 2, 3, 4, 1, 2, 3}, 5, 0, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		v.Step()
+	}
+	assert.Equal(t, 4, v.PathIndex)
+	assert.Equal(t, 1, v.PrevID)
+	assert.Equal(t, 2, v.NextID)
+	assert.Equal(t, []int{1, 2, 3}, v.Remaining())
+	v.Drive()
+	assert.Equal(t, 6, v.Edges)
+	assert.Equal(t, 60., v.Distance)
+
+	// only the remaining route is sent
+	full := codecVehicle()
+	fullBytes, _ := full.Marshal()
+	driven := codecVehicle()
+	driven.PathIndex = 5
+	drivenBytes, _ := driven.Marshal()
+	assert.Less(t, len(drivenBytes), len(fullBytes))
+	decoded, err := UnmarshalVehicle(drivenBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{6, 7, 8}, decoded.PathIDs)
+	assert.Equal(t, 0, decoded.PathIndex)
+}
+
+func TestCodec_Envelope(t *testing.T) {
+	edge := EdgePackage{Src: 3, Dest: 9}
+	data, _ := edge.Marshal()
+
+	if _, err := UnmarshalLengthFloat(data); !errors.Is(err, ErrMessageType) {
+		t.Errorf("expected ErrMessageType, got %v", err)
+	}
+
+	future := append([]byte{}, data...)
+	future[1] = CodecVersion + 1
+	if _, err := UnmarshalEdgePackage(future); !errors.Is(err, ErrCodecVersion) {
+		t.Errorf("expected ErrCodecVersion, got %v", err)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+	} {
+		if _, err := UnmarshalEdgePackage(data); !errors.Is(err, ErrMalformedInput) {
+			t.Errorf("%s: expected ErrMalformedInput, got %v", name, err)
+		}
+	}
+}
+
+// fuzzSeeds adds valid messages of every type to the corpus of a fuzz test
+func fuzzSeeds(f *testing.F) {
+	v := codecVehicle()
+	vBytes, _ := v.Marshal()
+	record := v.TripRecord()
+	rBytes, _ := record.Marshal()
+	eBytes, _ := MarshalEdgeIntervals([]EdgeInterval{{EdgeID: "a", From: 1, To: 2}})
+	pBytes, _ := MarshalParkingEvents([]ParkingEvent{{Facility: "P1", Vehicle: "a", Time: 3}})
+	f.Add(vBytes)
+	f.Add(rBytes)
+	f.Add(eBytes)
+	f.Add(pBytes)
+	f.Add(EncodeVehicleBatch([][]byte{vBytes, vBytes}))
+	f.Add(stopMarker)
+}
+
+func FuzzUnmarshalVehicle(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, err := UnmarshalVehicle(data)
+		if err != nil {
+			return
+		}
+		// a decoded vehicle encodes to the same bytes
+		again, _ := v.Marshal()
+		if !bytes.Equal(data, again) {
+			t.Errorf("vehicle %q does not encode to the decoded bytes", v.ID)
+		}
+	})
+}
+
+func FuzzDecodeVehicleBatch(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = DecodeVehicleBatch(data)
+	})
+}
+
+func FuzzUnmarshalMessages(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = UnmarshalTripRecord(data)
+		_, _ = UnmarshalEdgeIntervals(data)
+		_, _ = UnmarshalParkingEvents(data)
+		_, _ = UnmarshalPositions(data)
+		_, _ = UnmarshalRankPhases(data)
+		_, _ = UnmarshalCheckpointCommand(data)
+		_, _ = UnmarshalCheckpointReply(data)
+		_, _ = UnmarshalEdgePackage(data)
+		_, _ = UnmarshalLengthFloat(data)
+	})
+}
+
+// BenchmarkVehicleCodec compares the codec with the gob encoding the messages used before
+func BenchmarkVehicleCodec(b *testing.B) {
+	v := codecVehicle()
+
+	b.Run("binary", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, _ := v.Marshal()
+			if _, err := UnmarshalVehicle(data); err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "bytes/msg")
+	})
+
+	b.Run("gob", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			// a fresh encoder per message, as every message is sent on its own
+			var buf bytes.Buffer
+			raw := v.raw()
+			if err := gob.NewEncoder(&buf).Encode(raw); err != nil {
+				b.Fatal(err)
+			}
+			size = buf.Len()
+			var decoded rawVehicle
+			if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(size), "bytes/msg")
+	})
+}
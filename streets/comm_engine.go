@@ -0,0 +1,275 @@
+package streets
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	mpi "github.com/sbromberger/gompi"
+)
+
+// DefaultEnginePoll is how long an idle engine waits before it probes for messages again
+const DefaultEnginePoll = 100 * time.Microsecond
+
+// engineInboxSize is the number of received messages of a tag buffered in its channel
+const engineInboxSize = 64
+
+// LeafTags are the tags a leaf receives while it simulates
+var LeafTags = []int{VEHICLE_IN_LEAF_TAG, RECEIVE_EDGE, CHECKPOINT_TAG}
+
+// RootTags are the tags the root receives while the leaves simulate
+var RootTags = []int{REQUEST_EDGE, VEHICLE_OUT_TAG, TRIP_RECORD_TAG, CHECKPOINT_REPLY_TAG, POSITIONS_TAG}
+
+// Message is a message received by a CommEngine
+type Message struct {
+	Source int
+	Data   []byte
+}
+
+// transport is the point to point part of a communicator, only the goroutines of the engine use it.
+// send is called from the sender goroutine, probe and recv from the receiving one.
+type transport interface {
+	send(data []byte, dest, tag int)
+	// probe reports whether a message of tag is waiting and returns its source
+	probe(tag int) (int, bool)
+	recv(source, tag int) []byte
+}
+
+// mpiTransport sends and receives with the communicator of an MPI process
+type mpiTransport struct {
+	m *MPI
+}
+
+func (t mpiTransport) send(data []byte, dest, tag int) {
+	start := time.Now()
+	t.m.comm.SendBytes(data, dest, tag)
+	t.m.phases.communicated(time.Since(start))
+}
+
+func (t mpiTransport) probe(tag int) (int, bool) {
+	ok, status := t.m.comm.Iprobe(mpi.AnySource, tag)
+	if !ok {
+		return 0, false
+	}
+	return status.GetSource(), true
+}
+
+func (t mpiTransport) recv(source, tag int) []byte {
+	data, _ := t.m.comm.RecvBytes(source, tag)
+	return data
+}
+
+type outgoingMessage struct {
+	data      []byte
+	dest, tag int
+}
+
+// engineInbox holds the received messages of a tag until the simulation takes them
+type engineInbox struct {
+	ch      chan Message
+	pending []Message
+
+	// left are the messages that did not fit into ch when the engine stopped, they are taken after ch is drained
+	mu   sync.Mutex
+	left []Message
+}
+
+// takeLeft returns the next message left when the engine stopped
+func (inbox *engineInbox) takeLeft() (Message, bool) {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+	if len(inbox.left) == 0 {
+		return Message{}, false
+	}
+	msg := inbox.left[0]
+	inbox.left = inbox.left[1:]
+	return msg, true
+}
+
+// CommEngine owns the communicator of a rank while it simulates.
+// Sends are queued and received messages are dispatched to a channel per tag, so vehicles keep
+// driving while the engine communicates. gompi has no MPI_Isend, the engine probes with
+// MPI_Iprobe and only receives messages that have arrived. Sends are posted from a goroutine of
+// their own: a send above the eager limit blocks until the peer receives it, and two ranks sending
+// to each other must both keep receiving meanwhile.
+type CommEngine struct {
+	t       transport
+	tags    []int
+	inboxes map[int]*engineInbox
+	out     chan outgoingMessage
+	poll    time.Duration
+
+	// mu keeps Send from queueing messages once Stop has begun
+	mu      sync.RWMutex
+	running bool
+	sent    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newCommEngine(t transport, tags []int, poll time.Duration) *CommEngine {
+	e := &CommEngine{
+		t:       t,
+		tags:    tags,
+		inboxes: make(map[int]*engineInbox, len(tags)),
+		out:     make(chan outgoingMessage, engineInboxSize),
+		poll:    poll,
+		running: true,
+		sent:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, tag := range tags {
+		e.inboxes[tag] = &engineInbox{ch: make(chan Message, engineInboxSize)}
+	}
+	go e.sender()
+	go e.run()
+	return e
+}
+
+// Send queues a message, it returns false if the engine has stopped and the message must be sent directly
+func (e *CommEngine) Send(data []byte, dest, tag int) bool {
+	if e == nil {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.running {
+		return false
+	}
+	e.out <- outgoingMessage{data: data, dest: dest, tag: tag}
+	return true
+}
+
+// Receive waits for the next message of tag. It returns false if the engine does not receive tag
+// or has stopped and every message it received is taken, the message must then be received directly.
+func (e *CommEngine) Receive(tag int) (Message, bool) {
+	if e == nil {
+		return Message{}, false
+	}
+	inbox, ok := e.inboxes[tag]
+	if !ok {
+		return Message{}, false
+	}
+	if msg, ok := <-inbox.ch; ok {
+		return msg, true
+	}
+	return inbox.takeLeft()
+}
+
+// Stop sends the queued messages and ends the engine, it keeps receiving until they are sent. Receivers
+// take the messages the engine received before they fall back to receiving directly.
+func (e *CommEngine) Stop() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.running {
+		e.mu.Unlock()
+		<-e.done
+		return
+	}
+	e.running = false
+	e.mu.Unlock()
+	close(e.out)
+	<-e.sent
+	close(e.stop)
+	<-e.done
+}
+
+// sender posts the queued messages in order until Stop closes the queue
+func (e *CommEngine) sender() {
+	defer close(e.sent)
+	for msg := range e.out {
+		e.t.send(msg.data, msg.dest, msg.tag)
+	}
+}
+
+func (e *CommEngine) run() {
+	defer close(e.done)
+
+	timer := time.NewTimer(e.poll)
+	defer timer.Stop()
+	for {
+		busy := e.deliver()
+		select {
+		case <-e.stop:
+			e.shutdown()
+			return
+		default:
+		}
+		if e.receive() {
+			busy = true
+		}
+		if busy {
+			continue
+		}
+
+		// idle until it is time to probe again
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(e.poll)
+		select {
+		case <-timer.C:
+		case <-e.stop:
+			e.shutdown()
+			return
+		}
+	}
+}
+
+// receive takes the arrived messages of every tag, it reports whether any arrived
+func (e *CommEngine) receive() bool {
+	received := false
+	for _, tag := range e.tags {
+		source, ok := e.t.probe(tag)
+		if !ok {
+			continue
+		}
+		inbox := e.inboxes[tag]
+		inbox.pending = append(inbox.pending, Message{Source: source, Data: e.t.recv(source, tag)})
+		received = true
+	}
+	return received
+}
+
+// deliver moves pending messages into the channels that have room, it never blocks, so that a
+// receiver waiting for another tag cannot stall the engine
+func (e *CommEngine) deliver() bool {
+	delivered := false
+	for _, tag := range e.tags {
+		inbox := e.inboxes[tag]
+		for len(inbox.pending) > 0 {
+			select {
+			case inbox.ch <- inbox.pending[0]:
+				inbox.pending[0] = Message{}
+				inbox.pending = inbox.pending[1:]
+				delivered = true
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return delivered
+}
+
+// shutdown closes the channels of all tags, the received messages that were not taken yet are kept
+// for the next receives
+func (e *CommEngine) shutdown() {
+	for _, tag := range e.tags {
+		inbox := e.inboxes[tag]
+		if n := len(inbox.pending); n > 0 {
+			log.Debug().Msgf("keeping %d received messages of tag %d that were not taken", n, tag)
+		}
+		inbox.mu.Lock()
+		inbox.left = inbox.pending
+		inbox.pending = nil
+		inbox.mu.Unlock()
+		close(inbox.ch)
+	}
+}
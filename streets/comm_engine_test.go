@@ -0,0 +1,244 @@
+package streets
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransport delivers queued messages and records sent ones. It fails the test if two sends or two
+// receives are made concurrently.
+type fakeTransport struct {
+	t         *testing.T
+	sending   atomic.Int32
+	receiving atomic.Int32
+
+	mu      sync.Mutex
+	arrived map[int][]Message
+	sent    []outgoingMessage
+}
+
+func newFakeTransport(t *testing.T) *fakeTransport {
+	return &fakeTransport{t: t, arrived: make(map[int][]Message)}
+}
+
+func (f *fakeTransport) enter(inFlight *atomic.Int32) func() {
+	if inFlight.Add(1) > 1 {
+		f.t.Errorf("transport is called concurrently")
+	}
+	return func() { inFlight.Add(-1) }
+}
+
+func (f *fakeTransport) arrive(tag int, msg Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.arrived[tag] = append(f.arrived[tag], msg)
+}
+
+func (f *fakeTransport) send(data []byte, dest, tag int) {
+	defer f.enter(&f.sending)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, outgoingMessage{data: data, dest: dest, tag: tag})
+}
+
+func (f *fakeTransport) probe(tag int) (int, bool) {
+	defer f.enter(&f.receiving)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.arrived[tag]) == 0 {
+		return 0, false
+	}
+	return f.arrived[tag][0].Source, true
+}
+
+func (f *fakeTransport) recv(source, tag int) []byte {
+	defer f.enter(&f.receiving)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msg := f.arrived[tag][0]
+	f.arrived[tag] = f.arrived[tag][1:]
+	if msg.Source != source {
+		f.t.Errorf("received from %d, probed %d", source, msg.Source)
+	}
+	return msg.Data
+}
+
+func (f *fakeTransport) sentCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent)
+}
+
+func TestCommEngine_Dispatch(t *testing.T) {
+	ft := newFakeTransport(t)
+	e := newCommEngine(ft, []int{1, 2}, time.Millisecond)
+	defer e.Stop()
+
+	// more messages of tag 1 than its channel holds must not keep tag 2 from being delivered
+	for i := 0; i < engineInboxSize+10; i++ {
+		ft.arrive(1, Message{Source: 3, Data: []byte{byte(i)}})
+	}
+	ft.arrive(2, Message{Source: 4, Data: []byte("b")})
+
+	msg, ok := e.Receive(2)
+	assert.True(t, ok)
+	assert.Equal(t, Message{Source: 4, Data: []byte("b")}, msg)
+
+	for i := 0; i < engineInboxSize+10; i++ {
+		msg, ok := e.Receive(1)
+		assert.True(t, ok)
+		assert.Equal(t, 3, msg.Source)
+		assert.Equal(t, []byte{byte(i)}, msg.Data)
+	}
+
+	_, ok = e.Receive(5)
+	assert.False(t, ok)
+}
+
+func TestCommEngine_Stop(t *testing.T) {
+	ft := newFakeTransport(t)
+	e := newCommEngine(ft, []int{1}, time.Millisecond)
+
+	received := make(chan bool)
+	go func() {
+		_, ok := e.Receive(1)
+		received <- ok
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.True(t, e.Send([]byte{1}, 0, 2))
+			}
+		}()
+	}
+	wg.Wait()
+	e.Stop()
+
+	// every queued message is sent before Stop returns
+	assert.Equal(t, 800, ft.sentCount())
+	assert.False(t, e.Send([]byte{1}, 0, 2))
+	// a waiting receiver falls back to receiving directly
+	assert.False(t, <-received)
+	e.Stop()
+
+	var stopped *CommEngine
+	assert.False(t, stopped.Send(nil, 0, 1))
+	_, ok := stopped.Receive(1)
+	assert.False(t, ok)
+	stopped.Stop()
+}
+
+func TestCommEngine_StopKeepsReceived(t *testing.T) {
+	ft := newFakeTransport(t)
+	e := newCommEngine(ft, []int{1}, time.Millisecond)
+
+	// more messages than the channel holds arrive before anybody receives them
+	n := engineInboxSize + 10
+	for i := 0; i < n; i++ {
+		ft.arrive(1, Message{Source: 3, Data: []byte{byte(i)}})
+	}
+	assert.Eventually(t, func() bool {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		return len(ft.arrived[1]) == 0
+	}, time.Second, time.Millisecond)
+	e.Stop()
+
+	// every received message is still taken in order before receivers fall back to the communicator
+	for i := 0; i < n; i++ {
+		msg, ok := e.Receive(1)
+		if !assert.True(t, ok, "message %d", i) {
+			return
+		}
+		assert.Equal(t, []byte{byte(i)}, msg.Data)
+	}
+	_, ok := e.Receive(1)
+	assert.False(t, ok)
+}
+
+// rendezvousTransport is one end of a connection whose sends block until the other end received them,
+// like MPI sends above the eager limit
+type rendezvousTransport struct {
+	rank int
+	peer *rendezvousTransport
+
+	mu      sync.Mutex
+	arrived map[int][]rendezvousMessage
+}
+
+type rendezvousMessage struct {
+	Message
+	received chan struct{}
+}
+
+func newRendezvousPair() (*rendezvousTransport, *rendezvousTransport) {
+	a := &rendezvousTransport{rank: 0, arrived: make(map[int][]rendezvousMessage)}
+	b := &rendezvousTransport{rank: 1, arrived: make(map[int][]rendezvousMessage)}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (r *rendezvousTransport) send(data []byte, dest, tag int) {
+	msg := rendezvousMessage{Message: Message{Source: r.rank, Data: data}, received: make(chan struct{})}
+	r.peer.mu.Lock()
+	r.peer.arrived[tag] = append(r.peer.arrived[tag], msg)
+	r.peer.mu.Unlock()
+	<-msg.received
+}
+
+func (r *rendezvousTransport) probe(tag int) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.arrived[tag]) == 0 {
+		return 0, false
+	}
+	return r.arrived[tag][0].Source, true
+}
+
+func (r *rendezvousTransport) recv(source, tag int) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg := r.arrived[tag][0]
+	r.arrived[tag] = r.arrived[tag][1:]
+	close(msg.received)
+	return msg.Data
+}
+
+func TestCommEngine_SendToEachOther(t *testing.T) {
+	ta, tb := newRendezvousPair()
+	a := newCommEngine(ta, []int{1}, time.Millisecond)
+	b := newCommEngine(tb, []int{1}, time.Millisecond)
+
+	// both engines are blocked in a send until the other one receives
+	assert.True(t, a.Send([]byte("a"), 1, 1))
+	assert.True(t, b.Send([]byte("b"), 0, 1))
+
+	received := make(chan Message, 2)
+	go func() {
+		msg, _ := a.Receive(1)
+		received <- msg
+	}()
+	go func() {
+		msg, _ := b.Receive(1)
+		received <- msg
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			// rank 0 sent "a", rank 1 sent "b"
+			assert.Equal(t, []byte{"ab"[msg.Source]}, msg.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("engines sending to each other deadlocked")
+		}
+	}
+	a.Stop()
+	b.Stop()
+}
@@ -0,0 +1,193 @@
+package streets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"pchpc_next/utils"
+)
+
+// maxZoneSamples is the number of vertex pairs tried for a zone pair before it is considered unreachable
+const maxZoneSamples = 32
+
+// Demand is an origin-destination matrix. Trips are given either between single vertices
+// or between zones, which are named sets of vertices.
+type Demand struct {
+	Zones map[string][]int `json:"zones"`
+	Trips []ODPair         `json:"trips"`
+}
+
+// ODPair is a single entry of the origin-destination matrix
+type ODPair struct {
+	// Origin and Destination are vertex IDs, used when no zone is given
+	Origin      int `json:"origin"`
+	Destination int `json:"destination"`
+
+	// OriginZone and DestinationZone name entries of Demand.Zones
+	OriginZone      string `json:"origin_zone"`
+	DestinationZone string `json:"destination_zone"`
+
+	// Count is the number of trips
+	Count int `json:"count"`
+
+	// DepartFrom and DepartTo is the departure time window in simulated seconds
+	DepartFrom float64 `json:"depart_from"`
+	DepartTo   float64 `json:"depart_to"`
+
+	// Profile optionally shapes the departures, see ParseDepartureProfile. Uniform over the window if empty.
+	Profile string `json:"profile"`
+}
+
+func UnmarshalDemandJSON(data []byte) (Demand, error) {
+	var r Demand
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+func (r *Demand) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LoadDemandFile reads an origin-destination matrix from a JSON file
+func LoadDemandFile(path string) (Demand, error) {
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Demand{}, err
+	}
+	return UnmarshalDemandJSON(jBytes)
+}
+
+// TotalTrips returns the number of vehicles the demand generates
+func (r *Demand) TotalTrips() int {
+	total := 0
+	for _, p := range r.Trips {
+		total += p.Count
+	}
+	return total
+}
+
+// candidates returns the vertices a trip end can be placed on
+func (r *Demand) candidates(zone string, vertex int) ([]int, error) {
+	if zone == "" {
+		return []int{vertex}, nil
+	}
+	vertices, ok := r.Zones[zone]
+	if !ok || len(vertices) == 0 {
+		return nil, fmt.Errorf("zone %q is not defined or empty", zone)
+	}
+	return vertices, nil
+}
+
+// validate checks that every trip end refers to a vertex of the graph
+func (r *Demand) validate(g *StreetGraph) error {
+	for name, vertices := range r.Zones {
+		for _, v := range vertices {
+			if !g.VertexExists(v) {
+				return fmt.Errorf("zone %q: vertex %d does not exist", name, v)
+			}
+		}
+	}
+
+	for i, p := range r.Trips {
+		if p.Count < 0 {
+			return fmt.Errorf("trip %d: negative count", i)
+		}
+		if p.DepartTo < p.DepartFrom {
+			return fmt.Errorf("trip %d: departure window ends before it starts", i)
+		}
+		if p.OriginZone == "" && !g.VertexExists(p.Origin) {
+			return fmt.Errorf("trip %d: origin vertex %d does not exist", i, p.Origin)
+		}
+		if p.DestinationZone == "" && !g.VertexExists(p.Destination) {
+			return fmt.Errorf("trip %d: destination vertex %d does not exist", i, p.Destination)
+		}
+		if p.Profile != "" {
+			if _, err := ParseDepartureProfile(p.Profile); err != nil {
+				return fmt.Errorf("trip %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// AddVehiclesFromDemand creates the vehicle population described by an origin-destination matrix
+func (g *StreetGraph) AddVehiclesFromDemand(d Demand, minSpeed, maxSpeed float64) ([]*Vehicle, error) {
+	if err := d.validate(g); err != nil {
+		return nil, err
+	}
+
+	r := g.random()
+
+	type pathKey struct {
+		src, dest int
+		class     string
+	}
+	paths := make(map[pathKey][]int)
+	shortestPath := func(src, dest int, class *VehicleClass) ([]int, error) {
+		key := pathKey{src: src, dest: dest}
+		if class != nil {
+			key.class = class.Name
+		}
+		if path, ok := paths[key]; ok {
+			return path, nil
+		}
+		path, err := g.ShortestPathFor(src, dest, class)
+		if err != nil {
+			return nil, err
+		}
+		paths[key] = path
+		return path, nil
+	}
+
+	vehicles := make([]*Vehicle, 0, d.TotalTrips())
+	for i, p := range d.Trips {
+		origins, err := d.candidates(p.OriginZone, p.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("trip %d: %w", i, err)
+		}
+		destinations, err := d.candidates(p.DestinationZone, p.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("trip %d: %w", i, err)
+		}
+
+		var profile DepartureProfile = UniformProfile{From: p.DepartFrom, To: p.DepartTo}
+		if p.Profile != "" {
+			profile, _ = ParseDepartureProfile(p.Profile)
+		}
+
+		for n := 0; n < p.Count; n++ {
+			class := g.pickClass()
+			var path []int
+			for s := 0; s < maxZoneSamples && len(path) < 2; s++ {
+				src := origins[r.OD.Intn(len(origins))]
+				dest := destinations[r.OD.Intn(len(destinations))]
+				if src == dest {
+					continue
+				}
+				path, _ = shortestPath(src, dest, class)
+			}
+			if len(path) < 2 {
+				return nil, fmt.Errorf("trip %d: no route between origin and destination", i)
+			}
+
+			speed := utils.RandomFloat64(r.Speed, minSpeed, maxSpeed)
+			departure := profile.Sample(r.Departure)
+
+			v, err := g.newVehicleOnPath(path, speed, departure, class)
+			if err != nil {
+				return nil, err
+			}
+			vehicles = append(vehicles, v)
+		}
+	}
+
+	if len(vehicles) == 0 {
+		return nil, errors.New("demand does not contain any trips")
+	}
+
+	log.Info().Msgf("Generated %d vehicles from %d OD pairs", len(vehicles), len(d.Trips))
+	return vehicles, nil
+}
@@ -0,0 +1,66 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lineGraph(t *testing.T) *StreetGraph {
+	vertices := []JVertex{
+		{ID: 1, X: 1, Y: 1},
+		{ID: 2, X: 2, Y: 1},
+		{ID: 3, X: 3, Y: 1},
+		{ID: 4, X: 4, Y: 1},
+	}
+	edges := []JEdge{
+		{From: 1, To: 2, Length: 10, MaxSpeed: "50"},
+		{From: 2, To: 3, Length: 10, MaxSpeed: "50"},
+		{From: 3, To: 4, Length: 10, MaxSpeed: "50"},
+	}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestStreetGraph_AddVehiclesFromDemand(t *testing.T) {
+	g := lineGraph(t)
+	d := Demand{
+		Zones: map[string][]int{"west": {1, 2}, "east": {4}},
+		Trips: []ODPair{
+			{OriginZone: "west", DestinationZone: "east", Count: 3, DepartFrom: 10, DepartTo: 20},
+			{Origin: 1, Destination: 3, Count: 2},
+		},
+	}
+
+	vehicles, err := g.AddVehiclesFromDemand(d, 5, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(vehicles))
+
+	for _, v := range vehicles[:3] {
+		assert.Contains(t, []int{1, 2}, v.PathIDs[0])
+		assert.Equal(t, 4, v.PathIDs[len(v.PathIDs)-1])
+		assert.GreaterOrEqual(t, v.Departure, 10.)
+		assert.LessOrEqual(t, v.Departure, 20.)
+	}
+	for _, v := range vehicles[3:] {
+		assert.Equal(t, []int{1, 2, 3}, v.PathIDs)
+	}
+}
+
+func TestStreetGraph_AddVehiclesFromDemandInvalid(t *testing.T) {
+	g := lineGraph(t)
+
+	_, err := g.AddVehiclesFromDemand(Demand{Trips: []ODPair{{Origin: 1, Destination: 99, Count: 1}}}, 5, 5)
+	assert.Error(t, err)
+
+	_, err = g.AddVehiclesFromDemand(Demand{Trips: []ODPair{{OriginZone: "nowhere", Destination: 4, Count: 1}}}, 5, 5)
+	assert.Error(t, err)
+
+	// the line graph is directed, there is no way back
+	_, err = g.AddVehiclesFromDemand(Demand{Trips: []ODPair{{Origin: 4, Destination: 1, Count: 1}}}, 5, 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package streets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"pchpc_next/utils"
+)
+
+// DepartureProfile draws departure times in simulated seconds
+type DepartureProfile interface {
+	Sample(r *rand.Rand) float64
+}
+
+// UniformProfile departs vehicles uniformly between From and To
+type UniformProfile struct {
+	From, To float64
+}
+
+func (p UniformProfile) Sample(r *rand.Rand) float64 {
+	return utils.RandomFloat64(r, p.From, p.To)
+}
+
+// PeakProfile departs vehicles normally distributed around Peak with a standard deviation of Width,
+// truncated to the window between From and To, e.g. a morning peak
+type PeakProfile struct {
+	From, To    float64
+	Peak, Width float64
+}
+
+func (p PeakProfile) Sample(r *rand.Rand) float64 {
+	for i := 0; i < 100; i++ {
+		t := p.Peak + r.NormFloat64()*p.Width
+		if t >= p.From && t <= p.To {
+			return t
+		}
+	}
+	// the window hardly overlaps the peak, fall back to the window
+	return UniformProfile{From: p.From, To: p.To}.Sample(r)
+}
+
+// CurveProfile splits the window between From and To into len(Weights) equal bins and
+// departs vehicles proportionally to the weight of each bin. It is not changed by sampling,
+// so one profile can be shared between goroutines.
+type CurveProfile struct {
+	From, To float64
+	Weights  []float64
+
+	cumulative []float64
+}
+
+// NewCurveProfile returns a curve profile over a copy of weights
+func NewCurveProfile(from, to float64, weights []float64) *CurveProfile {
+	p := &CurveProfile{From: from, To: to, Weights: append([]float64(nil), weights...)}
+	p.cumulative = make([]float64, len(p.Weights))
+	sum := 0.
+	for i, w := range p.Weights {
+		sum += w
+		p.cumulative[i] = sum
+	}
+	return p
+}
+
+func (p *CurveProfile) Sample(r *rand.Rand) float64 {
+	if p.cumulative == nil {
+		// a profile that was not built by NewCurveProfile
+		p = NewCurveProfile(p.From, p.To, p.Weights)
+	}
+
+	total := p.cumulative[len(p.cumulative)-1]
+	bin := sort.SearchFloat64s(p.cumulative, r.Float64()*total)
+	if bin >= len(p.Weights) {
+		bin = len(p.Weights) - 1
+	}
+
+	binWidth := (p.To - p.From) / float64(len(p.Weights))
+	from := p.From + binWidth*float64(bin)
+	return utils.RandomFloat64(r, from, from+binWidth)
+}
+
+// ParseDepartureProfile parses a profile of the form
+//
+//	uniform:from,to
+//	peak:from,to,peak,width
+//	curve:from,to,w1,w2,...
+func ParseDepartureProfile(s string) (DepartureProfile, error) {
+	name, args, _ := strings.Cut(s, ":")
+
+	values := make([]float64, 0)
+	if args != "" {
+		for _, a := range strings.Split(args, ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+			if err != nil {
+				return nil, fmt.Errorf("departure profile %q: %w", s, err)
+			}
+			values = append(values, f)
+		}
+	}
+
+	if len(values) < 2 || values[1] < values[0] {
+		return nil, fmt.Errorf("departure profile %q: needs a window from,to", s)
+	}
+	from, to := values[0], values[1]
+
+	switch name {
+	case "uniform":
+		return UniformProfile{From: from, To: to}, nil
+	case "peak":
+		if len(values) != 4 || values[3] <= 0 {
+			return nil, fmt.Errorf("departure profile %q: needs from,to,peak,width", s)
+		}
+		return PeakProfile{From: from, To: to, Peak: values[2], Width: values[3]}, nil
+	case "curve":
+		weights := values[2:]
+		if len(weights) == 0 {
+			return nil, fmt.Errorf("departure profile %q: needs at least one weight", s)
+		}
+		sum := 0.
+		for _, w := range weights {
+			if w < 0 {
+				return nil, fmt.Errorf("departure profile %q: negative weight", s)
+			}
+			sum += w
+		}
+		if sum == 0 {
+			return nil, fmt.Errorf("departure profile %q: all weights are zero", s)
+		}
+		return NewCurveProfile(from, to, weights), nil
+	}
+
+	return nil, fmt.Errorf("unknown departure profile %q", name)
+}
+
+// SortByDeparture orders vehicles by their departure time, keeping the order of equal departures
+func SortByDeparture(vehicles []*Vehicle) {
+	sort.SliceStable(vehicles, func(i, j int) bool {
+		return vehicles[i].Departure < vehicles[j].Departure
+	})
+}
+
+// ReleaseVehicles hands every vehicle to emit once the clock reaches its departure time. A virtual clock
+// is not advanced, the vehicles are emitted at once in departure order and start at their departure
+// time wherever they are stepped. Once ctx is done the remaining vehicles are emitted without waiting.
+func ReleaseVehicles(ctx context.Context, clock *Clock, vehicles []*Vehicle, emit func(*Vehicle) error) error {
+	if clock == nil {
+		return errors.New("no clock set")
+	}
+
+	queue := make([]*Vehicle, len(vehicles))
+	copy(queue, vehicles)
+	SortByDeparture(queue)
+
+	for _, vehicle := range queue {
+		if clock.IsRealtime() {
+			clock.WaitUntilDone(ctx, vehicle.Departure)
+		}
+		log.Debug().Msgf("Releasing vehicle %s at %f", vehicle.ID, math.Max(clock.Now(), vehicle.Departure))
+		if err := emit(vehicle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
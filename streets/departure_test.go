@@ -0,0 +1,82 @@
+package streets
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDepartureProfile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, s := range []string{"uniform:0,100", "peak:0,100,50,10", "curve:0,100,1,4,1"} {
+		p, err := ParseDepartureProfile(s)
+		assert.NoError(t, err, s)
+
+		for i := 0; i < 1000; i++ {
+			d := p.Sample(r)
+			assert.GreaterOrEqual(t, d, 0., s)
+			assert.LessOrEqual(t, d, 100., s)
+		}
+	}
+
+	for _, s := range []string{"", "uniform", "uniform:100,0", "peak:0,100", "curve:0,100,0,0", "rush:0,1"} {
+		_, err := ParseDepartureProfile(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestReleaseVehicles(t *testing.T) {
+	vehicles := []*Vehicle{{ID: "c", Departure: 30}, {ID: "a", Departure: 10}, {ID: "b", Departure: 20}}
+	clock := NewClock(0)
+
+	released := make([]string, 0)
+	err := ReleaseVehicles(context.Background(), clock, vehicles, func(v *Vehicle) error {
+		// the virtual clock is left to whoever steps the vehicles
+		assert.Equal(t, 0., clock.Now())
+		released = append(released, v.ID)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, released)
+	assert.Equal(t, "c", vehicles[0].ID, "input order must not change")
+}
+
+func TestReleaseVehicles_Done(t *testing.T) {
+	vehicles := []*Vehicle{{ID: "a", Departure: 0}, {ID: "b", Departure: 3600}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the second vehicle would depart in an hour of wall time, it is emitted once ctx is done
+	released := make([]string, 0)
+	err := ReleaseVehicles(ctx, NewClock(1), vehicles, func(v *Vehicle) error {
+		released = append(released, v.ID)
+		cancel()
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, released)
+}
+
+func TestCurveProfile_Shared(t *testing.T) {
+	p := NewCurveProfile(0, 100, []float64{0, 1})
+	// sampling from several goroutines must not change the profile, the race detector would report it
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func(seed int64) {
+			defer func() { done <- struct{}{} }()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 100; i++ {
+				d := p.Sample(r)
+				if d < 50 || d > 100 {
+					t.Errorf("departure %f is outside the only weighted bin", d)
+				}
+			}
+		}(int64(g))
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+}
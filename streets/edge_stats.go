@@ -0,0 +1,294 @@
+package streets
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// EdgeStats accumulates traffic measures of one edge per interval of simulated time
+type EdgeStats struct {
+	mu       sync.Mutex
+	interval float64
+
+	entries   []int
+	exits     []int
+	occupancy []float64 // vehicle seconds spent on the edge
+	distance  []float64 // vehicle meters driven on the edge
+	covered   []float64 // vehicle length times seconds spent on the edge
+}
+
+func newEdgeStats() *EdgeStats {
+	return &EdgeStats{}
+}
+
+// SetInterval enables recording with intervals of the given length in simulated seconds
+func (s *EdgeStats) SetInterval(interval float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+}
+
+// grow makes sure interval i can be written
+func (s *EdgeStats) grow(i int) {
+	for len(s.entries) <= i {
+		s.entries = append(s.entries, 0)
+		s.exits = append(s.exits, 0)
+		s.occupancy = append(s.occupancy, 0)
+		s.distance = append(s.distance, 0)
+		s.covered = append(s.covered, 0)
+	}
+}
+
+// Record adds a vehicle of vehicleLength that entered the edge at enter and left it at exit after driving
+// length. Occupancy and distance are split over the intervals assuming a constant speed.
+func (s *EdgeStats) Record(enter, exit, length, vehicleLength float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.interval <= 0 || enter < 0 || exit < enter {
+		return
+	}
+
+	first := int(enter / s.interval)
+	last := int(exit / s.interval)
+	s.grow(last)
+
+	s.entries[first]++
+	s.exits[last]++
+
+	duration := exit - enter
+	if duration == 0 {
+		s.distance[first] += length
+		return
+	}
+
+	for i := first; i <= last; i++ {
+		from := math.Max(enter, float64(i)*s.interval)
+		to := math.Min(exit, float64(i+1)*s.interval)
+		if to <= from {
+			continue
+		}
+		s.occupancy[i] += to - from
+		s.distance[i] += length * (to - from) / duration
+		s.covered[i] += vehicleLength * (to - from)
+	}
+}
+
+// RecordTraversal records a vehicle of vehicleLength on the edge from->to, for edges not driven by Vehicle.Step
+func (g *StreetGraph) RecordTraversal(from, to int, enter, exit, vehicleLength float64) error {
+	edge, err := g.Graph.Edge(from, to)
+	if err != nil {
+		return err
+	}
+	data, ok := edge.Properties.Data.(Data)
+	if !ok {
+		return errors.New("edge data is not of type Data")
+	}
+	if data.Stats != nil {
+		data.Stats.Record(enter, exit, data.Length, vehicleLength)
+	}
+	return nil
+}
+
+// EdgeInterval holds the accumulated measures of one edge in one interval
+type EdgeInterval struct {
+	EdgeID    string
+	From, To  int
+	Length    float64
+	Interval  int
+	Entries   int
+	Exits     int
+	Occupancy float64
+	Distance  float64
+	Covered   float64
+}
+
+// Flow returns the exits per hour
+func (r *EdgeInterval) Flow(interval float64) float64 {
+	return float64(r.Exits) / interval * 3600
+}
+
+// Density returns the mean number of vehicles per kilometer
+func (r *EdgeInterval) Density(interval float64) float64 {
+	if r.Length <= 0 {
+		return 0
+	}
+	return r.Occupancy / interval / (r.Length / 1000)
+}
+
+// SpaceOccupancy returns the mean share of the edge length covered by vehicles
+func (r *EdgeInterval) SpaceOccupancy(interval float64) float64 {
+	if r.Length <= 0 {
+		return 0
+	}
+	return r.Covered / interval / r.Length
+}
+
+// MeanSpeed returns the space mean speed in meters per second, false if no vehicle spent time on the edge
+func (r *EdgeInterval) MeanSpeed() (float64, bool) {
+	if r.Occupancy <= 0 {
+		return 0, false
+	}
+	return r.Distance / r.Occupancy, true
+}
+
+// EnableEdgeStats starts recording traffic measures on every edge of the graph
+func (g *StreetGraph) EnableEdgeStats(interval float64) error {
+	edges, err := g.Graph.Edges()
+	if err != nil {
+		return err
+	}
+
+	for _, edge := range edges {
+		data, ok := edge.Properties.Data.(Data)
+		if !ok {
+			return errors.New("edge data is not of type Data")
+		}
+		if data.Stats != nil {
+			data.Stats.SetInterval(interval)
+		}
+	}
+	return nil
+}
+
+// EdgeStatsTable returns the recorded intervals of every edge that saw traffic
+func (g *StreetGraph) EdgeStatsTable() ([]EdgeInterval, error) {
+	edges, err := g.Graph.Edges()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]EdgeInterval, 0)
+	for _, edge := range edges {
+		data, ok := edge.Properties.Data.(Data)
+		if !ok {
+			return nil, errors.New("edge data is not of type Data")
+		}
+		if data.Stats == nil {
+			continue
+		}
+
+		data.Stats.mu.Lock()
+		for i := range data.Stats.entries {
+			rows = append(rows, EdgeInterval{
+				EdgeID:    data.ID,
+				From:      edge.Source,
+				To:        edge.Target,
+				Length:    data.Length,
+				Interval:  i,
+				Entries:   data.Stats.entries[i],
+				Exits:     data.Stats.exits[i],
+				Occupancy: data.Stats.occupancy[i],
+				Distance:  data.Stats.distance[i],
+				Covered:   data.Stats.covered[i],
+			})
+		}
+		data.Stats.mu.Unlock()
+	}
+
+	sortEdgeIntervals(rows)
+	return rows, nil
+}
+
+func sortEdgeIntervals(rows []EdgeInterval) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].From != rows[j].From {
+			return rows[i].From < rows[j].From
+		}
+		if rows[i].To != rows[j].To {
+			return rows[i].To < rows[j].To
+		}
+		return rows[i].Interval < rows[j].Interval
+	})
+}
+
+// MergeEdgeIntervals sums the tables of several graphs, e.g. of all leaves
+func MergeEdgeIntervals(tables ...[]EdgeInterval) []EdgeInterval {
+	type key struct{ from, to, interval int }
+	merged := make(map[key]*EdgeInterval)
+
+	for _, table := range tables {
+		for _, row := range table {
+			k := key{row.From, row.To, row.Interval}
+			m, ok := merged[k]
+			if !ok {
+				r := row
+				merged[k] = &r
+				continue
+			}
+			m.Entries += row.Entries
+			m.Exits += row.Exits
+			m.Occupancy += row.Occupancy
+			m.Distance += row.Distance
+			m.Covered += row.Covered
+		}
+	}
+
+	rows := make([]EdgeInterval, 0, len(merged))
+	for _, row := range merged {
+		rows = append(rows, *row)
+	}
+	sortEdgeIntervals(rows)
+	return rows
+}
+
+func MarshalEdgeIntervals(rows []EdgeInterval) ([]byte, error) {
+	return encodeEdgeIntervals(rows), nil
+}
+
+func UnmarshalEdgeIntervals(data []byte) ([]EdgeInterval, error) {
+	return decodeEdgeIntervals(data)
+}
+
+// WriteEdgeStatsFile writes the edge x interval table as CSV with flow in vehicles per hour,
+// density in vehicles per kilometer, the share of the edge covered by vehicles and mean speed in meters per second
+func WriteEdgeStatsFile(path string, rows []EdgeInterval, interval float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	w := csv.NewWriter(bw)
+	_ = w.Write([]string{"edge_id", "from", "to", "interval", "start", "entries", "exits", "flow", "density", "space_occupancy", "mean_speed"})
+
+	ff := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for _, r := range rows {
+		speed := ""
+		if s, ok := r.MeanSpeed(); ok {
+			speed = ff(s)
+		}
+		_ = w.Write([]string{
+			r.EdgeID,
+			strconv.Itoa(r.From),
+			strconv.Itoa(r.To),
+			strconv.Itoa(r.Interval),
+			ff(float64(r.Interval) * interval),
+			strconv.Itoa(r.Entries),
+			strconv.Itoa(r.Exits),
+			ff(r.Flow(interval)),
+			ff(r.Density(interval)),
+			ff(r.SpaceOccupancy(interval)),
+			speed,
+		})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,59 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEdgeStats_Record(t *testing.T) {
+	s := newEdgeStats()
+
+	// disabled until an interval is set
+	s.Record(0, 10, 100, 4.5)
+	assert.Equal(t, 0, len(s.entries))
+
+	s.SetInterval(10)
+	s.Record(5, 15, 100, 4.5)
+
+	assert.Equal(t, []int{1, 0}, s.entries)
+	assert.Equal(t, []int{0, 1}, s.exits)
+	assert.Equal(t, []float64{5, 5}, s.occupancy)
+	assert.Equal(t, []float64{50, 50}, s.distance)
+	assert.Equal(t, []float64{22.5, 22.5}, s.covered)
+}
+
+func TestStreetGraph_EdgeStatsTable(t *testing.T) {
+	g := lineGraph(t)
+	assert.NoError(t, g.EnableEdgeStats(60))
+
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+	v.Drive()
+
+	rows, err := g.EdgeStatsTable()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(rows))
+	for _, r := range rows {
+		assert.Equal(t, 1, r.Entries)
+		assert.Equal(t, 1, r.Exits)
+		assert.Equal(t, 10., r.Distance)
+		speed, ok := r.MeanSpeed()
+		assert.True(t, ok)
+		assert.Equal(t, 3.3333333333333335, speed)
+		// a vehicle without a class counts with the length of a car
+		assert.InDelta(t, DefaultVehicleLength*3/60/10, r.SpaceOccupancy(60), 1e-12)
+	}
+
+	merged := MergeEdgeIntervals(rows, rows[:1])
+	assert.Equal(t, 3, len(merged))
+	assert.Equal(t, 2, merged[0].Entries)
+	assert.Equal(t, 60., merged[0].Flow(120))
+
+	b, err := MarshalEdgeIntervals(rows)
+	assert.NoError(t, err)
+	u, err := UnmarshalEdgeIntervals(b)
+	assert.NoError(t, err)
+	assert.Equal(t, rows, u)
+}
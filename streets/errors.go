@@ -0,0 +1,17 @@
+package streets
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dominikbraun/graph"
+)
+
+var (
+	// ErrMissingEdge is returned when an edge a vehicle drives on is not in its graph
+	ErrMissingEdge = errors.New("edge is not in the graph")
+	// ErrUnreachable is returned when there is no path to a destination, it also matches graph.ErrTargetNotReachable
+	ErrUnreachable = fmt.Errorf("destination is unreachable: %w", graph.ErrTargetNotReachable)
+	// ErrMalformedInput is returned for graph, vehicle and message input that cannot be decoded
+	ErrMalformedInput = errors.New("malformed input")
+)
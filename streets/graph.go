@@ -1,10 +1,10 @@
 package streets
 
 import (
+	"context"
 	"github.com/dominikbraun/graph"
-	"golang.org/x/exp/slices"
-	"math/rand"
 	"pchpc_next/utils"
+	"sort"
 )
 
 // StreetGraph is a graph of streets with vertices of type int and edges of type JVertex
@@ -18,8 +18,29 @@ type StreetGraph struct {
 	// graph is the graph
 	Graph graph.Graph[int, JVertex]
 
+	// Bounds is the rectangle of the graph, the whole map for the root graph
+	Bounds Bounds
+
+	// Random holds the random streams used to generate vehicles
+	Random *RandomSource
+
+	// Classes is the mix new vehicles draw their class from, nil creates vehicles without a class
+	Classes *ClassMix
+
+	// Parking holds the parking facilities, nil parks vehicles wherever their path ends
+	Parking *ParkingRegistry
+
+	// Until is the simulated time vehicles are stopped at, 0 lets them drive to their destination
+	Until float64
+
+	// Halt stops every vehicle where it is once it is done, e.g. when the run exceeds its wall limit, nil never halts
+	Halt context.Context
+
 	// vertex IDs
 	vertexIDs []int
+
+	// sorted successors of every vertex
+	adjacency map[int][]int
 }
 
 // VertexExists checks if a vertex exists in a graph
@@ -40,18 +61,18 @@ func (g *StreetGraph) GetVertices() ([]int, error) {
 		return nil, err
 	}
 
+	seen := make(map[int]bool)
 	vertices := make([]int, 0)
 	for _, edge := range edges {
-		dstID := edge.Target
-		srcID := edge.Source
-
-		if !slices.Contains(vertices, dstID) {
-			vertices = append(vertices, dstID)
-		}
-		if !slices.Contains(vertices, srcID) {
-			vertices = append(vertices, dstID, srcID)
+		for _, id := range []int{edge.Source, edge.Target} {
+			if !seen[id] {
+				seen[id] = true
+				vertices = append(vertices, id)
+			}
 		}
 	}
+	// edges come in map order, sort to be reproducible
+	sort.Ints(vertices)
 
 	g.vertexIDs = vertices
 	return g.vertexIDs, nil
@@ -64,27 +85,38 @@ func (g *StreetGraph) AddVehicle(minSpeed, maxSpeed float64) (*Vehicle, error) {
 		return nil, err
 	}
 
+	r := g.random()
+	class := g.pickClass()
+
 	// Calculate the path
 	var path []int
 	for len(path) < 2 {
-		srcIdx := rand.Intn(len(vertices))
+		srcIdx := r.OD.Intn(len(vertices))
 		src := vertices[srcIdx]
-		destIdx := rand.Intn(len(vertices))
+		destIdx := r.OD.Intn(len(vertices))
 		dest := vertices[destIdx]
 		if src == dest {
 			continue
 		}
-		path, err = graph.ShortestPath(g.Graph, src, dest)
+		path, err = g.ShortestPathFor(src, dest, class)
 		if err == nil {
 			break
 		}
 	}
 
-	speed := utils.RandomFloat64(minSpeed, maxSpeed)
+	speed := utils.RandomFloat64(r.Speed, minSpeed, maxSpeed)
 
-	// Create the vehicle
+	return g.newVehicleOnPath(path, speed, 0.0, class)
+}
+
+// newVehicleOnPath creates a vehicle of the class that drives along path, departing at the given simulated time
+func (g *StreetGraph) newVehicleOnPath(path []int, speed, departure float64, class *VehicleClass) (*Vehicle, error) {
 	vb := NewVehicleBuilder().WithGraph(g).WithPathIDs(path).WithDelta(0.0).WithIsParked(false)
-	vb = vb.WithSpeed(speed).WithLastID(path[0]).WithNextID(path[1])
+	if class != nil {
+		vb = vb.WithClass(*class)
+	}
+	vb = vb.WithSpeed(speed).WithLastID(path[0]).WithNextID(path[1]).WithDeparture(departure)
+	vb = vb.WithID(utils.RandomID(g.random().ID, vehicleIDAlphabet, vehicleIDLength))
 
 	v, err := vb.Build()
 	if err != nil {
@@ -97,12 +129,7 @@ func (g *StreetGraph) AddVehicle(minSpeed, maxSpeed float64) (*Vehicle, error) {
 // AddVehicleFromJson adds a vehicle to a graph from jsonBytes
 func (g *StreetGraph) AddVehicleFromJson(jsonBytes []byte) (*Vehicle, error) {
 	// Create the vehicle
-	vb, err := NewVehicleBuilder().FromJsonBytes(jsonBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	vb = vb.WithGraph(g)
+	vb := NewVehicleBuilder().FromJsonBytes(jsonBytes).WithGraph(g)
 	v, err := vb.Build()
 
 	if err != nil {
@@ -112,6 +139,15 @@ func (g *StreetGraph) AddVehicleFromJson(jsonBytes []byte) (*Vehicle, error) {
 	return &v, nil
 }
 
+// vertex returns a vertex of the graph, vertices of other leaves are looked up in the root graph
+func (g *StreetGraph) vertex(id int) (JVertex, error) {
+	v, err := g.Graph.Vertex(id)
+	if err != nil && g.RootGraph != nil {
+		return g.RootGraph.Graph.Vertex(id)
+	}
+	return v, err
+}
+
 func (g *StreetGraph) GetRectFromVertexID(vertexID int, leafs []*StreetGraph) (int, error) {
 	for _, leaf := range leafs {
 		if leaf.VertexExists(vertexID) {
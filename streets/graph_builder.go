@@ -2,6 +2,7 @@ package streets
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"pchpc_next/utils"
 	"strconv"
@@ -23,6 +24,19 @@ type rect struct {
 	Vertices []JVertex
 }
 
+// Bounds is the rectangle a graph was picked from
+type Bounds struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+// bounds returns the rectangle as Bounds
+func (r *rect) bounds() Bounds {
+	return Bounds{MinX: r.BotLeft.X, MinY: r.BotLeft.Y, MaxX: r.TopRight.X, MaxY: r.TopRight.Y}
+}
+
 // inRect checks if a vertex is in a rectangle
 func (r *rect) inRect(v JVertex) bool {
 	for _, vertex := range r.Vertices {
@@ -44,6 +58,8 @@ type GraphBuilder struct {
 	pickedRect           rect
 	id                   int
 	root                 *StreetGraph
+	// err is the first error of the builder chain, it is returned by Build
+	err error
 }
 
 // -- GraphBuilder --
@@ -80,10 +96,13 @@ func (gb *GraphBuilder) WithEdges(edges []JEdge) *GraphBuilder {
 
 			// Add the Data struct to the edge
 			e.Data.Map = &hMap
+			e.Data.Stats = newEdgeStats()
 			e.Data.MaxSpeed = msf
 			e.Data.Length = e.Length
 			e.Data.ID = e.ID
 			e.Data.Name = e.Name
+			e.Data.Geometry = e.Geometry
+			e.Data.Highway = e.Highway
 		}
 		nEdges = append(nEdges, e)
 	}
@@ -98,28 +117,36 @@ func (gb *GraphBuilder) WithRectangleParts(n int) *GraphBuilder {
 	return gb
 }
 
-// FromJsonBytes unmarshals the graph JSON bytes into a graph
+// FromJsonBytes unmarshals the graph JSON bytes into a graph, Build returns ErrMalformedInput if they cannot be decoded
 func (gb *GraphBuilder) FromJsonBytes(jBytes []byte) *GraphBuilder {
 	jGraph, err := UnmarshalGraphJSON(jBytes)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to unmarshal graph JSON.")
-		panic(err)
+		return gb.fail(fmt.Errorf("%w: %w", ErrMalformedInput, err))
 	}
 
 	return gb.WithVertices(jGraph.Graph.Vertices).WithEdges(jGraph.Graph.Edges)
 }
 
-// FromJsonFile reads the graph JSON file and unmarshals it into a graph
+// FromJsonFile reads the graph JSON file and unmarshals it into a graph, Build returns the error if it cannot be read
 func (gb *GraphBuilder) FromJsonFile(jFile string) *GraphBuilder {
 	jBytes, err := os.ReadFile(jFile)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to read graph JSON file.")
-		panic(err)
+		return gb.fail(err)
 	}
 
 	return gb.FromJsonBytes(jBytes)
 }
 
+// fail records the first error of the builder chain
+func (gb *GraphBuilder) fail(err error) *GraphBuilder {
+	if gb.err == nil {
+		gb.err = err
+	}
+	return gb
+}
+
 // SetTopRightBottomLeftVertices returns the top right and bottom left vertices of the graph
 func (gb *GraphBuilder) SetTopRightBottomLeftVertices() *GraphBuilder {
 	if len(gb.vertices) == 0 {
@@ -230,9 +257,9 @@ func (gb *GraphBuilder) PickRect(i int) *GraphBuilder {
 
 	n := len(gb.rects)
 
-	if i >= n {
+	if i < 0 || i >= n {
 		log.Error().Msgf("Rectangle index out of bounds. Max index: %d", n-1)
-		return gb
+		return gb.fail(fmt.Errorf("rectangle index %d out of bounds, max index %d", i, n-1))
 	}
 
 	gb.pickedRect = gb.rects[i]
@@ -296,6 +323,10 @@ func (gb *GraphBuilder) IsLeaf(root *StreetGraph, taskID int) *GraphBuilder {
 }
 
 func (gb *GraphBuilder) check() error {
+	if gb.err != nil {
+		return gb.err
+	}
+
 	// Verify that the graph can be built
 	if gb.vertices == nil {
 		log.Error().Msg("No vertices set in graph. Use WithVertices() to set vertices.")
@@ -361,6 +392,7 @@ func (gb *GraphBuilder) Build() (*StreetGraph, error) {
 		ID:        gb.id,
 		RootGraph: gb.root,
 		Graph:     g,
+		Bounds:    gb.pickedRect.bounds(),
 	}
 
 	return &gb.graph, nil
@@ -280,3 +280,21 @@ func TestGraphBuilder_Build(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, g)
 }
+
+func TestGraphBuilder_BuildErrors(t *testing.T) {
+	_, err := NewGraphBuilder().FromJsonBytes([]byte("{")).SetTopRightBottomLeftVertices().
+		NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	assert.ErrorIs(t, err, ErrMalformedInput)
+
+	_, err = NewGraphBuilder().FromJsonFile("../assets/missing.json").SetTopRightBottomLeftVertices().
+		NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the first error of the chain is returned
+	_, err = NewGraphBuilder().FromJsonFile("../assets/missing.json").FromJsonBytes([]byte("{")).Build()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = NewGraphBuilder().FromJsonFile("../assets/out.json").SetTopRightBottomLeftVertices().
+		NumberOfRects(2).DivideGraphsIntoRects().PickRect(2).IsRoot().Build()
+	assert.Error(t, err)
+}
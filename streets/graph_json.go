@@ -2,6 +2,7 @@ package streets
 
 import (
 	"encoding/json"
+	"os"
 	"pchpc_next/utils"
 )
 
@@ -11,6 +12,15 @@ func UnmarshalGraphJSON(data []byte) (GraphJSON, error) {
 	return r, err
 }
 
+// LoadGraphFile reads a graph from a JSON file
+func LoadGraphFile(path string) (GraphJSON, error) {
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return GraphJSON{}, err
+	}
+	return UnmarshalGraphJSON(jBytes)
+}
+
 func (r *GraphJSON) Marshal() ([]byte, error) {
 	return json.Marshal(r)
 }
@@ -33,7 +43,11 @@ type JEdge struct {
 	MaxSpeed string  `json:"max_speed"`
 	Name     string  `json:"name"`
 	ID       string  `json:"osm_id"`
-	Data     Data
+	// Geometry is the optional polyline of the street as [x, y] points from the first to the last vertex
+	Geometry [][2]float64 `json:"geometry,omitempty"`
+	// Highway is the optional OSM highway type, e.g. residential, used to keep vehicle classes off roads
+	Highway string `json:"highway,omitempty"`
+	Data    Data
 }
 
 type JVertex struct {
@@ -47,5 +61,8 @@ type Data struct {
 	Name     string
 	MaxSpeed float64
 	Length   float64
+	Geometry [][2]float64
+	Highway  string
 	Map      *utils.HashMap[string, *Vehicle]
+	Stats    *EdgeStats
 }
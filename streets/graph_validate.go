@@ -0,0 +1,132 @@
+package streets
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dominikbraun/graph"
+)
+
+// GraphIssue is a problem found in a graph file. Errors make the graph unusable,
+// warnings point to data the simulation silently works around.
+type GraphIssue struct {
+	Error   bool
+	Message string
+}
+
+func (i GraphIssue) String() string {
+	if i.Error {
+		return "error: " + i.Message
+	}
+	return "warning: " + i.Message
+}
+
+// ValidateGraph checks the vertices and edges of a graph file
+func ValidateGraph(j GraphJSON) []GraphIssue {
+	issues := make([]GraphIssue, 0)
+	errorf := func(format string, args ...interface{}) {
+		issues = append(issues, GraphIssue{Error: true, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(format string, args ...interface{}) {
+		issues = append(issues, GraphIssue{Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(j.Graph.Vertices) == 0 {
+		errorf("graph has no vertices")
+	}
+	if len(j.Graph.Edges) == 0 {
+		errorf("graph has no edges")
+	}
+
+	// vertices are listed once per edge, so the same vertex appears several times
+	vertices := make(map[int]JVertex)
+	for _, v := range j.Graph.Vertices {
+		if v.ID <= 0 {
+			// 0 marks the end of a path and negative IDs a leaf switch
+			errorf("vertex %d: IDs must be positive", v.ID)
+			continue
+		}
+		if other, ok := vertices[v.ID]; ok && (other.X != v.X || other.Y != v.Y) {
+			errorf("vertex %d: listed at (%g, %g) and (%g, %g)", v.ID, other.X, other.Y, v.X, v.Y)
+			continue
+		}
+		vertices[v.ID] = v
+	}
+
+	type edgeKey struct{ from, to int }
+	edges := make(map[edgeKey]bool)
+	connected := make(map[int]bool)
+	for _, e := range j.Graph.Edges {
+		_, fromOk := vertices[e.From]
+		_, toOk := vertices[e.To]
+		if !fromOk {
+			errorf("edge %d->%d: vertex %d does not exist", e.From, e.To, e.From)
+		}
+		if !toOk {
+			errorf("edge %d->%d: vertex %d does not exist", e.From, e.To, e.To)
+		}
+		if !fromOk || !toOk {
+			continue
+		}
+		if e.Length <= 0 {
+			errorf("edge %d->%d: length %g is not positive", e.From, e.To, e.Length)
+		}
+		if e.From == e.To {
+			warnf("edge %d->%d: loops back to its vertex", e.From, e.To)
+		}
+		if edges[edgeKey{e.From, e.To}] {
+			warnf("edge %d->%d: listed twice, only the first one is used", e.From, e.To)
+		}
+		if _, err := strconv.Atoi(e.MaxSpeed); err != nil && e.MaxSpeed != "" {
+			warnf("edge %d->%d: max speed %q is not a number, 50 is used", e.From, e.To, e.MaxSpeed)
+		}
+		edges[edgeKey{e.From, e.To}] = true
+		connected[e.From] = true
+		connected[e.To] = true
+	}
+
+	isolated := 0
+	for id := range vertices {
+		if !connected[id] {
+			isolated++
+		}
+	}
+	if isolated > 0 {
+		warnf("%d vertices have no edges", isolated)
+	}
+
+	if len(edges) > 0 {
+		g := graph.New(graph.IntHash, graph.Directed())
+		for id := range connected {
+			_ = g.AddVertex(id)
+		}
+		for e := range edges {
+			_ = g.AddEdge(e.from, e.to)
+		}
+		components, err := graph.StronglyConnectedComponents(g)
+		if err != nil {
+			errorf("connectivity: %v", err)
+		} else if len(components) > 1 {
+			largest := 0
+			for _, c := range components {
+				if len(c) > largest {
+					largest = len(c)
+				}
+			}
+			warnf("%d of %d vertices are outside the largest strongly connected component, vehicles may not find a path from or to them",
+				len(connected)-largest, len(connected))
+		}
+	}
+
+	return issues
+}
+
+// HasErrors reports whether any issue is an error
+func HasErrors(issues []GraphIssue) bool {
+	for _, issue := range issues {
+		if issue.Error {
+			return true
+		}
+	}
+	return false
+}
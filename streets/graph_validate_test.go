@@ -0,0 +1,44 @@
+package streets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGraph(t *testing.T) {
+	valid := GraphJSON{Graph: JGraph{
+		Vertices: []JVertex{{ID: 1, X: 1, Y: 1}, {ID: 2, X: 2, Y: 1}, {ID: 1, X: 1, Y: 1}, {ID: 2, X: 2, Y: 1}},
+		Edges:    []JEdge{{From: 1, To: 2, Length: 10, MaxSpeed: "50"}, {From: 2, To: 1, Length: 10}},
+	}}
+	assert.Empty(t, ValidateGraph(valid))
+
+	invalid := GraphJSON{Graph: JGraph{
+		Vertices: []JVertex{{ID: 1, X: 1, Y: 1}, {ID: 1, X: 5, Y: 1}, {ID: 2, X: 2, Y: 1}, {ID: 0}},
+		Edges: []JEdge{
+			{From: 1, To: 2, Length: 0},
+			{From: 2, To: 3, Length: 10},
+			{From: 1, To: 2, Length: 10, MaxSpeed: "fast"},
+		},
+	}}
+	issues := ValidateGraph(invalid)
+	assert.True(t, HasErrors(issues))
+
+	messages := make([]string, len(issues))
+	for i, issue := range issues {
+		messages[i] = issue.String()
+	}
+	all := strings.Join(messages, "\n")
+	for _, expected := range []string{
+		"error: vertex 0: IDs must be positive",
+		"error: vertex 1: listed at",
+		"error: edge 1->2: length 0 is not positive",
+		"error: edge 2->3: vertex 3 does not exist",
+		"warning: edge 1->2: listed twice",
+		`warning: edge 1->2: max speed "fast" is not a number`,
+		"outside the largest strongly connected component",
+	} {
+		assert.Contains(t, all, expected)
+	}
+}
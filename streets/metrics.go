@@ -0,0 +1,244 @@
+package streets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// metricTags is the number of MPI tags counted by Metrics
+const metricTags = PHASES_TAG + 1
+
+// tagNames are the label values of the MPI tags
+var tagNames = map[int]string{
+	VEHICLE_OUT_TAG:      "vehicle_out",
+	VEHICLE_IN_ROOT_TAG:  "vehicle_in_root",
+	VEHICLE_IN_LEAF_TAG:  "vehicle_in_leaf",
+	REQUEST_EDGE:         "request_edge",
+	RECEIVE_EDGE:         "receive_edge",
+	VEHICLE_OUT_ROOT_TAG: "vehicle_out_root",
+	REQUEST_DONE_INC_TAG: "request_done_inc",
+	DONE_BCAST_TAG:       "done_bcast",
+	TRIP_RECORD_TAG:      "trip_record",
+	EDGE_STATS_TAG:       "edge_stats",
+	CHECKPOINT_TAG:       "checkpoint",
+	CHECKPOINT_REPLY_TAG: "checkpoint_reply",
+	POSITIONS_TAG:        "positions",
+	PARKING_TAG:          "parking",
+	PHASES_TAG:           "phases",
+}
+
+// Metrics are the live counters of a rank, served in the Prometheus text format.
+// All methods are safe for concurrent use and do nothing on a nil *Metrics.
+type Metrics struct {
+	rank int
+
+	sent     [metricTags]atomic.Int64
+	received [metricTags]atomic.Int64
+
+	edgeRequests atomic.Int64
+	edgeWaiting  atomic.Int64
+
+	released atomic.Int64
+	started  atomic.Int64
+	finished atomic.Int64
+	parked   atomic.Int64
+
+	mu     sync.Mutex
+	clock  *Clock
+	queues map[string]func() int
+}
+
+// NewMetrics returns the metrics of a rank
+func NewMetrics(rank int) *Metrics {
+	return &Metrics{rank: rank, queues: make(map[string]func() int)}
+}
+
+func (mt *Metrics) messageSent(tag int) {
+	if mt == nil || tag < 0 || tag >= metricTags {
+		return
+	}
+	mt.sent[tag].Add(1)
+}
+
+func (mt *Metrics) messageReceived(tag int) {
+	if mt == nil || tag < 0 || tag >= metricTags {
+		return
+	}
+	mt.received[tag].Add(1)
+}
+
+func (mt *Metrics) edgeRequestServed() {
+	if mt == nil {
+		return
+	}
+	mt.edgeRequests.Add(1)
+}
+
+// edgeRequestWaiting changes the number of edge length requests waiting for an earlier one by delta
+func (mt *Metrics) edgeRequestWaiting(delta int64) {
+	if mt == nil {
+		return
+	}
+	mt.edgeWaiting.Add(delta)
+}
+
+// VehicleReleased counts a vehicle that has been emitted at its departure
+func (mt *Metrics) VehicleReleased() {
+	if mt == nil {
+		return
+	}
+	mt.released.Add(1)
+}
+
+// Released returns the number of emitted vehicles
+func (mt *Metrics) Released() int64 {
+	if mt == nil {
+		return 0
+	}
+	return mt.released.Load()
+}
+
+// VehicleStarted counts a vehicle that is driven on this rank
+func (mt *Metrics) VehicleStarted() {
+	if mt == nil {
+		return
+	}
+	mt.started.Add(1)
+}
+
+// VehicleFinished counts a vehicle that is not driven on this rank anymore
+func (mt *Metrics) VehicleFinished() {
+	if mt == nil {
+		return
+	}
+	mt.finished.Add(1)
+}
+
+// VehicleParked counts a vehicle that has reached its destination
+func (mt *Metrics) VehicleParked() {
+	if mt == nil {
+		return
+	}
+	mt.parked.Add(1)
+}
+
+// SetClock reports the simulated time of the clock
+func (mt *Metrics) SetClock(clock *Clock) {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.clock = clock
+}
+
+// Queue reports the depth of a queue, e.g. the vehicles waiting for their departure
+func (mt *Metrics) Queue(name string, depth func() int) {
+	if mt == nil {
+		return
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.queues[name] = depth
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (mt *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	rank := fmt.Sprintf(`rank="%d"`, mt.rank)
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	value := func(name, labels string, v interface{}) {
+		fmt.Fprintf(cw, "%s{%s} %v\n", name, labels, v)
+	}
+
+	tags := make([]int, 0, len(tagNames))
+	for tag := range tagNames {
+		tags = append(tags, tag)
+	}
+	sort.Ints(tags)
+
+	metric("pchpc_messages_sent_total", "counter", "MPI messages sent per tag.")
+	for _, tag := range tags {
+		value("pchpc_messages_sent_total", fmt.Sprintf(`%s,tag="%s"`, rank, tagNames[tag]), mt.sent[tag].Load())
+	}
+	metric("pchpc_messages_received_total", "counter", "MPI messages received per tag.")
+	for _, tag := range tags {
+		value("pchpc_messages_received_total", fmt.Sprintf(`%s,tag="%s"`, rank, tagNames[tag]), mt.received[tag].Load())
+	}
+
+	metric("pchpc_edge_length_requests_total", "counter", "Edge length requests served by the root.")
+	value("pchpc_edge_length_requests_total", rank, mt.edgeRequests.Load())
+
+	started, finished := mt.started.Load(), mt.finished.Load()
+	released, parked := mt.released.Load(), mt.parked.Load()
+	metric("pchpc_vehicles_active", "gauge", "Vehicles driven on this rank.")
+	value("pchpc_vehicles_active", rank, started-finished)
+	metric("pchpc_vehicles_parked_total", "counter", "Vehicles that reached their destination.")
+	value("pchpc_vehicles_parked_total", rank, parked)
+	metric("pchpc_vehicles_released_total", "counter", "Vehicles emitted at their departure.")
+	value("pchpc_vehicles_released_total", rank, released)
+	if mt.rank == ROOT_ID {
+		metric("pchpc_vehicles_in_flight", "gauge", "Released vehicles that are not parked yet.")
+		value("pchpc_vehicles_in_flight", rank, released-parked)
+	}
+
+	mt.mu.Lock()
+	names := make([]string, 0, len(mt.queues))
+	for name := range mt.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metric("pchpc_queue_depth", "gauge", "Entries waiting in a queue.")
+	value("pchpc_queue_depth", rank+`,queue="edge_length"`, mt.edgeWaiting.Load())
+	for _, name := range names {
+		value("pchpc_queue_depth", fmt.Sprintf(`%s,queue="%s"`, rank, name), mt.queues[name]())
+	}
+	if mt.clock != nil {
+		metric("pchpc_clock_seconds", "gauge", "Simulated time.")
+		value("pchpc_clock_seconds", rank, mt.clock.Now())
+	}
+	mt.mu.Unlock()
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP serves the metrics on any path
+func (mt *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = mt.WriteTo(w)
+}
+
+// ServeMetrics serves the metrics at /metrics on addr until the server fails
+func ServeMetrics(addr string, mt *Metrics) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mt)
+	return http.ListenAndServe(addr, mux)
+}
+
+// countingWriter counts the written bytes and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
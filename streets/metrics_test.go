@@ -0,0 +1,41 @@
+package streets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_WriteTo(t *testing.T) {
+	mt := NewMetrics(0)
+	mt.SetClock(NewClockAt(0, 12))
+	mt.Queue("release", func() int { return 3 })
+	mt.messageSent(VEHICLE_IN_LEAF_TAG)
+	mt.messageReceived(TRIP_RECORD_TAG)
+	mt.edgeRequestServed()
+	mt.VehicleReleased()
+	mt.VehicleReleased()
+	mt.VehicleParked()
+
+	var buf bytes.Buffer
+	n, err := mt.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	out := buf.String()
+	assert.Contains(t, out, `pchpc_messages_sent_total{rank="0",tag="vehicle_in_leaf"} 1`)
+	assert.Contains(t, out, `pchpc_messages_received_total{rank="0",tag="trip_record"} 1`)
+	assert.Contains(t, out, `pchpc_edge_length_requests_total{rank="0"} 1`)
+	assert.Contains(t, out, `pchpc_vehicles_in_flight{rank="0"} 1`)
+	assert.Contains(t, out, `pchpc_queue_depth{rank="0",queue="release"} 3`)
+	assert.Contains(t, out, `pchpc_clock_seconds{rank="0"} 12`)
+	assert.Contains(t, out, "# TYPE pchpc_vehicles_active gauge")
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var mt *Metrics
+	mt.VehicleStarted()
+	mt.messageSent(VEHICLE_OUT_TAG)
+	assert.Equal(t, int64(0), mt.Released())
+}
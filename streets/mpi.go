@@ -2,8 +2,11 @@ package streets
 
 import (
 	"errors"
+	"fmt"
 	"github.com/rs/zerolog/log"
 	mpi "github.com/sbromberger/gompi"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,16 +19,126 @@ const (
 	VEHICLE_OUT_ROOT_TAG = 6
 	REQUEST_DONE_INC_TAG = 7
 	DONE_BCAST_TAG       = 8
+	TRIP_RECORD_TAG      = 9
+	EDGE_STATS_TAG       = 10
+	CHECKPOINT_TAG       = 11
+	CHECKPOINT_REPLY_TAG = 12
+	POSITIONS_TAG        = 13
+	PARKING_TAG          = 14
+	PHASES_TAG           = 15
 )
 
+// stopMarker is sent instead of a vehicle to tell a leaf that no more vehicles will arrive
+var stopMarker = seal(MsgStop, func(*encoder) {})
+
+// ErrStopped is returned by ReceiveVehicleOnLeaf once the root has stopped the leaf
+var ErrStopped = errors.New("leaf was stopped by root")
+
 type MPI struct {
 	taskID int
 	comm   mpi.Communicator
 	g      *StreetGraph
+
+	// lengthMu keeps concurrent edge length requests of a leaf from receiving each other's answers
+	lengthMu sync.Mutex
+	metrics  *Metrics
+	phases   *PhaseTimer
+
+	// outbox batches the vehicles this process sends, the root sends to the leaves and the leaves to the root
+	outbox *VehicleBatcher
+	// engine owns the communicator of the rank while it simulates, nil if the calls are made directly
+	engine *CommEngine
 }
 
 func NewMPI(taskID int, communicator mpi.Communicator, graph *StreetGraph) *MPI {
-	return &MPI{taskID: taskID, comm: communicator, g: graph}
+	m := &MPI{taskID: taskID, comm: communicator, g: graph}
+	// every vehicle is sent on its own until SetBatching is called
+	m.outbox, _ = NewVehicleBatcher(BatchOptions{MaxVehicles: 1}, m.sendVehicleBatch)
+	return m
+}
+
+// SetBatching buffers outgoing vehicles per destination with the thresholds of opts
+func (m *MPI) SetBatching(opts BatchOptions) error {
+	outbox, err := NewVehicleBatcher(opts, m.sendVehicleBatch)
+	if err != nil {
+		return err
+	}
+	m.outbox.Close()
+	m.outbox = outbox
+	return nil
+}
+
+// FlushVehicles sends the buffered vehicles of every destination
+func (m *MPI) FlushVehicles() {
+	m.outbox.Flush()
+}
+
+// CloseBatching sends the buffered vehicles and stops their periodic flush
+func (m *MPI) CloseBatching() {
+	m.outbox.Close()
+}
+
+func (m *MPI) sendVehicleBatch(dest int, batch []byte) {
+	tag := VEHICLE_OUT_TAG
+	if m.taskID == ROOT_ID {
+		tag = VEHICLE_IN_LEAF_TAG
+	}
+	m.send(batch, dest, tag)
+}
+
+// SetMetrics counts the messages of this process in mt
+func (m *MPI) SetMetrics(mt *Metrics) {
+	m.metrics = mt
+}
+
+// Metrics returns the metrics of this process, nil if none are counted
+func (m *MPI) Metrics() *Metrics {
+	return m.metrics
+}
+
+// SetPhaseTimer adds the time spent in MPI calls to pt
+func (m *MPI) SetPhaseTimer(pt *PhaseTimer) {
+	m.phases = pt
+}
+
+// StartEngine hands the communicator of the rank to a CommEngine until StopEngine is called. The root
+// receives RootTags and a leaf LeafTags through it. It must be called before the goroutines of the rank
+// start sending and receiving.
+func (m *MPI) StartEngine(poll time.Duration) error {
+	if m.engine != nil {
+		return errors.New("engine is already started")
+	}
+	tags := LeafTags
+	if m.taskID == ROOT_ID {
+		tags = RootTags
+	}
+	m.engine = newCommEngine(mpiTransport{m: m}, tags, poll)
+	return nil
+}
+
+// StopEngine sends the queued messages, later calls use the communicator directly
+func (m *MPI) StopEngine() {
+	m.engine.Stop()
+}
+
+// send sends bytes through the engine or directly and counts the message. Direct sends are timed.
+func (m *MPI) send(data []byte, dest int, tag int) {
+	if !m.engine.Send(data, dest, tag) {
+		mpiTransport{m: m}.send(data, dest, tag)
+	}
+	m.metrics.messageSent(tag)
+}
+
+// recv receives bytes and counts the message, it returns the bytes and their source.
+// Receives mostly wait for work, so they are not timed.
+func (m *MPI) recv(source int, tag int) ([]byte, int) {
+	msg, ok := m.engine.Receive(tag)
+	if !ok {
+		data, status := m.comm.RecvBytes(source, tag)
+		msg = Message{Source: status.GetSource(), Data: data}
+	}
+	m.metrics.messageReceived(tag)
+	return msg.Data, msg.Source
 }
 
 func (m *MPI) AskRootForEdgeLength(srcVertexID, destVertexID int) (float64, error) {
@@ -34,6 +147,15 @@ func (m *MPI) AskRootForEdgeLength(srcVertexID, destVertexID int) (float64, erro
 		return 0, errors.New("process is root")
 	}
 
+	// the whole round trip is time a vehicle cannot drive
+	defer func(start time.Time) {
+		m.phases.communicated(time.Since(start))
+	}(time.Now())
+	m.metrics.edgeRequestWaiting(1)
+	m.lengthMu.Lock()
+	defer m.lengthMu.Unlock()
+	m.metrics.edgeRequestWaiting(-1)
+
 	// package
 	e := EdgePackage{
 		Src:  srcVertexID,
@@ -48,13 +170,13 @@ func (m *MPI) AskRootForEdgeLength(srcVertexID, destVertexID int) (float64, erro
 	log.Info().Msgf("[%d] sending edge package len(%d)", m.taskID, len(edgePackage))
 	// send request to root
 	log.Debug().Msgf("[%d] sending edge %d->%d to root", m.taskID, e.Src, e.Dest)
-	m.comm.SendBytes(edgePackage, ROOT_ID, REQUEST_EDGE)
+	m.send(edgePackage, ROOT_ID, REQUEST_EDGE)
 
 	log.Info().Msgf("[%d] waiting to get edge package from root", m.taskID)
 	// receive edge length from root
 	//TODO: length, _ := m.comm.RecvFloat64(ROOT_ID, RECEIVE_EDGE)
-	bytes, status := m.comm.RecvBytes(ROOT_ID, RECEIVE_EDGE)
-	log.Info().Msgf("[%d] received edge package from %d", m.taskID, status.GetSource())
+	bytes, source := m.recv(ROOT_ID, RECEIVE_EDGE)
+	log.Info().Msgf("[%d] received edge package from %d", m.taskID, source)
 	lf, err := UnmarshalLengthFloat(bytes)
 	if err != nil {
 		log.Error().Msgf("failed to unmarshal length float: %s", err.Error())
@@ -64,68 +186,81 @@ func (m *MPI) AskRootForEdgeLength(srcVertexID, destVertexID int) (float64, erro
 	length := lf.Length
 
 	if length <= 0.0 {
-		return 0, errors.New("failed to pack edge package")
+		// root answers requests it cannot serve with a length of 0
+		return 0, fmt.Errorf("%w: %d -> %d", ErrMissingEdge, srcVertexID, destVertexID)
 	}
 
 	return length, nil
 }
 
 func (m *MPI) RespondToEdgeLengthRequest() error {
-	log.Info().Msgf("[%d] waiting for edge package - %d", m.taskID)
+	log.Info().Msgf("[%d] waiting for edge package", m.taskID)
 	if m.taskID != ROOT_ID {
 		return errors.New("process is not root")
 	}
 
 	log.Info().Msg("[root] waiting for edge package")
 
-	bytes, status := m.comm.RecvBytes(2, REQUEST_EDGE) // FIXME: MPI.ANY_SOURCE
+	bytes, source := m.recv(mpi.AnySource, REQUEST_EDGE)
 	edgePackage, err := UnmarshalEdgePackage(bytes)
 
-	log.Info().Msgf("[root] received edge package from %d", status.GetSource())
+	log.Info().Msgf("[root] received edge package from %d", source)
 
 	if err != nil {
-		return errors.New("failed to unmarshal edge package")
+		// the leaf still waits for an answer
+		m.sendEdgeLength(0, source)
+		return fmt.Errorf("%w: edge package from %d: %w", ErrMalformedInput, source, err)
 	}
 
-	log.Debug().Msgf("[root] received edge package from %d src(%d) dest(%d)", status.GetSource(), edgePackage.Src,
+	log.Debug().Msgf("[root] received edge package from %d src(%d) dest(%d)", source, edgePackage.Src,
 		edgePackage.Dest)
 	edge, err := m.g.Graph.Edge(edgePackage.Src, edgePackage.Dest)
 
 	if err != nil {
 		log.Error().Msgf("failed to get edge: %s", err.Error())
-		return err
+		m.sendEdgeLength(0, source)
+		return fmt.Errorf("%w: %d -> %d requested by %d", ErrMissingEdge, edgePackage.Src, edgePackage.Dest, source)
 	}
 
 	data, ok := edge.Properties.Data.(Data)
 	if !ok {
-		return errors.New("edge data is not of type Data")
+		// the leaf treats the edge as missing
+		m.sendEdgeLength(0, source)
+		return fmt.Errorf("%w: %d -> %d requested by %d has no edge data", ErrMissingEdge, edgePackage.Src, edgePackage.Dest, source)
 	}
 
 	log.Info().Msgf("[root] sending edge package %f", data.Length)
 
 	// send edge length to sender
-	lf := LengthFloat{Length: data.Length}
+	if err := m.sendEdgeLength(data.Length, source); err != nil {
+		return err
+	}
+	m.metrics.edgeRequestServed()
+
+	return nil
+}
+
+// sendEdgeLength answers the edge length request of a leaf, a length of 0 tells it the edge is missing
+func (m *MPI) sendEdgeLength(length float64, dest int) error {
+	lf := LengthFloat{Length: length}
 	lfBytes, err := lf.Marshal()
 	if err != nil {
 		log.Error().Msgf("failed to marshal length float: %s", err.Error())
 		return errors.New("failed to pack length float")
 	}
-	m.comm.SendBytes(lfBytes, status.GetSource(), RECEIVE_EDGE)
-
+	m.send(lfBytes, dest, RECEIVE_EDGE)
 	return nil
 }
 
 // SendVehicleToRoot sends a vehicle to the root process using MPI Broadcast
 func (m *MPI) SendVehicleToRoot(vehicle Vehicle) error {
+	vehicle.Handoffs++
 	jBytes, err := vehicle.Marshal()
 	if err != nil {
 		return errors.New("failed to pack vehicle")
 	}
 	log.Info().Msgf("[%d] sending vehicle to root", m.taskID)
-	m.comm.SendBytes(jBytes, ROOT_ID, VEHICLE_OUT_TAG)
-
-	// TODO: delete vehicle from current graph
-	return nil
+	return m.outbox.Add(ROOT_ID, jBytes)
 }
 
 func (m *MPI) EmitVehicle(vehicle Vehicle, lookupTable map[int]int) error {
@@ -147,7 +282,9 @@ func (m *MPI) EmitVehicle(vehicle Vehicle, lookupTable map[int]int) error {
 		return errors.New("failed to find target leaf")
 	}
 
-	m.comm.SendBytes(jBytes, targetID, VEHICLE_IN_LEAF_TAG)
+	if err := m.outbox.Add(targetID, jBytes); err != nil {
+		return err
+	}
 
 	log.Info().Msgf("[%d] sent vehicle - %s", m.taskID, vehicle.ID)
 
@@ -159,35 +296,224 @@ func (m *MPI) ReceiveAndSendVehicleOverRoot(lookupTable map[int]int) error {
 		return errors.New("process is not root")
 	}
 
-	jBytes, status := m.comm.RecvBytes(mpi.AnySource, VEHICLE_OUT_TAG)
-	log.Debug().Msgf("[%d] received vehicle request from %d", m.taskID, status.GetSource())
-	vehicle, err := UnmarshalVehicle(jBytes)
-	log.Info().Msgf("[%d] received vehicle from %d", m.taskID, status.GetSource())
-
+	batch, source := m.recv(mpi.AnySource, VEHICLE_OUT_TAG)
+	log.Debug().Msgf("[%d] received vehicle batch from %d", m.taskID, source)
+	encoded, err := splitVehicleBatch(batch)
 	if err != nil {
-		log.Error().Msgf("failed to unmarshal vehicle: %s", err.Error())
 		return err
 	}
-	targetID := lookupTable[vehicle.NextID]
-	//targetID, err := m.g.GetRectFromVertexID(vehicle.NextID, leafs)
-	if targetID == 0 {
-		return errors.New("failed to find target leaf")
+
+	// the vehicles are forwarded as they were encoded by the leaf
+	for _, jBytes := range encoded {
+		vehicle, err := UnmarshalVehicle(jBytes)
+		if err != nil {
+			log.Error().Msgf("failed to unmarshal vehicle: %s", err.Error())
+			return err
+		}
+		log.Info().Msgf("[%d] received vehicle from %d", m.taskID, source)
+		targetID := lookupTable[vehicle.NextID]
+		if targetID <= 0 {
+			return errors.New("failed to find target leaf")
+		}
+		if err := m.outbox.Add(targetID, jBytes); err != nil {
+			return err
+		}
 	}
-	if targetID == -1 {
-		return errors.New("failed to find target leaf")
+	return nil
+}
+
+// ReceiveVehiclesOnLeaf receives the next batch of vehicles sent to the leaf
+func (m *MPI) ReceiveVehiclesOnLeaf() ([]Vehicle, error) {
+	batch, _ := m.recv(ROOT_ID, VEHICLE_IN_LEAF_TAG)
+	if messageType(batch) == MsgStop {
+		return nil, ErrStopped
+	}
+	return DecodeVehicleBatch(batch)
+}
+
+// StopLeaves tells every leaf that no more vehicles will arrive
+func (m *MPI) StopLeaves() error {
+	if m.taskID != ROOT_ID {
+		return errors.New("process is not root")
+	}
+
+	// the stop marker must not overtake buffered vehicles
+	m.outbox.Close()
+	for leafID := 1; leafID < m.comm.Size(); leafID++ {
+		m.send(stopMarker, leafID, VEHICLE_IN_LEAF_TAG)
 	}
+	return nil
+}
 
-	m.comm.SendBytes(jBytes, targetID, VEHICLE_IN_LEAF_TAG)
+// SendTripRecordToRoot sends the trip record of a parked vehicle to the root process
+func (m *MPI) SendTripRecordToRoot(record TripRecord) error {
+	rBytes, err := record.Marshal()
+	if err != nil {
+		return errors.New("failed to pack trip record")
+	}
+	m.send(rBytes, ROOT_ID, TRIP_RECORD_TAG)
 	return nil
 }
 
-func (m *MPI) ReceiveVehicleOnLeaf() (Vehicle, error) {
-	jBytes, _ := m.comm.RecvBytes(ROOT_ID, VEHICLE_IN_LEAF_TAG)
-	vehicle, err := UnmarshalVehicle(jBytes)
+// ReceiveTripRecord receives the trip record of a vehicle parked on any leaf
+func (m *MPI) ReceiveTripRecord() (TripRecord, error) {
+	if m.taskID != ROOT_ID {
+		return TripRecord{}, errors.New("process is not root")
+	}
+
+	rBytes, source := m.recv(mpi.AnySource, TRIP_RECORD_TAG)
+	record, err := UnmarshalTripRecord(rBytes)
+	if err != nil {
+		return TripRecord{}, err
+	}
+	log.Debug().Msgf("[%d] received trip record %s from %d", m.taskID, record.ID, source)
+	return record, nil
+}
+
+// SendEdgeStatsToRoot sends the edge x interval table of the leaf to the root process
+func (m *MPI) SendEdgeStatsToRoot(rows []EdgeInterval) error {
+	sBytes, err := MarshalEdgeIntervals(rows)
 	if err != nil {
-		return Vehicle{}, err
+		return errors.New("failed to pack edge stats")
+	}
+	m.send(sBytes, ROOT_ID, EDGE_STATS_TAG)
+	return nil
+}
+
+// ReceiveEdgeStats receives the edge x interval tables of all leaves and merges them
+func (m *MPI) ReceiveEdgeStats() ([]EdgeInterval, error) {
+	if m.taskID != ROOT_ID {
+		return nil, errors.New("process is not root")
+	}
+
+	tables := make([][]EdgeInterval, 0)
+	for i := 1; i < m.comm.Size(); i++ {
+		sBytes, source := m.recv(mpi.AnySource, EDGE_STATS_TAG)
+		rows, err := UnmarshalEdgeIntervals(sBytes)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("[%d] received %d edge intervals from %d", m.taskID, len(rows), source)
+		tables = append(tables, rows)
+	}
+	return MergeEdgeIntervals(tables...), nil
+}
+
+// SendCheckpointCommand sends a checkpoint command from the root to a leaf
+func (m *MPI) SendCheckpointCommand(leafID int, cmd CheckpointCommand) error {
+	if m.taskID != ROOT_ID {
+		return errors.New("process is not root")
+	}
+	cBytes, err := cmd.Marshal()
+	if err != nil {
+		return errors.New("failed to pack checkpoint command")
+	}
+	m.send(cBytes, leafID, CHECKPOINT_TAG)
+	return nil
+}
+
+// ReceiveCheckpointCommand waits for the next checkpoint command of the root
+func (m *MPI) ReceiveCheckpointCommand() (CheckpointCommand, error) {
+	cBytes, _ := m.recv(ROOT_ID, CHECKPOINT_TAG)
+	return UnmarshalCheckpointCommand(cBytes)
+}
+
+// SendCheckpointReply answers a checkpoint command
+func (m *MPI) SendCheckpointReply(reply CheckpointReply) error {
+	rBytes, err := reply.Marshal()
+	if err != nil {
+		return errors.New("failed to pack checkpoint reply")
+	}
+	m.send(rBytes, ROOT_ID, CHECKPOINT_REPLY_TAG)
+	return nil
+}
+
+// ReceiveCheckpointReply waits for the answer of a leaf to a checkpoint command
+func (m *MPI) ReceiveCheckpointReply(leafID int) (CheckpointReply, error) {
+	rBytes, _ := m.recv(leafID, CHECKPOINT_REPLY_TAG)
+	return UnmarshalCheckpointReply(rBytes)
+}
+
+// SendParkingToRoot sends the parking events of the facilities owned by the leaf to the root process
+func (m *MPI) SendParkingToRoot(events []ParkingEvent) error {
+	pBytes, err := MarshalParkingEvents(events)
+	if err != nil {
+		return errors.New("failed to pack parking events")
+	}
+	m.send(pBytes, ROOT_ID, PARKING_TAG)
+	return nil
+}
+
+// ReceiveParking receives the parking events of all leaves
+func (m *MPI) ReceiveParking() ([]ParkingEvent, error) {
+	if m.taskID != ROOT_ID {
+		return nil, errors.New("process is not root")
+	}
+
+	events := make([]ParkingEvent, 0)
+	for i := 1; i < m.comm.Size(); i++ {
+		pBytes, source := m.recv(mpi.AnySource, PARKING_TAG)
+		leafEvents, err := UnmarshalParkingEvents(pBytes)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Msgf("[%d] received %d parking events from %d", m.taskID, len(leafEvents), source)
+		events = append(events, leafEvents...)
+	}
+	sortParkingEvents(events)
+	return events, nil
+}
+
+// SendPositionsToRoot sends the sampled vehicle positions of the leaf to the root process
+func (m *MPI) SendPositionsToRoot(positions []VehiclePosition) error {
+	pBytes, err := MarshalPositions(positions)
+	if err != nil {
+		return errors.New("failed to pack positions")
+	}
+	m.send(pBytes, ROOT_ID, POSITIONS_TAG)
+	return nil
+}
+
+// ReceivePositions receives the sampled vehicle positions of any leaf and returns the sending leaf
+func (m *MPI) ReceivePositions() (int, []VehiclePosition, error) {
+	if m.taskID != ROOT_ID {
+		return 0, nil, errors.New("process is not root")
+	}
+
+	pBytes, source := m.recv(mpi.AnySource, POSITIONS_TAG)
+	positions, err := UnmarshalPositions(pBytes)
+	if err != nil {
+		return 0, nil, err
+	}
+	return source, positions, nil
+}
+
+// SendPhasesToRoot sends the timings of the leaf to the root process
+func (m *MPI) SendPhasesToRoot(rp RankPhases) error {
+	pBytes, err := rp.Marshal()
+	if err != nil {
+		return errors.New("failed to pack phase timings")
+	}
+	m.send(pBytes, ROOT_ID, PHASES_TAG)
+	return nil
+}
+
+// ReceivePhases receives the timings of all leaves
+func (m *MPI) ReceivePhases() ([]RankPhases, error) {
+	if m.taskID != ROOT_ID {
+		return nil, errors.New("process is not root")
+	}
+
+	ranks := make([]RankPhases, 0)
+	for i := 1; i < m.comm.Size(); i++ {
+		pBytes, _ := m.recv(mpi.AnySource, PHASES_TAG)
+		rp, err := UnmarshalRankPhases(pBytes)
+		if err != nil {
+			return nil, err
+		}
+		ranks = append(ranks, rp)
 	}
-	return vehicle, nil
+	return ranks, nil
 }
 
 func (m *MPI) SendDoneToRoot() {
@@ -1,10 +1,12 @@
 package streets
 
 import (
+	"github.com/dominikbraun/graph"
 	mpi2 "github.com/sbromberger/gompi"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewMPI(t *testing.T) {
@@ -85,3 +87,30 @@ func TestEdgeRequest(t *testing.T) {
 		assert.Equal(t, edge.Length, length)
 	}
 }
+
+func TestRespondToEdgeLengthRequest_NoData(t *testing.T) {
+	g := graph.New(func(v JVertex) int { return v.ID }, graph.Directed())
+	_ = g.AddVertex(JVertex{ID: 2})
+	_ = g.AddVertex(JVertex{ID: 4})
+	_ = g.AddEdge(2, 4, graph.EdgeData("not edge data"))
+
+	ft := newFakeTransport(t)
+	m := &MPI{taskID: ROOT_ID, g: &StreetGraph{Graph: g}}
+	m.engine = newCommEngine(ft, RootTags, time.Millisecond)
+	request, err := (&EdgePackage{Src: 2, Dest: 4}).Marshal()
+	assert.NoError(t, err)
+	ft.arrive(REQUEST_EDGE, Message{Source: 3, Data: request})
+
+	err = m.RespondToEdgeLengthRequest()
+	assert.ErrorIs(t, err, ErrMissingEdge)
+	m.engine.Stop()
+
+	// the leaf is answered with the length of a missing edge
+	if assert.Equal(t, 1, ft.sentCount()) {
+		assert.Equal(t, 3, ft.sent[0].dest)
+		assert.Equal(t, RECEIVE_EDGE, ft.sent[0].tag)
+		length, err := UnmarshalLengthFloat(ft.sent[0].data)
+		assert.NoError(t, err)
+		assert.Equal(t, 0., length.Length)
+	}
+}
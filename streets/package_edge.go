@@ -1,28 +1,21 @@
 package streets
 
-import (
-	"bytes"
-	"encoding/gob"
-)
-
 func UnmarshalEdgePackage(data []byte) (EdgePackage, error) {
 	var r EdgePackage
-	byteBuffer := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(byteBuffer)
-
-	err := dec.Decode(&r)
-
-	return r, err
+	d, err := openEnvelope(data, MsgEdgeRequest)
+	if err != nil {
+		return r, err
+	}
+	r.Src = d.int()
+	r.Dest = d.int()
+	return r, d.close()
 }
 
 func (r *EdgePackage) Marshal() ([]byte, error) {
-	tmp := *r
-
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-
-	err := enc.Encode(tmp)
-	return buf.Bytes(), err
+	return seal(MsgEdgeRequest, func(e *encoder) {
+		e.int(r.Src)
+		e.int(r.Dest)
+	}), nil
 }
 
 type EdgePackage struct {
@@ -1,28 +1,19 @@
 package streets
 
-import (
-	"bytes"
-	"encoding/gob"
-)
-
 type LengthFloat struct {
 	Length float64
 }
 
 func (l *LengthFloat) Marshal() ([]byte, error) {
-	tmp := *l
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-
-	err := enc.Encode(tmp)
-	return buf.Bytes(), err
+	return seal(MsgEdgeLength, func(e *encoder) { e.f64(l.Length) }), nil
 }
 
 func UnmarshalLengthFloat(data []byte) (LengthFloat, error) {
 	var lengthFloat LengthFloat
-	byteBuffer := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(byteBuffer)
-
-	err := dec.Decode(&lengthFloat)
-	return lengthFloat, err
+	d, err := openEnvelope(data, MsgEdgeLength)
+	if err != nil {
+		return lengthFloat, err
+	}
+	lengthFloat.Length = d.f64()
+	return lengthFloat, d.close()
 }
@@ -0,0 +1,500 @@
+package streets
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultSearchRadius is the distance in meters around the destination vehicles cruise for a spot
+	defaultSearchRadius = 500.
+	// defaultMaxTries is the number of facilities a vehicle tries before it parks on the street
+	defaultMaxTries = 5
+	// earthRadius in meters
+	earthRadius = 6371000.
+)
+
+// Parking is the set of parking facilities of a scenario
+type Parking struct {
+	Facilities []ParkingFacility `json:"facilities"`
+	// SearchRadius in meters around the destination in which vehicles look for alternatives
+	SearchRadius float64 `json:"search_radius"`
+	// MaxTries is the number of full facilities a vehicle tries before it parks on the street
+	MaxTries int `json:"max_tries"`
+}
+
+// ParkingFacility is a car park at a vertex or on-street parking along an edge
+type ParkingFacility struct {
+	ID string `json:"id"`
+	// Vertex is the entrance of a car park, nil for on-street parking
+	Vertex *int `json:"vertex,omitempty"`
+	// From and To is the edge of on-street parking, it is reached at To
+	From     int `json:"from"`
+	To       int `json:"to"`
+	Capacity int `json:"capacity"`
+	// Stay is how long a vehicle occupies a spot in simulated seconds, 0 until the end of the run
+	Stay float64 `json:"stay"`
+}
+
+// onStreet reports whether the facility is parking along an edge rather than a car park
+func (f *ParkingFacility) onStreet() bool {
+	return f.Vertex == nil
+}
+
+// access returns the vertex at which vehicles enter the facility
+func (f *ParkingFacility) access() int {
+	if f.onStreet() {
+		return f.To
+	}
+	return *f.Vertex
+}
+
+// ParkingEvent is a vehicle taking a spot or being turned away by a full facility
+type ParkingEvent struct {
+	Facility string  `json:"facility"`
+	Vehicle  string  `json:"vehicle"`
+	Time     float64 `json:"time"`
+	// Until is the end of the stay, 0 if the vehicle stays until the end of the run
+	Until    float64 `json:"until"`
+	Rejected bool    `json:"rejected"`
+}
+
+// occupies reports whether the event holds a spot at time t
+func (e *ParkingEvent) occupies(t float64) bool {
+	return !e.Rejected && e.Time <= t && (e.Until == 0 || t < e.Until)
+}
+
+// ParkingSearch is the state of a vehicle looking for a spot
+type ParkingSearch struct {
+	Searching bool `json:"searching"`
+	// Start and From are the time and the distance driven when the vehicle reached its destination
+	Start float64 `json:"start"`
+	From  float64 `json:"from"`
+	// Tried are the facilities the vehicle has tried
+	Tried    []string `json:"tried"`
+	Facility string   `json:"facility"`
+}
+
+func UnmarshalParkingJSON(data []byte) (Parking, error) {
+	var r Parking
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+func (r *Parking) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LoadParkingFile reads parking facilities from a JSON file
+func LoadParkingFile(path string) (Parking, error) {
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Parking{}, err
+	}
+	return UnmarshalParkingJSON(jBytes)
+}
+
+// ParkingRegistry tracks the occupancy of the facilities at the vertices of a graph. Every graph knows all
+// facilities to pick alternatives, but only books spots in the facilities it owns. It is safe for concurrent use.
+type ParkingRegistry struct {
+	parking  Parking
+	owned    map[string]bool
+	byVertex map[int][]ParkingFacility
+
+	mu     sync.Mutex
+	events map[string][]ParkingEvent
+}
+
+// EnableParking attaches the parking facilities to the graph, the graph owns the facilities at its vertices
+func (g *StreetGraph) EnableParking(p Parking) error {
+	if p.SearchRadius <= 0 {
+		p.SearchRadius = defaultSearchRadius
+	}
+	if p.MaxTries <= 0 {
+		p.MaxTries = defaultMaxTries
+	}
+
+	r := &ParkingRegistry{
+		parking:  p,
+		owned:    make(map[string]bool),
+		byVertex: make(map[int][]ParkingFacility),
+		events:   make(map[string][]ParkingEvent),
+	}
+	ids := make(map[string]bool)
+	for _, f := range p.Facilities {
+		if f.ID == "" {
+			return errors.New("parking facility needs an id")
+		}
+		if ids[f.ID] {
+			return fmt.Errorf("facility %s: duplicate id", f.ID)
+		}
+		ids[f.ID] = true
+		if f.Capacity < 0 {
+			return fmt.Errorf("facility %s: negative capacity", f.ID)
+		}
+		if f.onStreet() {
+			if _, err := g.edgeData(f.From, f.To); err != nil {
+				return fmt.Errorf("facility %s: no edge %d->%d", f.ID, f.From, f.To)
+			}
+		} else if _, err := g.vertex(*f.Vertex); err != nil {
+			return fmt.Errorf("facility %s: vertex %d does not exist", f.ID, *f.Vertex)
+		}
+
+		r.byVertex[f.access()] = append(r.byVertex[f.access()], f)
+		if g.VertexExists(f.access()) {
+			r.owned[f.ID] = true
+		}
+	}
+
+	// vehicles look for alternatives concurrently, so the successors are computed up front
+	if _, err := g.routingGraph().successors(); err != nil {
+		return err
+	}
+	g.Parking = r
+	return nil
+}
+
+// routingGraph is the graph paths are searched on, the root graph for a leaf
+func (g *StreetGraph) routingGraph() *StreetGraph {
+	if g.RootGraph != nil {
+		return g.RootGraph
+	}
+	return g
+}
+
+// occupy books a spot in an untried facility at vertex for the vehicle and returns its ID,
+// false if there is none or all of them are full
+func (r *ParkingRegistry) occupy(vertex int, v *Vehicle) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.byVertex[vertex] {
+		if !r.owned[f.ID] || contains(v.Parking.Tried, f.ID) {
+			continue
+		}
+		v.Parking.Tried = append(v.Parking.Tried, f.ID)
+
+		event := ParkingEvent{Facility: f.ID, Vehicle: v.ID, Time: v.Time}
+		if f.Stay > 0 {
+			event.Until = v.Time + f.Stay
+		}
+		if r.peak(f.ID, event) >= f.Capacity {
+			event.Rejected = true
+			event.Until = 0
+			r.events[f.ID] = append(r.events[f.ID], event)
+			continue
+		}
+		r.events[f.ID] = append(r.events[f.ID], event)
+		return f.ID, true
+	}
+	return "", false
+}
+
+// peak returns the highest number of taken spots of a facility during the stay of event. Vehicles keep their own
+// time, so a spot may already be booked by a vehicle that arrives later in simulated time.
+func (r *ParkingRegistry) peak(facility string, event ParkingEvent) int {
+	peak := 0
+	count := func(t float64) {
+		occupied := 0
+		for _, e := range r.events[facility] {
+			if e.occupies(t) {
+				occupied++
+			}
+		}
+		if occupied > peak {
+			peak = occupied
+		}
+	}
+
+	count(event.Time)
+	for _, e := range r.events[facility] {
+		if !e.Rejected && e.Time > event.Time && (event.Until == 0 || e.Time < event.Until) {
+			count(e.Time)
+		}
+	}
+	return peak
+}
+
+// alternative returns the path from vertex to the closest untried facility within the search radius of the
+// destination. Availability is only known to the owner of a facility, so the vehicle has to drive there.
+func (r *ParkingRegistry) alternative(g *StreetGraph, vertex int, v *Vehicle) ([]int, bool) {
+	dest, err := g.vertex(v.Destination)
+	if err != nil {
+		return nil, false
+	}
+
+	type candidate struct {
+		facility ParkingFacility
+		distance float64
+	}
+	candidates := make([]candidate, 0)
+	for _, f := range r.parking.Facilities {
+		if f.Capacity == 0 || contains(v.Parking.Tried, f.ID) || f.access() == vertex {
+			continue
+		}
+		a, err := g.vertex(f.access())
+		if err != nil {
+			continue
+		}
+		if d := vertexDistance(dest, a); d <= r.parking.SearchRadius {
+			candidates = append(candidates, candidate{f, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	routing := g.routingGraph()
+	class, _ := v.VehicleClass()
+	var classPtr *VehicleClass
+	if v.Class != "" {
+		classPtr = &class
+	}
+	for _, c := range candidates {
+		path, err := routing.ShortestPathFor(vertex, c.facility.access(), classPtr)
+		if err != nil {
+			continue
+		}
+		// on-street parking is reached by driving its edge
+		if c.facility.onStreet() && len(path) >= 2 && path[len(path)-2] != c.facility.From {
+			if toFrom, err := routing.ShortestPathFor(vertex, c.facility.From, classPtr); err == nil && !contains(toFrom, c.facility.To) {
+				path = append(toFrom, c.facility.To)
+			}
+		}
+		return path, true
+	}
+	return nil, false
+}
+
+// FindParking is called when the vehicle reaches vertex at the end of its path. Without facilities it parks
+// there, otherwise it takes a free spot or cruises to the closest facility it has not tried yet.
+// It parks on the street once it has tried MaxTries facilities or there is no alternative left.
+func (v *Vehicle) FindParking(vertex int) {
+	v.IsParked = true
+	registry := v.StreetGraph.Parking
+	if registry == nil || v.Line != "" {
+		return
+	}
+	if !v.Parking.Searching {
+		v.Parking.Searching = true
+		v.Parking.Start = v.Time
+		v.Parking.From = v.Distance
+	}
+
+	if id, ok := registry.occupy(vertex, v); ok {
+		v.Parking.Facility = id
+		return
+	}
+	if len(v.Parking.Tried) >= registry.parking.MaxTries {
+		return
+	}
+	path, ok := registry.alternative(v.StreetGraph, vertex, v)
+	if !ok {
+		return
+	}
+
+	log.Debug().Msgf("[%s] cruises from %d to %d for parking", v.ID, vertex, path[len(path)-1])
+	v.IsParked = false
+	v.Route = NewRoute(path)
+	v.PrevID = vertex
+	v.NextID = path[1]
+	v.MarkedForDeletion = v.NextIsForeign()
+}
+
+// SearchTime and SearchDistance are the time and distance driven looking for a spot
+func (v *Vehicle) SearchTime() float64 {
+	if !v.Parking.Searching {
+		return 0
+	}
+	return v.Time - v.Parking.Start
+}
+
+func (v *Vehicle) SearchDistance() float64 {
+	if !v.Parking.Searching {
+		return 0
+	}
+	return v.Distance - v.Parking.From
+}
+
+// Events returns the events of the facilities owned by the registry
+func (r *ParkingRegistry) Events() []ParkingEvent {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]ParkingEvent, 0)
+	for _, facilityEvents := range r.events {
+		events = append(events, facilityEvents...)
+	}
+	sortParkingEvents(events)
+	return events
+}
+
+// Restore adds the events of a checkpoint, events of facilities owned by other graphs are skipped
+func (r *ParkingRegistry) Restore(events []ParkingEvent) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range events {
+		if r.owned[e.Facility] {
+			r.events[e.Facility] = append(r.events[e.Facility], e)
+		}
+	}
+}
+
+// Facilities returns all facilities known to the registry
+func (r *ParkingRegistry) Facilities() []ParkingFacility {
+	if r == nil {
+		return nil
+	}
+	return r.parking.Facilities
+}
+
+func sortParkingEvents(events []ParkingEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Facility != events[j].Facility {
+			return events[i].Facility < events[j].Facility
+		}
+		if events[i].Time != events[j].Time {
+			return events[i].Time < events[j].Time
+		}
+		return events[i].Vehicle < events[j].Vehicle
+	})
+}
+
+func MarshalParkingEvents(events []ParkingEvent) ([]byte, error) {
+	return encodeParkingEvents(events), nil
+}
+
+func UnmarshalParkingEvents(data []byte) ([]ParkingEvent, error) {
+	return decodeParkingEvents(data)
+}
+
+// ParkingInterval is the occupancy of a facility in one interval of simulated time
+type ParkingInterval struct {
+	Facility string
+	Interval int
+	Capacity int
+	// Occupied is the number of taken spots at the end of the interval
+	Occupied int
+	Arrivals int
+	Rejected int
+}
+
+// ParkingOccupancy builds the facility x interval table from the events of all ranks
+func ParkingOccupancy(facilities []ParkingFacility, events []ParkingEvent, interval float64) []ParkingInterval {
+	if interval <= 0 {
+		return nil
+	}
+	byFacility := make(map[string][]ParkingEvent)
+	last := 0
+	for _, e := range events {
+		byFacility[e.Facility] = append(byFacility[e.Facility], e)
+		if i := int(e.Time / interval); i > last {
+			last = i
+		}
+	}
+
+	rows := make([]ParkingInterval, 0)
+	for _, f := range facilities {
+		for i := 0; i <= last; i++ {
+			row := ParkingInterval{Facility: f.ID, Interval: i, Capacity: f.Capacity}
+			end := float64(i+1) * interval
+			for _, e := range byFacility[f.ID] {
+				if e.occupies(end) {
+					row.Occupied++
+				}
+				if int(e.Time/interval) != i {
+					continue
+				}
+				if e.Rejected {
+					row.Rejected++
+				} else {
+					row.Arrivals++
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Facility != rows[j].Facility {
+			return rows[i].Facility < rows[j].Facility
+		}
+		return rows[i].Interval < rows[j].Interval
+	})
+	return rows
+}
+
+// WriteParkingFile writes the facility x interval table as CSV
+func WriteParkingFile(path string, rows []ParkingInterval, interval float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	w := csv.NewWriter(bw)
+	_ = w.Write([]string{"facility", "interval", "start", "capacity", "occupied", "occupancy", "arrivals", "rejected"})
+
+	ff := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for _, r := range rows {
+		occupancy := ""
+		if r.Capacity > 0 {
+			occupancy = ff(float64(r.Occupied) / float64(r.Capacity))
+		}
+		_ = w.Write([]string{
+			r.Facility,
+			strconv.Itoa(r.Interval),
+			ff(float64(r.Interval) * interval),
+			strconv.Itoa(r.Capacity),
+			strconv.Itoa(r.Occupied),
+			occupancy,
+			strconv.Itoa(r.Arrivals),
+			strconv.Itoa(r.Rejected),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// vertexDistance is the distance in meters between two vertices given in degrees of longitude and latitude
+func vertexDistance(a, b JVertex) float64 {
+	toRad := math.Pi / 180
+	x := (b.X - a.X) * toRad * math.Cos((a.Y+b.Y)/2*toRad)
+	y := (b.Y - a.Y) * toRad
+	return math.Hypot(x, y) * earthRadius
+}
+
+func contains[T comparable](s []T, x T) bool {
+	for _, y := range s {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ringGraph(t *testing.T) *StreetGraph {
+	vertices := []JVertex{
+		{ID: 1, X: 1, Y: 1},
+		{ID: 2, X: 1.001, Y: 1},
+		{ID: 3, X: 1.001, Y: 1.001},
+		{ID: 4, X: 1, Y: 1.001},
+	}
+	edges := []JEdge{
+		{From: 1, To: 2, Length: 10, MaxSpeed: "50"},
+		{From: 2, To: 3, Length: 10, MaxSpeed: "50"},
+		{From: 3, To: 4, Length: 10, MaxSpeed: "50"},
+		{From: 4, To: 1, Length: 10, MaxSpeed: "50"},
+	}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func vertexAt(vertex int) *int {
+	return &vertex
+}
+
+func driveTo(t *testing.T, g *StreetGraph, path []int) *Vehicle {
+	v, err := g.newVehicleOnPath(path, 5, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Drive()
+	return v
+}
+
+func TestVehicle_FindParking(t *testing.T) {
+	g := ringGraph(t)
+	err := g.EnableParking(Parking{Facilities: []ParkingFacility{
+		{ID: "A", Vertex: vertexAt(3), Capacity: 1},
+		{ID: "B", From: 4, To: 1, Capacity: 5},
+	}})
+	assert.NoError(t, err)
+
+	first := driveTo(t, g, []int{1, 2, 3})
+	assert.True(t, first.IsParked)
+	assert.Equal(t, "A", first.Parking.Facility)
+	assert.Equal(t, 0., first.SearchDistance())
+
+	// A is full, the second vehicle cruises along the edge of B
+	second := driveTo(t, g, []int{1, 2, 3})
+	assert.True(t, second.IsParked)
+	assert.Equal(t, "B", second.Parking.Facility)
+	assert.Equal(t, []string{"A", "B"}, second.Parking.Tried)
+	assert.Equal(t, []int{3, 4, 1}, second.PathIDs)
+	assert.Equal(t, 20., second.SearchDistance())
+	assert.Greater(t, second.SearchTime(), 0.)
+
+	record := second.TripRecord()
+	assert.Equal(t, 3, record.Destination)
+	assert.Equal(t, "B", record.Facility)
+	assert.Equal(t, 40., record.Distance)
+
+	events := g.Parking.Events()
+	assert.Equal(t, 3, len(events))
+	rejected := 0
+	for _, e := range events {
+		if e.Rejected {
+			rejected++
+			assert.Equal(t, second.ID, e.Vehicle)
+		}
+	}
+	assert.Equal(t, 1, rejected)
+}
+
+func TestVehicle_FindParkingGivesUp(t *testing.T) {
+	g := ringGraph(t)
+	err := g.EnableParking(Parking{Facilities: []ParkingFacility{
+		{ID: "A", Vertex: vertexAt(3), Capacity: 0},
+		{ID: "B", Vertex: vertexAt(1), Capacity: 5},
+	}, MaxTries: 1})
+	assert.NoError(t, err)
+
+	v := driveTo(t, g, []int{1, 2, 3})
+	assert.True(t, v.IsParked)
+	assert.Equal(t, "", v.Parking.Facility)
+	assert.Equal(t, []int{1, 2, 3}, v.PathIDs)
+
+	assert.Error(t, g.EnableParking(Parking{Facilities: []ParkingFacility{{ID: "C", Vertex: vertexAt(99), Capacity: 1}}}))
+	assert.Error(t, g.EnableParking(Parking{Facilities: []ParkingFacility{{ID: "D", From: 1, To: 3, Capacity: 1}}}))
+}
+
+func TestParkingOccupancy(t *testing.T) {
+	facilities := []ParkingFacility{{ID: "A", Vertex: vertexAt(1), Capacity: 2}}
+	events := []ParkingEvent{
+		{Facility: "A", Vehicle: "a", Time: 10, Until: 70},
+		{Facility: "A", Vehicle: "b", Time: 20},
+		{Facility: "A", Vehicle: "c", Time: 30, Rejected: true},
+		{Facility: "A", Vehicle: "d", Time: 130},
+	}
+
+	rows := ParkingOccupancy(facilities, events, 60)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, ParkingInterval{Facility: "A", Interval: 0, Capacity: 2, Occupied: 2, Arrivals: 2, Rejected: 1}, rows[0])
+	assert.Equal(t, 1, rows[1].Occupied)
+	assert.Equal(t, 2, rows[2].Occupied)
+	assert.Equal(t, 1, rows[2].Arrivals)
+
+	b, err := MarshalParkingEvents(events)
+	assert.NoError(t, err)
+	decoded, err := UnmarshalParkingEvents(b)
+	assert.NoError(t, err)
+	assert.Equal(t, events, decoded)
+}
+
+func TestParkingRegistry_OccupyOutOfOrder(t *testing.T) {
+	g := ringGraph(t)
+	assert.NoError(t, g.EnableParking(Parking{Facilities: []ParkingFacility{{ID: "A", Vertex: vertexAt(3), Capacity: 1, Stay: 100}}}))
+
+	late := &Vehicle{ID: "late", Time: 200}
+	_, ok := g.Parking.occupy(3, late)
+	assert.True(t, ok)
+
+	// an earlier arrival would still be parked when the spot is taken at 200
+	early := &Vehicle{ID: "early", Time: 150}
+	_, ok = g.Parking.occupy(3, early)
+	assert.False(t, ok)
+
+	// but leaves in time before it
+	earlier := &Vehicle{ID: "earlier", Time: 50}
+	_, ok = g.Parking.occupy(3, earlier)
+	assert.True(t, ok)
+}
+
+func TestParkingFacility_VertexZero(t *testing.T) {
+	vertices := []JVertex{{ID: 0, X: 1, Y: 1}, {ID: 1, X: 1.001, Y: 1}, {ID: 2, X: 1.001, Y: 1.001}}
+	edges := []JEdge{
+		{From: 0, To: 1, Length: 10, MaxSpeed: "50"},
+		{From: 1, To: 2, Length: 10, MaxSpeed: "50"},
+		{From: 2, To: 0, Length: 10, MaxSpeed: "50"},
+	}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	assert.NoError(t, err)
+
+	// a car park at vertex 0 is not mistaken for on-street parking
+	p, err := UnmarshalParkingJSON([]byte(`{"facilities": [{"id": "A", "vertex": 0, "capacity": 1}, {"id": "B", "from": 1, "to": 2, "capacity": 1}]}`))
+	assert.NoError(t, err)
+	assert.False(t, p.Facilities[0].onStreet())
+	assert.True(t, p.Facilities[1].onStreet())
+	assert.NoError(t, g.EnableParking(p))
+
+	v := driveTo(t, g, []int{1, 2, 0})
+	assert.True(t, v.IsParked)
+	assert.Equal(t, "A", v.Parking.Facility)
+	assert.Equal(t, 0, v.Destination)
+}
@@ -0,0 +1,179 @@
+package streets
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// phases of a run, in the order they start
+const (
+	PhaseGraphLoad  = "graph_load"
+	PhasePartition  = "partition"
+	PhaseVehicles   = "vehicles"
+	PhaseEmission   = "emission"
+	PhaseSimulation = "simulation"
+	PhaseShutdown   = "shutdown"
+)
+
+// Phases lists the phases of a run
+var Phases = []string{PhaseGraphLoad, PhasePartition, PhaseVehicles, PhaseEmission, PhaseSimulation, PhaseShutdown}
+
+// PhaseTimer records the wall time of the phases of a rank and the time its goroutines are blocked in MPI calls.
+// All methods are safe for concurrent use and do nothing on a nil *PhaseTimer.
+type PhaseTimer struct {
+	created time.Time
+
+	mu        sync.Mutex
+	started   map[string]time.Time
+	durations map[string]time.Duration
+
+	communication atomic.Int64
+}
+
+// NewPhaseTimer returns a timer whose total time starts now
+func NewPhaseTimer() *PhaseTimer {
+	return &PhaseTimer{created: time.Now(), started: make(map[string]time.Time), durations: make(map[string]time.Duration)}
+}
+
+// Start starts a phase, a phase started twice is timed from its first start
+func (pt *PhaseTimer) Start(phase string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if _, ok := pt.started[phase]; !ok {
+		pt.started[phase] = time.Now()
+	}
+}
+
+// Stop ends a phase, stopping it again extends it
+func (pt *PhaseTimer) Stop(phase string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if start, ok := pt.started[phase]; ok {
+		pt.durations[phase] = time.Since(start)
+	}
+}
+
+// communicated adds the time a goroutine was blocked in an MPI call
+func (pt *PhaseTimer) communicated(d time.Duration) {
+	if pt == nil {
+		return
+	}
+	pt.communication.Add(int64(d))
+}
+
+// Rank returns the timings of the rank so far
+func (pt *PhaseTimer) Rank(rank int) RankPhases {
+	rp := RankPhases{Rank: rank, Phases: make(map[string]float64)}
+	if pt == nil {
+		return rp
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	for phase, d := range pt.durations {
+		rp.Phases[phase] = d.Seconds()
+	}
+	rp.Communication = time.Duration(pt.communication.Load()).Seconds()
+	rp.Total = time.Since(pt.created).Seconds()
+	return rp
+}
+
+// RankPhases are the timings of a rank in seconds
+type RankPhases struct {
+	Rank   int                `json:"rank"`
+	Phases map[string]float64 `json:"phases"`
+	// Communication is the time goroutines were blocked in MPI sends and edge length requests, it can
+	// exceed Total as vehicles are driven concurrently
+	Communication float64 `json:"communication"`
+	Total         float64 `json:"total"`
+	// Workers is the utilisation of every worker stepping vehicles, empty if the rank has no workers
+	Workers []float64 `json:"workers,omitempty"`
+}
+
+func (rp *RankPhases) Marshal() ([]byte, error) {
+	return encodeRankPhases(rp), nil
+}
+
+func UnmarshalRankPhases(data []byte) (RankPhases, error) {
+	return decodeRankPhases(data)
+}
+
+// PhaseReport are the timings of all ranks of a run
+type PhaseReport struct {
+	Mode      string       `json:"mode"`
+	WorldSize int          `json:"world_size"`
+	Vehicles  int          `json:"vehicles"`
+	Ranks     []RankPhases `json:"ranks"`
+}
+
+// NewPhaseReport collects the timings of the ranks of a run
+func NewPhaseReport(mode string, worldSize, vehicles int, ranks []RankPhases) PhaseReport {
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i].Rank < ranks[j].Rank
+	})
+	return PhaseReport{Mode: mode, WorldSize: worldSize, Vehicles: vehicles, Ranks: ranks}
+}
+
+// Wall is the wall time of the run, the total of its slowest rank
+func (r PhaseReport) Wall() float64 {
+	wall := 0.
+	for _, rank := range r.Ranks {
+		if rank.Total > wall {
+			wall = rank.Total
+		}
+	}
+	return wall
+}
+
+// Phase is the time of a phase on its slowest rank
+func (r PhaseReport) Phase(phase string) float64 {
+	longest := 0.
+	for _, rank := range r.Ranks {
+		if d := rank.Phases[phase]; d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// CommunicationShare is the time blocked in MPI calls per second of rank time
+func (r PhaseReport) CommunicationShare() float64 {
+	communication, total := 0., 0.
+	for _, rank := range r.Ranks {
+		communication += rank.Communication
+		total += rank.Total
+	}
+	if total == 0 {
+		return 0
+	}
+	return communication / total
+}
+
+// WritePhaseReportFile writes a phase report as JSON
+func WritePhaseReportFile(path string, r PhaseReport) error {
+	jBytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(jBytes, '\n'), 0o664)
+}
+
+// LoadPhaseReportFile reads a phase report written by WritePhaseReportFile
+func LoadPhaseReportFile(path string) (PhaseReport, error) {
+	var r PhaseReport
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(jBytes, &r)
+	return r, err
+}
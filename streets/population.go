@@ -0,0 +1,143 @@
+package streets
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PopulationEntry is one line of a population file
+type PopulationEntry struct {
+	ID        string  `json:"id"`
+	Path      []int   `json:"path"`
+	Speed     float64 `json:"speed"`
+	Departure float64 `json:"departure"`
+	Class     string  `json:"class,omitempty"`
+}
+
+// WritePopulation writes vehicles as JSON Lines, one PopulationEntry per line
+func WritePopulation(w io.Writer, vehicles []*Vehicle) error {
+	enc := json.NewEncoder(w)
+	for _, v := range vehicles {
+		entry := PopulationEntry{
+			ID:        v.ID,
+			Path:      v.PathIDs,
+			Speed:     v.Speed,
+			Departure: v.Departure,
+			Class:     v.Class,
+		}
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePopulationFile writes vehicles to a population file
+func WritePopulationFile(path string, vehicles []*Vehicle) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := WritePopulation(w, vehicles); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// validatePath checks that every step of the path is an edge of the graph the class may drive on
+func (g *StreetGraph) validatePath(path []int, class *VehicleClass) error {
+	if len(path) < 2 {
+		return errors.New("path needs at least two vertices")
+	}
+	for i := 0; i < len(path)-1; i++ {
+		data, err := g.edgeData(path[i], path[i+1])
+		if err != nil {
+			return fmt.Errorf("no edge %d->%d", path[i], path[i+1])
+		}
+		if class != nil && !class.Allows(data.Highway) {
+			return fmt.Errorf("%s may not drive on %s edge %d->%d", class.Name, data.Highway, path[i], path[i+1])
+		}
+	}
+	return nil
+}
+
+// ReadPopulation reads vehicles written by WritePopulation and places them on the graph.
+// Every path is validated against the graph.
+func (g *StreetGraph) ReadPopulation(r io.Reader) ([]*Vehicle, error) {
+	vehicles := make([]*Vehicle, 0)
+	ids := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry PopulationEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if entry.ID == "" {
+			return nil, fmt.Errorf("line %d: missing id", line)
+		}
+		if ids[entry.ID] {
+			return nil, fmt.Errorf("line %d: duplicate id %s", line, entry.ID)
+		}
+		ids[entry.ID] = true
+		if entry.Speed <= 0 {
+			return nil, fmt.Errorf("line %d: speed must be positive", line)
+		}
+		var class *VehicleClass
+		if entry.Class != "" {
+			c, ok := DefaultVehicleClasses[entry.Class]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown vehicle class %q", line, entry.Class)
+			}
+			class = &c
+		}
+		if err := g.validatePath(entry.Path, class); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		vb := NewVehicleBuilder().WithID(entry.ID).WithGraph(g).WithPathIDs(entry.Path).WithDelta(0.0).WithIsParked(false)
+		vb = vb.WithSpeed(entry.Speed).WithLastID(entry.Path[0]).WithNextID(entry.Path[1]).WithDeparture(entry.Departure)
+		if class != nil {
+			vb = vb.WithClass(*class)
+		}
+		v, err := vb.Build()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		vehicles = append(vehicles, &v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vehicles, nil
+}
+
+// LoadPopulationFile reads a population file and places the vehicles on the graph
+func (g *StreetGraph) LoadPopulationFile(path string) ([]*Vehicle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return g.ReadPopulation(f)
+}
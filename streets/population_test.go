@@ -0,0 +1,52 @@
+package streets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopulation_RoundTrip(t *testing.T) {
+	g := lineGraph(t)
+	g.Random = NewRandomSource(1, 0)
+
+	vehicles := make([]*Vehicle, 0)
+	for i := 0; i < 5; i++ {
+		v, err := g.AddVehicle(5, 10)
+		assert.NoError(t, err)
+		v.SetDeparture(float64(i))
+		vehicles = append(vehicles, v)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WritePopulation(&buf, vehicles))
+	assert.Equal(t, 5, strings.Count(buf.String(), "\n"))
+
+	loaded, err := g.ReadPopulation(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(vehicles), len(loaded))
+	for i, v := range loaded {
+		assert.Equal(t, vehicles[i].ID, v.ID)
+		assert.Equal(t, vehicles[i].PathIDs, v.PathIDs)
+		assert.Equal(t, vehicles[i].Speed, v.Speed)
+		assert.Equal(t, vehicles[i].Departure, v.Departure)
+	}
+}
+
+func TestPopulation_ReadInvalid(t *testing.T) {
+	g := lineGraph(t)
+
+	for _, line := range []string{
+		`{"id":"a","path":[1,3],"speed":5}`,
+		`{"id":"a","path":[1],"speed":5}`,
+		`{"id":"a","path":[1,2],"speed":0}`,
+		`{"path":[1,2],"speed":5}`,
+		`{"id":"a","path":[1,2],"speed":5}` + "\n" + `{"id":"a","path":[2,3],"speed":5}`,
+		`not json`,
+	} {
+		_, err := g.ReadPopulation(strings.NewReader(line))
+		assert.Error(t, err, line)
+	}
+}
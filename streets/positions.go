@@ -0,0 +1,101 @@
+package streets
+
+import (
+	"sort"
+	"sync"
+)
+
+// VehiclePosition is the sampled position of a vehicle
+type VehiclePosition struct {
+	ID   string  `json:"id"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Time float64 `json:"t"`
+	// Rank is the rank driving the vehicle
+	Rank int `json:"rank"`
+}
+
+func MarshalPositions(positions []VehiclePosition) ([]byte, error) {
+	return encodePositions(positions), nil
+}
+
+func UnmarshalPositions(data []byte) ([]VehiclePosition, error) {
+	return decodePositions(data)
+}
+
+// PositionBoard holds the latest position of every driving vehicle. It is safe for concurrent use.
+type PositionBoard struct {
+	rank int
+
+	mu        sync.Mutex
+	positions map[string]VehiclePosition
+	// ranks are the positions sampled by other ranks
+	ranks map[int][]VehiclePosition
+}
+
+// NewPositionBoard returns an empty board for the vehicles driven on rank
+func NewPositionBoard(rank int) *PositionBoard {
+	return &PositionBoard{
+		rank:      rank,
+		positions: make(map[string]VehiclePosition),
+		ranks:     make(map[int][]VehiclePosition),
+	}
+}
+
+// Update sets the position of a vehicle
+func (b *PositionBoard) Update(v *Vehicle) {
+	p, err := v.Position()
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.positions[v.ID] = VehiclePosition{ID: v.ID, X: p.X, Y: p.Y, Time: v.Time, Rank: b.rank}
+}
+
+// Remove removes a vehicle that is parked or has left the rank
+func (b *PositionBoard) Remove(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.positions, id)
+}
+
+// SetRank replaces the positions sampled by another rank
+func (b *PositionBoard) SetRank(rank int, positions []VehiclePosition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ranks[rank] = positions
+}
+
+// Sample returns the positions of the vehicles driven on this rank
+func (b *PositionBoard) Sample() []VehiclePosition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	positions := make([]VehiclePosition, 0, len(b.positions))
+	for _, p := range b.positions {
+		positions = append(positions, p)
+	}
+	sortPositions(positions)
+	return positions
+}
+
+// Snapshot returns the positions of this rank and all positions sampled by other ranks
+func (b *PositionBoard) Snapshot() []VehiclePosition {
+	positions := b.Sample()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, rankPositions := range b.ranks {
+		positions = append(positions, rankPositions...)
+	}
+	sortPositions(positions)
+	return positions
+}
+
+func sortPositions(positions []VehiclePosition) {
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].ID < positions[j].ID
+	})
+}
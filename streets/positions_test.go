@@ -0,0 +1,69 @@
+package streets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositionBoard(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithID("a").WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+
+	board := NewPositionBoard(0)
+	v.Step()
+	board.Update(&v)
+	board.SetRank(2, []VehiclePosition{{ID: "b", X: 7, Y: 8, Rank: 2}})
+
+	sample := board.Sample()
+	assert.Len(t, sample, 1)
+	assert.Equal(t, "a", sample[0].ID)
+	assert.InDelta(t, 1.9, sample[0].X, 1e-9)
+	assert.Equal(t, v.Time, sample[0].Time)
+
+	snapshot := board.Snapshot()
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, "b", snapshot[1].ID)
+
+	board.Remove("a")
+	board.SetRank(2, nil)
+	assert.Len(t, board.Snapshot(), 0)
+}
+
+func TestMarshalPositions(t *testing.T) {
+	positions := []VehiclePosition{{ID: "a", X: 1, Y: 2, Time: 3, Rank: 1}}
+	b, err := MarshalPositions(positions)
+	assert.NoError(t, err)
+
+	u, err := UnmarshalPositions(b)
+	assert.NoError(t, err)
+	assert.Equal(t, positions, u)
+}
+
+func TestVizServer_Graph(t *testing.T) {
+	g := lineGraph(t)
+	s, err := NewVizServer(g, []*StreetGraph{g}, NewPositionBoard(0), 2)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graph", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var vg vizGraph
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vg))
+	assert.Len(t, vg.Vertices, 4)
+	assert.Equal(t, [][2]int{{1, 2}, {2, 3}, {3, 4}}, vg.Edges)
+	assert.Equal(t, []Bounds{{MinX: 1, MinY: 1, MaxX: 4, MaxY: 1}}, vg.Rects)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, rec.Body.String(), "EventSource")
+
+	_, err = NewVizServer(g, nil, NewPositionBoard(0), 0)
+	assert.Error(t, err)
+}
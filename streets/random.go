@@ -0,0 +1,47 @@
+package streets
+
+import (
+	"math/rand"
+	"time"
+
+	"pchpc_next/utils"
+)
+
+// RandomSource holds one random number stream per purpose, so that drawing more numbers
+// for one purpose does not change the numbers drawn for another
+type RandomSource struct {
+	// OD picks origins and destinations
+	OD *rand.Rand
+	// Speed picks vehicle speeds
+	Speed *rand.Rand
+	// Departure picks departure times
+	Departure *rand.Rand
+	// ID generates vehicle IDs
+	ID *rand.Rand
+	// Class picks vehicle classes
+	Class *rand.Rand
+}
+
+// NewRandomSource returns the random streams of a rank for a seed
+func NewRandomSource(seed int64, rank int) *RandomSource {
+	return &RandomSource{
+		OD:        utils.NewRandStream(seed, rank, "od"),
+		Speed:     utils.NewRandStream(seed, rank, "speed"),
+		Departure: utils.NewRandStream(seed, rank, "departure"),
+		ID:        utils.NewRandStream(seed, rank, "id"),
+		Class:     utils.NewRandStream(seed, rank, "class"),
+	}
+}
+
+// NewSeed returns a seed for runs that did not ask for one
+func NewSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// random returns the random streams of the graph, seeding them from the time if none are set
+func (g *StreetGraph) random() *RandomSource {
+	if g.Random == nil {
+		g.Random = NewRandomSource(NewSeed(), g.ID)
+	}
+	return g.Random
+}
@@ -0,0 +1,52 @@
+package streets
+
+// Route is the path of a vehicle with a cursor at the vertex it has passed last. The cursor only moves
+// forward, so a route that visits a vertex twice is followed in order and every query takes constant time.
+type Route struct {
+	PathIDs   []int
+	PathIndex int // route cursor, the index of PrevID in PathIDs
+}
+
+// NewRoute returns a route along path with the cursor at its first vertex
+func NewRoute(path []int) Route {
+	return Route{PathIDs: path}
+}
+
+// Current returns the vertex at the cursor, 0 for an empty route
+func (r *Route) Current() int {
+	if r.PathIndex < 0 || r.PathIndex >= len(r.PathIDs) {
+		return 0
+	}
+	return r.PathIDs[r.PathIndex]
+}
+
+// Peek returns the vertex after the cursor, false at the destination
+func (r *Route) Peek() (int, bool) {
+	next := r.PathIndex + 1
+	if next <= 0 || next >= len(r.PathIDs) {
+		return 0, false
+	}
+	return r.PathIDs[next], true
+}
+
+// Advance moves the cursor to the vertex after it and returns that vertex, false at the destination
+func (r *Route) Advance() (int, bool) {
+	next, ok := r.Peek()
+	if ok {
+		r.PathIndex++
+	}
+	return next, ok
+}
+
+// AtDestination reports whether the cursor is at the last vertex of the route
+func (r *Route) AtDestination() bool {
+	return r.PathIndex >= len(r.PathIDs)-1
+}
+
+// Remaining returns the path from the cursor on
+func (r *Route) Remaining() []int {
+	if r.PathIndex <= 0 || r.PathIndex >= len(r.PathIDs) {
+		return r.PathIDs
+	}
+	return r.PathIDs[r.PathIndex:]
+}
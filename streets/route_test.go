@@ -0,0 +1,78 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	// the loop passes vertex 2 twice, the cursor follows the route in order
+	r := NewRoute([]int{1, 2, 3, 2, 4})
+	assert.Equal(t, 1, r.Current())
+	next, ok := r.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, 2, next)
+
+	visited := []int{r.Current()}
+	for !r.AtDestination() {
+		next, ok := r.Advance()
+		assert.True(t, ok)
+		assert.Equal(t, next, r.Current())
+		visited = append(visited, next)
+		assert.Equal(t, visited[len(visited)-1:], r.Remaining()[:1])
+	}
+	assert.Equal(t, []int{1, 2, 3, 2, 4}, visited)
+	assert.Equal(t, 4, r.PathIndex)
+	assert.Equal(t, []int{4}, r.Remaining())
+
+	_, ok = r.Peek()
+	assert.False(t, ok)
+	_, ok = r.Advance()
+	assert.False(t, ok)
+	assert.Equal(t, 4, r.PathIndex)
+
+	var empty Route
+	assert.True(t, empty.AtDestination())
+	assert.Equal(t, 0, empty.Current())
+	_, ok = empty.Advance()
+	assert.False(t, ok)
+}
+
+func TestVehicle_Handoff(t *testing.T) {
+	// vertex 5 is on another leaf
+	g := lineGraph(t)
+	v, err := g.newVehicleOnPath([]int{1, 2, 3, 5}, 3, 0, nil)
+	assert.NoError(t, err)
+	assert.False(t, v.NextIsForeign())
+
+	assert.NoError(t, v.Step())
+	assert.False(t, v.MarkedForDeletion)
+	assert.NoError(t, v.Step())
+
+	// the vehicle is handed over on the edge between the leaves
+	assert.True(t, v.MarkedForDeletion)
+	assert.True(t, v.NextIsForeign())
+	assert.Equal(t, 3, v.PrevID)
+	assert.Equal(t, 5, v.NextID)
+	assert.Equal(t, []int{3, 5}, v.Remaining())
+	assert.Equal(t, 2, v.Edges)
+	assert.Equal(t, 20., v.Distance)
+}
+
+func TestVehicle_AdvanceToNext(t *testing.T) {
+	g := lineGraph(t)
+	v, err := g.newVehicleOnPath([]int{1, 2, 3}, 3, 0, nil)
+	assert.NoError(t, err)
+
+	v.AdvanceToNext()
+	assert.Equal(t, 2, v.PrevID)
+	assert.Equal(t, 3, v.NextID)
+	assert.False(t, v.IsParked)
+
+	v.AdvanceToNext()
+	assert.Equal(t, 3, v.PrevID)
+	assert.Equal(t, 0, v.NextID)
+	assert.True(t, v.IsParked)
+	assert.True(t, v.AtDestination())
+}
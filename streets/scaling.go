@@ -0,0 +1,118 @@
+package streets
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// kinds of scaling series
+const (
+	// StrongScaling keeps the number of vehicles fixed
+	StrongScaling = "strong"
+	// WeakScaling grows the number of vehicles with the workers
+	WeakScaling = "weak"
+)
+
+// ScalingRow compares a run to the run with the fewest workers of its series
+type ScalingRow struct {
+	Kind               string
+	WorldSize          int
+	Workers            int
+	Vehicles           int
+	Wall               float64
+	Speedup            float64
+	Efficiency         float64
+	CommunicationShare float64
+	// Phases is the time of every phase on its slowest rank
+	Phases map[string]float64
+}
+
+// Workers is the number of ranks driving vehicles, a world of one rank runs without MPI
+func Workers(worldSize int) int {
+	if worldSize <= 1 {
+		return 1
+	}
+	return worldSize - 1
+}
+
+// ScalingTable computes speedup and efficiency of a series of runs. In a weak series the speedup is
+// scaled by the growth of the work, so that both kinds are 1 per worker for perfect scaling.
+func ScalingTable(kind string, reports []PhaseReport) []ScalingRow {
+	if len(reports) == 0 {
+		return nil
+	}
+	sorted := make([]PhaseReport, len(reports))
+	copy(sorted, reports)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].WorldSize < sorted[j].WorldSize
+	})
+	base := sorted[0]
+	baseWorkers := float64(Workers(base.WorldSize))
+
+	rows := make([]ScalingRow, len(sorted))
+	for i, r := range sorted {
+		row := ScalingRow{
+			Kind:               kind,
+			WorldSize:          r.WorldSize,
+			Workers:            Workers(r.WorldSize),
+			Vehicles:           r.Vehicles,
+			Wall:               r.Wall(),
+			CommunicationShare: r.CommunicationShare(),
+			Phases:             make(map[string]float64),
+		}
+		for _, phase := range Phases {
+			row.Phases[phase] = r.Phase(phase)
+		}
+		if row.Wall > 0 {
+			ratio := base.Wall() / row.Wall
+			growth := float64(row.Workers) / baseWorkers
+			if kind == WeakScaling {
+				row.Speedup = ratio * growth
+				row.Efficiency = ratio
+			} else {
+				row.Speedup = ratio
+				row.Efficiency = ratio / growth
+			}
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// WriteScalingFile writes scaling rows as CSV with a column per phase
+func WriteScalingFile(path string, rows []ScalingRow) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	header := []string{"kind", "world_size", "workers", "vehicles", "wall", "speedup", "efficiency", "communication_share"}
+	_ = w.Write(append(header, Phases...))
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 4, 64)
+	}
+	for _, r := range rows {
+		row := []string{
+			r.Kind,
+			strconv.Itoa(r.WorldSize),
+			strconv.Itoa(r.Workers),
+			strconv.Itoa(r.Vehicles),
+			formatFloat(r.Wall),
+			formatFloat(r.Speedup),
+			formatFloat(r.Efficiency),
+			formatFloat(r.CommunicationShare),
+		}
+		for _, phase := range Phases {
+			row = append(row, formatFloat(r.Phases[phase]))
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,75 @@
+package streets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func report(worldSize, vehicles int, totals ...float64) PhaseReport {
+	ranks := make([]RankPhases, len(totals))
+	for i, total := range totals {
+		ranks[i] = RankPhases{Rank: len(totals) - 1 - i, Total: total, Communication: total / 2, Phases: map[string]float64{PhaseSimulation: total - 1}}
+	}
+	return NewPhaseReport("mpi", worldSize, vehicles, ranks)
+}
+
+func TestScalingTable(t *testing.T) {
+	strong := ScalingTable(StrongScaling, []PhaseReport{
+		report(5, 100, 25, 25, 20, 20, 20),
+		report(1, 100, 80),
+		report(3, 100, 40, 30, 30),
+	})
+	assert.Equal(t, 3, len(strong))
+	assert.Equal(t, []int{1, 2, 4}, []int{strong[0].Workers, strong[1].Workers, strong[2].Workers})
+	assert.Equal(t, 1., strong[0].Speedup)
+	assert.Equal(t, 2., strong[1].Speedup)
+	assert.Equal(t, 1., strong[1].Efficiency)
+	assert.Equal(t, 3.2, strong[2].Speedup)
+	assert.Equal(t, 0.8, strong[2].Efficiency)
+	assert.Equal(t, 39., strong[1].Phases[PhaseSimulation])
+	assert.Equal(t, 0.5, strong[1].CommunicationShare)
+
+	weak := ScalingTable(WeakScaling, []PhaseReport{report(1, 100, 10), report(3, 200, 20, 20, 20)})
+	assert.Equal(t, 0.5, weak[1].Efficiency)
+	assert.Equal(t, 1., weak[1].Speedup)
+	assert.Nil(t, ScalingTable(WeakScaling, nil))
+
+	path := filepath.Join(t.TempDir(), "scaling.csv")
+	assert.NoError(t, WriteScalingFile(path, append(strong, weak...)))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Equal(t, 6, len(lines))
+	assert.True(t, strings.HasSuffix(lines[0], strings.Join(Phases, ",")))
+}
+
+func TestPhaseTimer(t *testing.T) {
+	pt := NewPhaseTimer()
+	pt.Start(PhaseGraphLoad)
+	time.Sleep(time.Millisecond)
+	pt.Stop(PhaseGraphLoad)
+	pt.Stop(PhaseEmission)
+	pt.communicated(time.Second)
+
+	rp := pt.Rank(2)
+	assert.Equal(t, 2, rp.Rank)
+	assert.Greater(t, rp.Phases[PhaseGraphLoad], 0.)
+	assert.NotContains(t, rp.Phases, PhaseEmission)
+	assert.Equal(t, 1., rp.Communication)
+	assert.GreaterOrEqual(t, rp.Total, rp.Phases[PhaseGraphLoad])
+
+	b, err := rp.Marshal()
+	assert.NoError(t, err)
+	decoded, err := UnmarshalRankPhases(b)
+	assert.NoError(t, err)
+	assert.Equal(t, rp, decoded)
+
+	var nilTimer *PhaseTimer
+	nilTimer.Start(PhaseShutdown)
+	assert.Equal(t, 0., nilTimer.Rank(0).Total)
+}
@@ -0,0 +1,297 @@
+package streets
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StepFunc advances a vehicle by one step and reports whether it is done
+type StepFunc func() bool
+
+// TimeFunc returns the simulated time a vehicle has reached
+type TimeFunc func() float64
+
+// task is a vehicle in the scheduler
+type task struct {
+	id   string
+	step StepFunc
+	at   TimeFunc // nil for vehicles that are not paced
+	t    float64  // simulated time of the vehicle when it was queued
+	seq  uint64   // queue order of vehicles at the same time
+}
+
+// taskQueue orders tasks by time and then by the order they were queued in
+type taskQueue []*task
+
+func (q taskQueue) Len() int { return len(q) }
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].t != q[j].t {
+		return q[i].t < q[j].t
+	}
+	return q[i].seq < q[j].seq
+}
+func (q taskQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*task)) }
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return t
+}
+
+// Scheduler steps vehicles on a fixed pool of workers. A worker takes the next vehicle from the run
+// queue, steps it once and puts it back at the end of the queue until it is done, so that any number
+// of vehicles share the same few goroutines. Paced vehicles wait outside the queue until the clock
+// reaches their time, so that no worker is blocked by the clock. A virtual clock is advanced by the
+// scheduler itself to the time of the slowest vehicle, vehicles further ahead than the lookahead wait.
+type Scheduler struct {
+	mu    sync.Mutex
+	ready *sync.Cond // signalled when a vehicle is queued or the scheduler is closed
+	done  *sync.Cond // signalled when the last vehicle is done
+	queue taskQueue
+	seq   uint64
+	// active are the submitted vehicles that are not done yet
+	active int
+	// waiting are the paced vehicles waiting for a realtime clock
+	waiting map[*task]*time.Timer
+	// held are the paced vehicles ahead of the lookahead of a virtual clock
+	held taskQueue
+	// running are the times of the paced vehicles being stepped
+	running   map[*task]float64
+	lookahead float64
+	closed    bool
+	clock     *Clock
+
+	started time.Time
+	stopped time.Time
+	busy    []atomic.Int64
+	workers sync.WaitGroup
+}
+
+// NewScheduler starts a pool of workers, GOMAXPROCS if workers is not positive
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &Scheduler{started: time.Now(), busy: make([]atomic.Int64, workers), waiting: make(map[*task]*time.Timer)}
+	s.ready = sync.NewCond(&s.mu)
+	s.done = sync.NewCond(&s.mu)
+	s.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work(i)
+	}
+	return s
+}
+
+// Pace makes vehicles submitted with SubmitAt wait for clock to reach their time before their next step.
+// A virtual clock follows the slowest vehicle and vehicles step at most lookahead simulated seconds ahead of it.
+func (s *Scheduler) Pace(clock *Clock, lookahead float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+	s.lookahead = lookahead
+	s.running = make(map[*task]float64)
+}
+
+// Submit adds a vehicle to the end of the run queue
+func (s *Scheduler) Submit(id string, step StepFunc) {
+	s.SubmitAt(id, step, nil)
+}
+
+// SubmitAt adds a vehicle that is paced by the time at returns
+func (s *Scheduler) SubmitAt(id string, step StepFunc, at TimeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active++
+	s.requeue(&task{id: id, step: step, at: at})
+}
+
+// requeue queues a vehicle again, a paced vehicle waits for the clock first
+func (s *Scheduler) requeue(t *task) {
+	if t.at == nil || s.clock == nil {
+		s.push(t)
+		return
+	}
+	t.t = t.at()
+	if !s.clock.IsRealtime() {
+		heap.Push(&s.held, t)
+		s.release()
+		return
+	}
+	wait := s.clock.WallUntil(t.t)
+	if wait <= 0 {
+		s.push(t)
+		return
+	}
+	s.waiting[t] = time.AfterFunc(wait, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.waiting[t]; !ok {
+			// taken by Close
+			return
+		}
+		delete(s.waiting, t)
+		s.push(t)
+	})
+}
+
+// release advances a virtual clock to the slowest vehicle and queues the held vehicles within the lookahead
+func (s *Scheduler) release() {
+	if len(s.held) == 0 {
+		return
+	}
+	slowest := s.held[0].t
+	if len(s.queue) > 0 && s.queue[0].t < slowest {
+		slowest = s.queue[0].t
+	}
+	for _, t := range s.running {
+		if t < slowest {
+			slowest = t
+		}
+	}
+	s.clock.WaitUntil(slowest)
+	for len(s.held) > 0 && s.held[0].t <= slowest+s.lookahead {
+		s.push(heap.Pop(&s.held).(*task))
+	}
+}
+
+func (s *Scheduler) push(t *task) {
+	if t.at == nil || s.clock == nil {
+		// vehicles that are not paced keep the order they were queued in
+		t.t = 0
+	}
+	s.seq++
+	t.seq = s.seq
+	heap.Push(&s.queue, t)
+	s.ready.Signal()
+}
+
+// pop takes the next vehicle, it waits while the queue is empty and returns nil once the scheduler is closed
+func (s *Scheduler) pop() *task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.ready.Wait()
+	}
+	if s.closed {
+		return nil
+	}
+	t := heap.Pop(&s.queue).(*task)
+	if s.running != nil && t.at != nil {
+		s.running[t] = t.t
+	}
+	return t
+}
+
+func (s *Scheduler) work(worker int) {
+	defer s.workers.Done()
+	for {
+		t := s.pop()
+		if t == nil {
+			return
+		}
+		start := time.Now()
+		finished := t.step()
+		s.busy[worker].Add(int64(time.Since(start)))
+
+		s.mu.Lock()
+		if s.running != nil {
+			delete(s.running, t)
+		}
+		if finished {
+			s.active--
+			if s.active == 0 {
+				s.done.Broadcast()
+			}
+			s.release()
+		} else {
+			s.requeue(t)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Queued returns the number of vehicles waiting in the run queue
+func (s *Scheduler) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Waiting returns the number of paced vehicles waiting for the clock
+func (s *Scheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting) + len(s.held)
+}
+
+// Wait blocks until every submitted vehicle is done
+func (s *Scheduler) Wait() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.active > 0 {
+		s.done.Wait()
+	}
+}
+
+// Close stops the workers after their current step and waits for them. It returns the IDs of the vehicles
+// that are not done, queued, held by the lookahead or waiting for the clock, they are not stepped anymore.
+func (s *Scheduler) Close() []string {
+	s.mu.Lock()
+	s.closed = true
+	s.ready.Broadcast()
+	s.mu.Unlock()
+	s.workers.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = time.Now()
+	unfinished := make([]string, 0, len(s.queue)+len(s.held)+len(s.waiting))
+	for _, t := range s.queue {
+		unfinished = append(unfinished, t.id)
+	}
+	for _, t := range s.held {
+		unfinished = append(unfinished, t.id)
+	}
+	for t, timer := range s.waiting {
+		timer.Stop()
+		unfinished = append(unfinished, t.id)
+	}
+	s.queue, s.held = nil, nil
+	s.waiting = make(map[*task]*time.Timer)
+	s.active -= len(unfinished)
+	if s.active == 0 {
+		s.done.Broadcast()
+	}
+	return unfinished
+}
+
+// Workers returns the size of the pool
+func (s *Scheduler) Workers() int {
+	return len(s.busy)
+}
+
+// Utilisation returns the share of time every worker spent stepping vehicles since the pool was started
+// until it was closed
+func (s *Scheduler) Utilisation() []float64 {
+	s.mu.Lock()
+	end := s.stopped
+	s.mu.Unlock()
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(s.started)
+
+	utilisation := make([]float64, len(s.busy))
+	if elapsed <= 0 {
+		return utilisation
+	}
+	for i := range s.busy {
+		utilisation[i] = float64(s.busy[i].Load()) / float64(elapsed)
+	}
+	return utilisation
+}
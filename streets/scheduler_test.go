@@ -0,0 +1,163 @@
+package streets
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(4)
+	assert.Equal(t, 4, s.Workers())
+
+	// far more vehicles than workers, each needs several steps
+	var finished atomic.Int64
+	steps := make([]int, 1000)
+	for i := range steps {
+		i := i
+		s.Submit(strconv.Itoa(i), func() bool {
+			// a vehicle is never stepped by two workers at once, the race detector would report it
+			steps[i]++
+			if steps[i] < 5 {
+				return false
+			}
+			finished.Add(1)
+			return true
+		})
+	}
+	s.Wait()
+	assert.Equal(t, int64(1000), finished.Load())
+	assert.Equal(t, 0, s.Queued())
+
+	// vehicles can still be submitted after waiting
+	s.Submit("late", func() bool {
+		time.Sleep(10 * time.Millisecond)
+		return true
+	})
+	s.Wait()
+	assert.Empty(t, s.Close())
+
+	for i, n := range steps {
+		if n != 5 {
+			t.Errorf("vehicle %d was stepped %d times", i, n)
+		}
+	}
+	utilisation := s.Utilisation()
+	assert.Equal(t, 4, len(utilisation))
+	busy := 0.
+	for _, u := range utilisation {
+		assert.GreaterOrEqual(t, u, 0.)
+		assert.LessOrEqual(t, u, 1.)
+		busy += u
+	}
+	assert.Greater(t, busy, 0.)
+}
+
+func TestScheduler_DefaultWorkers(t *testing.T) {
+	s := NewScheduler(0)
+	assert.Equal(t, runtime.GOMAXPROCS(0), s.Workers())
+	s.Wait()
+	s.Close()
+}
+
+func TestScheduler_Pace(t *testing.T) {
+	s := NewScheduler(2)
+	// one simulated second takes 10ms
+	s.Pace(NewClock(100), 0)
+
+	start := time.Now()
+	times := make([]float64, 4)
+	for i := range times {
+		i := i
+		s.SubmitAt(strconv.Itoa(i), func() bool {
+			times[i]++
+			return times[i] >= 5
+		}, func() float64 {
+			return times[i]
+		})
+	}
+	assert.Eventually(t, func() bool { return s.Waiting() > 0 }, time.Second, time.Millisecond)
+	s.Wait()
+	elapsed := time.Since(start)
+	s.Close()
+
+	// the vehicles waited for the clock to reach 4s, but the workers were idle meanwhile
+	assert.GreaterOrEqual(t, elapsed, 35*time.Millisecond)
+	for i, u := range s.Utilisation() {
+		if u > 0.5 {
+			t.Errorf("worker %d was busy %.2f of the time while the vehicles waited for the clock", i, u)
+		}
+	}
+	assert.Equal(t, 0, s.Waiting())
+}
+
+func TestScheduler_PaceVirtual(t *testing.T) {
+	s := NewScheduler(4)
+	clock := NewClock(0)
+	s.Pace(clock, 10)
+
+	var mu sync.Mutex
+	// every vehicle steps 5 simulated seconds, the departures are far apart
+	order := make([]float64, 0)
+	times := []float64{300, 0, 100, 200}
+	for i := range times {
+		i := i
+		s.SubmitAt(strconv.Itoa(i), func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, times[i])
+			times[i] += 5
+			return int(times[i])%100 == 50
+		}, func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return times[i]
+		})
+	}
+	s.Wait()
+	s.Close()
+
+	// the vehicles did not overlap, a later departure only stepped once the earlier vehicles were done
+	for i := 1; i < len(order); i++ {
+		if order[i] < order[i-1] {
+			t.Errorf("step at %.0f came after a step at %.0f", order[i], order[i-1])
+		}
+	}
+	assert.Equal(t, 40, len(order))
+	assert.Equal(t, 345., clock.Now())
+	assert.Equal(t, 0, s.Waiting())
+}
+
+func TestScheduler_CloseReturnsUnfinished(t *testing.T) {
+	s := NewScheduler(2)
+	s.Pace(NewClock(0), 0)
+	started := make(chan struct{})
+	var once sync.Once
+	// a stays at 0 and keeps b at 100 beyond the lookahead
+	s.SubmitAt("a", func() bool {
+		once.Do(func() { close(started) })
+		return false
+	}, func() float64 { return 0 })
+	s.SubmitAt("b", func() bool { return true }, func() float64 { return 100 })
+	<-started
+	assert.Equal(t, 1, s.Waiting())
+	assert.ElementsMatch(t, []string{"a", "b"}, s.Close())
+	assert.Equal(t, 0, s.Waiting())
+	// nothing is left to wait for
+	s.Wait()
+
+	// a vehicle waiting for a realtime clock is returned and not queued anymore
+	s = NewScheduler(1)
+	s.Pace(NewClock(1), 0)
+	s.SubmitAt("c", func() bool { return true }, func() float64 { return 3600 })
+	assert.Equal(t, 1, s.Waiting())
+	assert.Equal(t, []string{"c"}, s.Close())
+	assert.Equal(t, 0, s.Waiting())
+	assert.Equal(t, 0, s.Queued())
+	s.Wait()
+}
@@ -0,0 +1,109 @@
+package streets
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// queueItem is a vertex in the priority queue of ShortestPath
+type queueItem struct {
+	vertex int
+	hops   int
+}
+
+// vertexQueue orders vertices by hops and breaks ties by vertex ID, so that paths are deterministic
+type vertexQueue []queueItem
+
+func (q vertexQueue) Len() int { return len(q) }
+func (q vertexQueue) Less(i, j int) bool {
+	if q[i].hops != q[j].hops {
+		return q[i].hops < q[j].hops
+	}
+	return q[i].vertex < q[j].vertex
+}
+func (q vertexQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *vertexQueue) Push(x interface{}) { *q = append(*q, x.(queueItem)) }
+func (q *vertexQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// successors returns the sorted successors of every vertex
+func (g *StreetGraph) successors() (map[int][]int, error) {
+	if g.adjacency != nil {
+		return g.adjacency, nil
+	}
+
+	adjacencyMap, err := g.Graph.AdjacencyMap()
+	if err != nil {
+		return nil, err
+	}
+
+	adjacency := make(map[int][]int, len(adjacencyMap))
+	for vertex, targets := range adjacencyMap {
+		next := make([]int, 0, len(targets))
+		for target := range targets {
+			next = append(next, target)
+		}
+		sort.Ints(next)
+		adjacency[vertex] = next
+	}
+
+	g.adjacency = adjacency
+	return g.adjacency, nil
+}
+
+// ShortestPath returns the path with the fewest edges between src and dest.
+// Unlike graph.ShortestPath the result does not depend on map iteration order.
+func (g *StreetGraph) ShortestPath(src, dest int) ([]int, error) {
+	return g.shortestPath(src, dest, nil)
+}
+
+// shortestPath is ShortestPath on the edges allow accepts, all edges for a nil allow
+func (g *StreetGraph) shortestPath(src, dest int, allow func(from, to int) bool) ([]int, error) {
+	adjacency, err := g.successors()
+	if err != nil {
+		return nil, err
+	}
+
+	hops := map[int]int{src: 0}
+	predecessors := make(map[int]int)
+	queue := &vertexQueue{{vertex: src}}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(queueItem)
+		if item.hops > hops[item.vertex] {
+			continue
+		}
+		if item.vertex == dest {
+			break
+		}
+
+		for _, next := range adjacency[item.vertex] {
+			if allow != nil && !allow(item.vertex, next) {
+				continue
+			}
+			h, seen := hops[next]
+			if seen && h <= item.hops+1 {
+				continue
+			}
+			hops[next] = item.hops + 1
+			predecessors[next] = item.vertex
+			heap.Push(queue, queueItem{vertex: next, hops: item.hops + 1})
+		}
+	}
+
+	if _, ok := hops[dest]; !ok {
+		return nil, fmt.Errorf("%w: %d -> %d", ErrUnreachable, src, dest)
+	}
+
+	path := []int{dest}
+	for current := dest; current != src; {
+		current = predecessors[current]
+		path = append([]int{current}, path...)
+	}
+	return path, nil
+}
@@ -0,0 +1,56 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/dominikbraun/graph"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreetGraph_ShortestPath(t *testing.T) {
+	g := lineGraph(t)
+
+	path, err := g.ShortestPath(1, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, path)
+
+	_, err = g.ShortestPath(4, 1)
+	assert.ErrorIs(t, err, ErrUnreachable)
+	assert.ErrorIs(t, err, graph.ErrTargetNotReachable)
+}
+
+func TestStreetGraph_ShortestPathTieBreak(t *testing.T) {
+	// two routes of equal length, 1->2->4 and 1->3->4
+	vertices := []JVertex{{ID: 1, X: 1, Y: 1}, {ID: 2, X: 2, Y: 2}, {ID: 3, X: 2, Y: 1}, {ID: 4, X: 3, Y: 1}}
+	edges := []JEdge{{From: 1, To: 3}, {From: 1, To: 2}, {From: 3, To: 4}, {From: 2, To: 4}}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		path, err := g.ShortestPath(1, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 4}, path)
+	}
+}
+
+func TestStreetGraph_AddVehicleSeeded(t *testing.T) {
+	generate := func(seed int64) []Vehicle {
+		g, err := NewGraphBuilder().FromJsonFile("../assets/out.json").SetTopRightBottomLeftVertices().
+			NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+		assert.NoError(t, err)
+		g.Random = NewRandomSource(seed, 0)
+
+		vehicles := make([]Vehicle, 0)
+		for i := 0; i < 10; i++ {
+			v, err := g.AddVehicle(5, 10)
+			assert.NoError(t, err)
+			v.StreetGraph = nil
+			vehicles = append(vehicles, *v)
+		}
+		return vehicles
+	}
+
+	assert.Equal(t, generate(42), generate(42))
+	assert.NotEqual(t, generate(42), generate(43))
+}
@@ -0,0 +1,368 @@
+package streets
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// defaultTransitSpeed is the planned speed of a line without one, in meters per second
+const defaultTransitSpeed = 8.3
+
+// OnTimeEarly and OnTimeLate bound the delay in seconds of an arrival that counts as on time
+const (
+	OnTimeEarly = -60.
+	OnTimeLate  = 300.
+)
+
+// Transit is a set of public transport lines
+type Transit struct {
+	Lines []TransitLine `json:"lines"`
+}
+
+// TransitLine is a bus or tram line driving a fixed route
+type TransitLine struct {
+	ID string `json:"id"`
+	// Mode is the vehicle class of the line, bus or tram
+	Mode string `json:"mode"`
+	// Route are the vertices of the line from the first to the last stop, every step must be an edge
+	Route []int         `json:"route"`
+	Stops []TransitStop `json:"stops"`
+	// Speed is the planned speed in meters per second the timetable is based on
+	Speed float64 `json:"speed"`
+
+	// Departures is the timetable of the first stop in simulated seconds. If it is empty,
+	// a vehicle departs every Headway seconds from First to Last.
+	Departures []float64 `json:"departures"`
+	Headway    float64   `json:"headway"`
+	First      float64   `json:"first"`
+	Last       float64   `json:"last"`
+}
+
+// TransitStop is a stop of a line
+type TransitStop struct {
+	Vertex int `json:"vertex"`
+	// Dwell is the time in seconds the vehicle waits at the stop
+	Dwell float64 `json:"dwell"`
+}
+
+// StopTime is the scheduled and actual arrival of a transit vehicle at a stop
+type StopTime struct {
+	Vertex    int     `json:"vertex"`
+	Dwell     float64 `json:"dwell"`
+	Scheduled float64 `json:"scheduled"`
+	Arrival   float64 `json:"arrival"`
+	Reached   bool    `json:"reached"`
+}
+
+// Delay is the difference of the actual to the scheduled arrival, negative if the vehicle is early
+func (s *StopTime) Delay() float64 {
+	return s.Arrival - s.Scheduled
+}
+
+func UnmarshalTransitJSON(data []byte) (Transit, error) {
+	var r Transit
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+func (r *Transit) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LoadTransitFile reads transit lines from a JSON file
+func LoadTransitFile(path string) (Transit, error) {
+	jBytes, err := os.ReadFile(path)
+	if err != nil {
+		return Transit{}, err
+	}
+	return UnmarshalTransitJSON(jBytes)
+}
+
+// class returns the vehicle class of the line
+func (l *TransitLine) class() (VehicleClass, error) {
+	mode := l.Mode
+	if mode == "" {
+		mode = "bus"
+	}
+	if mode != "bus" && mode != "tram" {
+		return VehicleClass{}, fmt.Errorf("unknown mode %q", l.Mode)
+	}
+	return DefaultVehicleClasses[mode], nil
+}
+
+// departures returns the departure times of the line at its first stop
+func (l *TransitLine) departures() ([]float64, error) {
+	if len(l.Departures) > 0 {
+		departures := append([]float64{}, l.Departures...)
+		sort.Float64s(departures)
+		return departures, nil
+	}
+	if l.Headway <= 0 {
+		return nil, errors.New("line needs departures or a positive headway")
+	}
+	if l.Last < l.First {
+		return nil, errors.New("last departure is before the first")
+	}
+	departures := make([]float64, 0)
+	for t := l.First; t <= l.Last; t += l.Headway {
+		departures = append(departures, t)
+	}
+	return departures, nil
+}
+
+// validate checks that the route can be driven by the line and that the stops are on it in order
+func (l *TransitLine) validate(g *StreetGraph) error {
+	if l.ID == "" {
+		return errors.New("line needs an id")
+	}
+	class, err := l.class()
+	if err != nil {
+		return err
+	}
+	if err := g.validatePath(l.Route, &class); err != nil {
+		return err
+	}
+	// vehicles find their next vertex by searching the path, so a route may not visit a vertex twice
+	seen := make(map[int]bool)
+	for _, v := range l.Route {
+		if seen[v] {
+			return fmt.Errorf("route visits vertex %d twice", v)
+		}
+		seen[v] = true
+	}
+
+	i := 0
+	for _, stop := range l.Stops {
+		if stop.Dwell < 0 {
+			return fmt.Errorf("stop %d: negative dwell", stop.Vertex)
+		}
+		for i < len(l.Route) && l.Route[i] != stop.Vertex {
+			i++
+		}
+		if i == len(l.Route) {
+			return fmt.Errorf("stop %d is not on the route or out of order", stop.Vertex)
+		}
+	}
+	return nil
+}
+
+// schedule returns the stop times of a trip departing at departure. The planned arrival at a stop is
+// the time to drive there at the planned speed plus the dwell at the stops before it.
+func (l *TransitLine) schedule(g *StreetGraph, departure, speed float64) ([]StopTime, error) {
+	stops := make([]StopTime, 0, len(l.Stops))
+	offset := 0.0
+	s := 0
+	for i, vertex := range l.Route {
+		if i > 0 {
+			data, err := g.edgeData(l.Route[i-1], vertex)
+			if err != nil {
+				return nil, err
+			}
+			offset += data.Length / speed
+		}
+		if s < len(l.Stops) && l.Stops[s].Vertex == vertex {
+			stops = append(stops, StopTime{Vertex: vertex, Dwell: l.Stops[s].Dwell, Scheduled: departure + offset})
+			offset += l.Stops[s].Dwell
+			s++
+		}
+	}
+	return stops, nil
+}
+
+// AddTransitVehicles creates a vehicle for every departure of every line. Vehicles are named after
+// their line and the number of the trip, e.g. 12-0, 12-1, ...
+func (g *StreetGraph) AddTransitVehicles(t Transit) ([]*Vehicle, error) {
+	vehicles := make([]*Vehicle, 0)
+	ids := make(map[string]bool)
+	for _, line := range t.Lines {
+		if ids[line.ID] {
+			return nil, fmt.Errorf("line %s: duplicate id", line.ID)
+		}
+		ids[line.ID] = true
+		if err := line.validate(g); err != nil {
+			return nil, fmt.Errorf("line %s: %w", line.ID, err)
+		}
+		departures, err := line.departures()
+		if err != nil {
+			return nil, fmt.Errorf("line %s: %w", line.ID, err)
+		}
+
+		class, _ := line.class()
+		speed := line.Speed
+		if speed <= 0 {
+			speed = defaultTransitSpeed
+		}
+		speed = math.Min(speed, class.MaxSpeed)
+
+		for k, departure := range departures {
+			v, err := g.newVehicleOnPath(line.Route, speed, departure, &class)
+			if err != nil {
+				return nil, fmt.Errorf("line %s: %w", line.ID, err)
+			}
+			v.ID = fmt.Sprintf("%s-%d", line.ID, k)
+			v.Line = line.ID
+			v.Stops, err = line.schedule(g, departure, speed)
+			if err != nil {
+				return nil, fmt.Errorf("line %s: %w", line.ID, err)
+			}
+			// the departure from the first stop is the start of the trip
+			v.ArriveAt(v.PrevID)
+			vehicles = append(vehicles, v)
+		}
+	}
+	return vehicles, nil
+}
+
+// ArriveAt records the arrival at a vertex. If it is the next stop of a transit vehicle,
+// the vehicle waits there for the dwell time unless it is the end of its trip.
+func (v *Vehicle) ArriveAt(vertex int) {
+	if v.NextStop >= len(v.Stops) || v.Stops[v.NextStop].Vertex != vertex {
+		return
+	}
+	stop := &v.Stops[v.NextStop]
+	stop.Arrival = v.Time
+	stop.Reached = true
+	v.NextStop++
+	if vertex != v.Destination {
+		v.Time += stop.Dwell
+	}
+}
+
+// LineReport is the punctuality and run time summary of a transit line
+type LineReport struct {
+	Line string
+	Runs int
+	// run times from the departure at the first stop to the arrival at the last vertex
+	MeanRunTime, MinRunTime, MaxRunTime float64
+	// Arrivals counts the arrivals at stops after the first, the delays are taken over them
+	Arrivals             int
+	MeanDelay, MaxDelay  float64
+	OnTime               int
+	totalRun, totalDelay float64
+}
+
+// OnTimeShare is the share of arrivals with a delay between OnTimeEarly and OnTimeLate
+func (r *LineReport) OnTimeShare() float64 {
+	if r.Arrivals == 0 {
+		return 0
+	}
+	return float64(r.OnTime) / float64(r.Arrivals)
+}
+
+// TransitReport collects the trip records of transit vehicles per line. It is safe for concurrent use
+// and a nil report ignores all records.
+type TransitReport struct {
+	mu    sync.Mutex
+	lines map[string]*LineReport
+}
+
+func NewTransitReport() *TransitReport {
+	return &TransitReport{lines: make(map[string]*LineReport)}
+}
+
+// Add adds the trip record of a vehicle, records of vehicles without a line are ignored
+func (t *TransitReport) Add(r TripRecord) {
+	if t == nil || r.Line == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	line, ok := t.lines[r.Line]
+	if !ok {
+		line = &LineReport{Line: r.Line, MinRunTime: math.Inf(1), MaxDelay: math.Inf(-1)}
+		t.lines[r.Line] = line
+	}
+
+	runTime := r.Arrival - r.Departure
+	line.Runs++
+	line.totalRun += runTime
+	line.MeanRunTime = line.totalRun / float64(line.Runs)
+	line.MinRunTime = math.Min(line.MinRunTime, runTime)
+	line.MaxRunTime = math.Max(line.MaxRunTime, runTime)
+
+	for i, stop := range r.Stops {
+		// the first stop is left on time by definition
+		if i == 0 || !stop.Reached {
+			continue
+		}
+		delay := stop.Delay()
+		line.Arrivals++
+		line.totalDelay += delay
+		line.MeanDelay = line.totalDelay / float64(line.Arrivals)
+		line.MaxDelay = math.Max(line.MaxDelay, delay)
+		if delay >= OnTimeEarly && delay <= OnTimeLate {
+			line.OnTime++
+		}
+	}
+}
+
+// Lines returns the reports of all lines sorted by line
+func (t *TransitReport) Lines() []LineReport {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := make([]LineReport, 0, len(t.lines))
+	for _, line := range t.lines {
+		l := *line
+		if l.Arrivals == 0 {
+			l.MaxDelay = 0
+		}
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Line < lines[j].Line
+	})
+	return lines
+}
+
+// WriteTransitReportFile writes the line reports as CSV
+func WriteTransitReportFile(path string, lines []LineReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	header := []string{"line", "runs", "mean_run_time", "min_run_time", "max_run_time", "arrivals", "mean_delay", "max_delay", "on_time_share"}
+	if err := w.Write(header); err != nil {
+		_ = f.Close()
+		return err
+	}
+	format := func(x float64) string {
+		return strconv.FormatFloat(x, 'f', 2, 64)
+	}
+	for _, l := range lines {
+		row := []string{
+			l.Line,
+			strconv.Itoa(l.Runs),
+			format(l.MeanRunTime),
+			format(l.MinRunTime),
+			format(l.MaxRunTime),
+			strconv.Itoa(l.Arrivals),
+			format(l.MeanDelay),
+			format(l.MaxDelay),
+			format(l.OnTimeShare()),
+		}
+		if err := w.Write(row); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
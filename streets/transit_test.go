@@ -0,0 +1,97 @@
+package streets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreetGraph_AddTransitVehicles(t *testing.T) {
+	g := lineGraph(t)
+	transit := Transit{Lines: []TransitLine{{
+		ID:      "12",
+		Route:   []int{1, 2, 3, 4},
+		Stops:   []TransitStop{{Vertex: 1}, {Vertex: 3, Dwell: 20}, {Vertex: 4}},
+		Speed:   5,
+		Headway: 100,
+		Last:    250,
+	}}}
+
+	vehicles, err := g.AddTransitVehicles(transit)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(vehicles))
+
+	v := vehicles[1]
+	assert.Equal(t, "12-1", v.ID)
+	assert.Equal(t, "bus", v.Class)
+	assert.Equal(t, 100., v.Departure)
+	assert.Equal(t, []float64{100, 104, 126}, []float64{v.Stops[0].Scheduled, v.Stops[1].Scheduled, v.Stops[2].Scheduled})
+	assert.True(t, v.Stops[0].Reached)
+
+	v.Drive()
+	for _, stop := range v.Stops {
+		assert.True(t, stop.Reached)
+	}
+	// the bus waits at the middle stop, not at the end of the line
+	assert.Equal(t, v.Stops[2].Arrival, v.Time)
+	assert.GreaterOrEqual(t, v.Stops[2].Arrival-v.Stops[1].Arrival, 20.)
+
+	report := NewTransitReport()
+	report.Add(v.TripRecord())
+	report.Add(TripRecord{ID: "car"})
+	lines := report.Lines()
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %d", len(lines))
+	}
+	assert.Equal(t, 1, lines[0].Runs)
+	assert.Equal(t, 2, lines[0].Arrivals)
+	assert.Equal(t, v.Time-v.Departure, lines[0].MeanRunTime)
+
+	path := filepath.Join(t.TempDir(), "transit.csv")
+	assert.NoError(t, WriteTransitReportFile(path, lines))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "line,runs,"))
+}
+
+func TestStreetGraph_AddTransitVehiclesInvalid(t *testing.T) {
+	g := lineGraph(t)
+
+	for name, line := range map[string]TransitLine{
+		"no edge":       {ID: "a", Route: []int{1, 3}, Headway: 60},
+		"stop off path": {ID: "b", Route: []int{1, 2}, Stops: []TransitStop{{Vertex: 4}}, Headway: 60},
+		"stop order":    {ID: "c", Route: []int{1, 2, 3}, Stops: []TransitStop{{Vertex: 3}, {Vertex: 2}}, Headway: 60},
+		"no headway":    {ID: "d", Route: []int{1, 2}},
+		"unknown mode":  {ID: "e", Route: []int{1, 2}, Mode: "ferry", Headway: 60},
+	} {
+		_, err := g.AddTransitVehicles(Transit{Lines: []TransitLine{line}})
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTransitReport_Punctuality(t *testing.T) {
+	report := NewTransitReport()
+	report.Add(TripRecord{Line: "1", Departure: 0, Arrival: 100, Stops: []StopTime{
+		{Scheduled: 0, Arrival: 0, Reached: true},
+		{Scheduled: 50, Arrival: 60, Reached: true},
+		{Scheduled: 90, Arrival: 500, Reached: true},
+	}})
+	report.Add(TripRecord{Line: "1", Departure: 100, Arrival: 300})
+
+	lines := report.Lines()
+	assert.Equal(t, 2, lines[0].Runs)
+	assert.Equal(t, 150., lines[0].MeanRunTime)
+	assert.Equal(t, 100., lines[0].MinRunTime)
+	assert.Equal(t, 200., lines[0].MaxRunTime)
+	assert.Equal(t, 410., lines[0].MaxDelay)
+	assert.Equal(t, 0.5, lines[0].OnTimeShare())
+
+	var nilReport *TransitReport
+	nilReport.Add(TripRecord{Line: "1"})
+	assert.Nil(t, nilReport.Lines())
+}
@@ -0,0 +1,236 @@
+package streets
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TripRecord is the summary of a finished trip
+type TripRecord struct {
+	ID          string  `json:"id"`
+	Origin      int     `json:"origin"`
+	Destination int     `json:"destination"`
+	Departure   float64 `json:"departure"`
+	Arrival     float64 `json:"arrival"`
+	Distance    float64 `json:"distance"`
+	Edges       int     `json:"edges"`
+	Leaves      []int   `json:"leaves"`
+	Handoffs    int     `json:"handoffs"`
+	Class       string  `json:"class"`
+	Line        string  `json:"line"`
+	// Facility is the parking facility the vehicle parked in, empty if it parked on the street
+	Facility       string  `json:"facility"`
+	SearchTime     float64 `json:"search_time"`
+	SearchDistance float64 `json:"search_distance"`
+	// Stopped is set if the run ended before the vehicle reached its destination
+	Stopped bool `json:"stopped"`
+	// Stops are the arrivals of a transit vehicle, they are not written to CSV
+	Stops []StopTime `json:"stops,omitempty"`
+	// Dropped is set by a leaf for a vehicle it could not drive, the root counts it as missing instead
+	// of writing it. A dropped record without an ID reports vehicles the leaf lost without knowing them.
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// tripRecordHeader is the CSV header of trip records
+var tripRecordHeader = []string{"id", "origin", "destination", "departure", "arrival", "distance", "edges", "leaves", "handoffs", "class", "line", "facility", "search_time", "search_distance", "stopped"}
+
+// TripRecord returns the trip record of a vehicle
+func (v *Vehicle) TripRecord() TripRecord {
+	leaves := v.Leaves
+	if leaves == nil {
+		leaves = []int{}
+	}
+	return TripRecord{
+		ID:             v.ID,
+		Origin:         v.Origin,
+		Destination:    v.Destination,
+		Departure:      v.Departure,
+		Arrival:        v.Time,
+		Distance:       v.Distance,
+		Edges:          v.Edges,
+		Leaves:         leaves,
+		Handoffs:       v.Handoffs,
+		Class:          v.Class,
+		Line:           v.Line,
+		Facility:       v.Parking.Facility,
+		SearchTime:     v.SearchTime(),
+		SearchDistance: v.SearchDistance(),
+		Stopped:        v.Stopped,
+		Stops:          v.Stops,
+	}
+}
+
+func (r *TripRecord) Marshal() ([]byte, error) {
+	return seal(MsgTripRecord, func(e *encoder) { e.tripRecord(r) }), nil
+}
+
+func UnmarshalTripRecord(data []byte) (TripRecord, error) {
+	d, err := openEnvelope(data, MsgTripRecord)
+	if err != nil {
+		return TripRecord{}, err
+	}
+	r := d.tripRecord()
+	return r, d.close()
+}
+
+// csvRow formats a trip record as a CSV row, leaves are separated by ';'
+func (r *TripRecord) csvRow() []string {
+	leaves := make([]string, len(r.Leaves))
+	for i, l := range r.Leaves {
+		leaves[i] = strconv.Itoa(l)
+	}
+	return []string{
+		r.ID,
+		strconv.Itoa(r.Origin),
+		strconv.Itoa(r.Destination),
+		strconv.FormatFloat(r.Departure, 'f', -1, 64),
+		strconv.FormatFloat(r.Arrival, 'f', -1, 64),
+		strconv.FormatFloat(r.Distance, 'f', -1, 64),
+		strconv.Itoa(r.Edges),
+		strings.Join(leaves, ";"),
+		strconv.Itoa(r.Handoffs),
+		r.Class,
+		r.Line,
+		r.Facility,
+		strconv.FormatFloat(r.SearchTime, 'f', -1, 64),
+		strconv.FormatFloat(r.SearchDistance, 'f', -1, 64),
+		strconv.FormatBool(r.Stopped),
+	}
+}
+
+// TripWriter writes trip records to a file. It is safe for concurrent use.
+type TripWriter struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	csv   *csv.Writer
+	json  *json.Encoder
+	count int
+}
+
+// NewTripWriter creates a trip record file. Files ending in .csv are written as CSV, all others as JSON Lines.
+func NewTripWriter(path string) (*TripWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tw := &TripWriter{f: f, w: bufio.NewWriter(f)}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		tw.csv = csv.NewWriter(tw.w)
+		if err := tw.csv.Write(tripRecordHeader); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	} else {
+		tw.json = json.NewEncoder(tw.w)
+	}
+	return tw, nil
+}
+
+// ResumeTripWriter continues a trip record file of a checkpointed run. Only the first keep records are kept,
+// records written after the checkpoint are written again by the resumed run.
+func ResumeTripWriter(path string, keep int) (*TripWriter, error) {
+	isCSV := strings.EqualFold(filepath.Ext(path), ".csv")
+	lines := make([]string, 0)
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if isCSV && len(lines) > 0 {
+		// header
+		keep++
+	}
+	if len(lines) < keep {
+		return nil, fmt.Errorf("%s has %d lines, expected at least %d", path, len(lines), keep)
+	}
+
+	if keep == 0 {
+		return NewTripWriter(path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	tw := &TripWriter{f: f, w: bufio.NewWriter(f), count: keep}
+	for _, line := range lines[:keep] {
+		if _, err := tw.w.WriteString(line + "\n"); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	if isCSV {
+		tw.count--
+		tw.csv = csv.NewWriter(tw.w)
+	} else {
+		tw.json = json.NewEncoder(tw.w)
+	}
+	return tw, nil
+}
+
+// Write appends a trip record
+func (tw *TripWriter) Write(r TripRecord) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.count++
+	if tw.csv != nil {
+		return tw.csv.Write(r.csvRow())
+	}
+	return tw.json.Encode(r)
+}
+
+// Count returns the number of records written
+func (tw *TripWriter) Count() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.count
+}
+
+// Flush writes buffered records to the file
+func (tw *TripWriter) Flush() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.csv != nil {
+		tw.csv.Flush()
+		if err := tw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return tw.w.Flush()
+}
+
+// Close flushes and closes the file
+func (tw *TripWriter) Close() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.csv != nil {
+		tw.csv.Flush()
+		if err := tw.csv.Error(); err != nil {
+			_ = tw.f.Close()
+			return err
+		}
+	}
+	if err := tw.w.Flush(); err != nil {
+		_ = tw.f.Close()
+		return err
+	}
+	return tw.f.Close()
+}
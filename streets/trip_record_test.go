@@ -0,0 +1,134 @@
+package streets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVehicle_TripRecord(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).WithDeparture(5).Build()
+	assert.NoError(t, err)
+
+	v.Drive()
+	r := v.TripRecord()
+
+	assert.Equal(t, 1, r.Origin)
+	assert.Equal(t, 4, r.Destination)
+	assert.Equal(t, 5., r.Departure)
+	assert.Greater(t, r.Arrival, r.Departure)
+	assert.Equal(t, 30., r.Distance)
+	assert.Equal(t, 3, r.Edges)
+	assert.Equal(t, []int{}, r.Leaves)
+}
+
+func TestTripRecord_Marshal(t *testing.T) {
+	r := TripRecord{ID: "a", Origin: 1, Destination: 2, Arrival: 3, Leaves: []int{1, 2}, Handoffs: 1}
+	b, err := r.Marshal()
+	assert.NoError(t, err)
+
+	u, err := UnmarshalTripRecord(b)
+	assert.NoError(t, err)
+	assert.Equal(t, r, u)
+}
+
+func TestTripRecord_MarshalDropped(t *testing.T) {
+	r := TripRecord{ID: "a", Leaves: []int{}, Dropped: true}
+	b, err := r.Marshal()
+	assert.NoError(t, err)
+
+	u, err := UnmarshalTripRecord(b)
+	assert.NoError(t, err)
+	assert.True(t, u.Dropped)
+	assert.Equal(t, "a", u.ID)
+}
+
+func TestTripWriter(t *testing.T) {
+	dir := t.TempDir()
+	records := []TripRecord{
+		{ID: "a", Origin: 1, Destination: 2, Leaves: []int{1, 2}, Handoffs: 1, Class: "bus", Line: "12"},
+		{ID: "b", Origin: 2, Destination: 1, Leaves: []int{2}},
+	}
+
+	for _, name := range []string{"trips.csv", "trips.jsonl"} {
+		path := filepath.Join(dir, name)
+		tw, err := NewTripWriter(path)
+		assert.NoError(t, err)
+		for _, r := range records {
+			assert.NoError(t, tw.Write(r))
+		}
+		assert.Equal(t, 2, tw.Count())
+		assert.NoError(t, tw.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if strings.HasSuffix(name, ".csv") {
+			assert.Equal(t, 3, len(lines))
+			assert.Equal(t, "a,1,2,0,0,0,0,1;2,1,bus,12,,0,0,false", lines[1])
+		} else {
+			assert.Equal(t, 2, len(lines))
+			assert.Contains(t, lines[0], `"leaves":[1,2]`)
+		}
+	}
+}
+
+func TestVehicle_StopAtLimit(t *testing.T) {
+	g := lineGraph(t)
+	g.Until = 2
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+
+	v.Drive()
+	record := v.TripRecord()
+	assert.True(t, record.Stopped)
+	assert.GreaterOrEqual(t, record.Arrival, 2.)
+	assert.Less(t, record.Edges, 3)
+
+	g.Until = 0
+	v, err = NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+	v.Drive()
+	assert.False(t, v.TripRecord().Stopped)
+	assert.Equal(t, 3, v.Edges)
+}
+
+func TestVehicle_StopAtLimitHalted(t *testing.T) {
+	g := lineGraph(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Halt = ctx
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+
+	assert.False(t, v.StopAtLimit())
+	cancel()
+	assert.NoError(t, v.Drive())
+	assert.True(t, v.TripRecord().Stopped)
+	assert.Equal(t, 0, v.Edges)
+}
+
+func TestSummarizeTrips(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.jsonl")
+	tw, err := NewTripWriter(path)
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Write(TripRecord{ID: "a", Departure: 10, Arrival: 30, Distance: 100}))
+	assert.NoError(t, tw.Write(TripRecord{ID: "b", Departure: 0, Arrival: 60, Distance: 300, Stopped: true}))
+	assert.NoError(t, tw.Close())
+
+	records, err := LoadTripRecordsFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+
+	s := SummarizeTrips(records)
+	assert.Equal(t, TripSummary{Vehicles: 2, Stopped: 1, MeanTravelTime: 40, MeanDistance: 200, LastArrival: 60}, s)
+	assert.Equal(t, TripSummary{}, SummarizeTrips(nil))
+}
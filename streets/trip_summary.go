@@ -0,0 +1,75 @@
+package streets
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// TripSummary condenses the trip records of a run
+type TripSummary struct {
+	Vehicles       int
+	Stopped        int
+	MeanTravelTime float64
+	MeanDistance   float64
+	// LastArrival is the simulated time the last vehicle parked or stopped at
+	LastArrival float64
+}
+
+// ReadTripRecords reads trip records written as JSON Lines by a TripWriter
+func ReadTripRecords(r io.Reader) ([]TripRecord, error) {
+	records := make([]TripRecord, 0)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record TripRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// LoadTripRecordsFile reads a JSON Lines trip record file
+func LoadTripRecordsFile(path string) ([]TripRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadTripRecords(f)
+}
+
+// SummarizeTrips computes the summary of trip records
+func SummarizeTrips(records []TripRecord) TripSummary {
+	s := TripSummary{Vehicles: len(records)}
+	if len(records) == 0 {
+		return s
+	}
+	for _, r := range records {
+		if r.Stopped {
+			s.Stopped++
+		}
+		s.MeanTravelTime += r.Arrival - r.Departure
+		s.MeanDistance += r.Distance
+		if r.Arrival > s.LastArrival {
+			s.LastArrival = r.Arrival
+		}
+	}
+	s.MeanTravelTime /= float64(len(records))
+	s.MeanDistance /= float64(len(records))
+	return s
+}
@@ -2,19 +2,58 @@ package streets
 
 import (
 	"errors"
+	"fmt"
 	"github.com/rs/zerolog/log"
+	"math"
 )
 
 // Drive is for the non-MPI implementation
-func (v *Vehicle) Drive() {
-	for !v.IsParked {
-		v.Step()
+func (v *Vehicle) Drive() error {
+	return v.DriveWith(nil)
+}
+
+// DriveWith drives the vehicle like Drive and calls onStep after every step, e.g. to report its position
+func (v *Vehicle) DriveWith(onStep func(*Vehicle)) error {
+	for {
+		done, err := v.DriveStep(onStep)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// DriveStep drives one step of DriveWith and reports whether the vehicle is parked
+func (v *Vehicle) DriveStep(onStep func(*Vehicle)) (bool, error) {
+	if v.IsParked || v.StopAtLimit() {
+		log.Info().Msgf("[%s] is parked at %f.", v.ID, v.Time)
+		return true, nil
+	}
+	if err := v.Step(); err != nil {
+		return false, err
 	}
-	log.Info().Msgf("[%s] is parked.", v.ID)
+	if onStep != nil {
+		onStep(v)
+	}
+	return false, nil
 }
 
-// Step is the main algorithm for the vehicle
-func (v *Vehicle) Step() {
+// StopAtLimit parks the vehicle where it is once it has reached the Until time of its graph or the graph is halted
+func (v *Vehicle) StopAtLimit() bool {
+	if v.StreetGraph == nil {
+		return false
+	}
+	halted := v.StreetGraph.Halt != nil && v.StreetGraph.Halt.Err() != nil
+	if !halted && (v.StreetGraph.Until <= 0 || v.Time < v.StreetGraph.Until) {
+		return false
+	}
+	log.Debug().Msgf("[%s] is stopped at %f.", v.ID, v.Time)
+	v.IsParked = true
+	v.Stopped = true
+	return true
+}
+
+// Step is the main algorithm for the vehicle. It returns ErrMissingEdge if the edge of the vehicle is not in its graph.
+func (v *Vehicle) Step() error {
 	log.Debug().Msgf("[%s] is stepping.", v.ID)
 	if v.NextID < 0 { // III.1
 		log.Debug().Msgf("[%s] is parked. (III.3)", v.ID)
@@ -25,17 +64,53 @@ func (v *Vehicle) Step() {
 	edge, err := v.StreetGraph.Graph.Edge(v.PrevID, v.NextID)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get edge.")
-		panic(err)
+		return fmt.Errorf("%w: %d -> %d of vehicle %s", ErrMissingEdge, v.PrevID, v.NextID, v.ID)
 	}
 	log.Debug().Msgf("[%s] is on edge %d -> %d (III.2)", v.ID, v.PrevID, v.NextID)
 
 	data, ok := edge.Properties.Data.(Data)
 	if !ok {
 		log.Error().Msg("Failed to convert edge data to Data.")
-		panic(errors.New("failed to convert edge data to Data"))
+		return errors.New("failed to convert edge data to Data")
+	}
+
+	enter, exit := v.driveEdge(data.Length)
+	if data.Stats != nil {
+		data.Stats.Record(enter, exit, data.Length, v.Length())
 	}
 
-	v.DistanceRemaining = data.Length
+	v.AdvanceToNext() // III.6.1, III.6.2
+	log.Debug().Msgf("[%s] is on %d, next is %d (III.6.1, III.6.2)", v.ID, v.PrevID, v.NextID)
+	if v.IsParked {
+		// III.8
+		log.Info().Msgf("[%s] is parked. (III.8)", v.ID)
+		return nil
+	} else if v.MarkedForDeletion {
+		// III.9.2
+		log.Info().Msgf("[%s] is marked for deletion. (III.9.2)", v.ID)
+		return nil
+	}
+
+	// III.9.1
+	// continue steps
+	log.Debug().Msgf("[%s] is continuing steps. (III.9.1)", v.ID)
+	return nil
+}
+
+// CrossEdge drives the edge PrevID -> NextID of the given length between two leaves, which is only in the
+// root graph, and returns the simulated times the vehicle entered and left it
+func (v *Vehicle) CrossEdge(length float64) (enter, exit float64) {
+	return v.driveEdge(length)
+}
+
+// driveEdge drives the edge PrevID -> NextID up to NextID and returns the simulated times the vehicle entered
+// and left it
+func (v *Vehicle) driveEdge(length float64) (enter, exit float64) {
+	v.EdgeFrom, v.EdgeTo = v.PrevID, v.NextID
+	v.DistanceRemaining = length
+	v.Distance += length
+	v.Edges++
+	enter = v.Time
 
 	// III.3
 	v.DistanceRemaining += v.Delta
@@ -43,74 +118,53 @@ func (v *Vehicle) Step() {
 
 	// III.4
 	log.Debug().Msgf("[%s] has speed %f (III.4)", v.ID, v.Speed)
-	for v.DistanceRemaining >= v.Speed && v.DistanceRemaining-v.Speed > 0 {
-		v.DistanceRemaining -= v.Speed // III.5
+	for speed := v.tickSpeed(); v.DistanceRemaining >= speed && v.DistanceRemaining-speed > 0; speed = v.tickSpeed() {
+		v.CurrentSpeed = speed
+		v.DistanceRemaining -= speed // III.5
+		// every step of Speed takes one tick of simulated time
+		v.Time++
 		log.Debug().Msgf("[%s] has distance remaining %f (III.5)", v.ID, v.DistanceRemaining)
 	}
+	exit = v.Time
+
 	// III.6
 	v.Delta = v.DistanceRemaining
 	v.DistanceRemaining = 0
 	log.Debug().Msgf("[%s] has delta remaining %f (III.6)", v.ID, v.Delta)
-
-	// because no vertex ID can be -1, which indicates a leaf switch.
-	nextStepId := v.GetNextID(v.NextID)
-	if v.MarkedForDeletion {
-		log.Debug().Msgf("[%s] Path %v - Next ID %d", v.ID, v.PathIDs, v.NextID)
-		// III.9.2
-		log.Info().Msgf("[%s] is marked for deletion. (III.9.2)", v.ID)
-		return
-	} else if nextStepId == 0 {
-		// FIXME: only this parking is triggered
-		// III.8
-		log.Info().Msgf("[%s] is parked. (III.8)", v.ID)
-		v.IsParked = true
-		return
-	}
-
-	v.PrevID = v.NextID // III.6.1
-	log.Debug().Msgf("[%s] has prevID %d (III.6.1)", v.ID, v.PrevID)
-	v.NextID = v.GetNextID(v.PrevID) // III.6.2
-	log.Debug().Msgf("[%s] has nextID %d (III.6.2)", v.ID, v.NextID)
-
-	// III.8
-	if v.NextID == 0 {
-		log.Debug().Msgf("[%s] is parked. (III.8)", v.ID)
-		v.IsParked = true
-		return
-	}
-
-	// III.9.1
-	// continue steps
-	log.Debug().Msgf("[%s] is continuing steps. (III.9.1)", v.ID)
+	v.ArriveAt(v.EdgeTo)
+	return enter, exit
 }
 
-// GetNextID returns the next ID in the path, 0 if the vehicle is parked (III.7)
-func (v *Vehicle) GetNextID(prevID int) int {
-	var prevIdIndex = -1
-
-	for i := 0; i < len(v.PathIDs); i++ {
-		if v.PathIDs[i] == prevID {
-			prevIdIndex = i
-		}
+// tickSpeed returns the distance driven in the next tick, vehicles with an acceleration speed up to Speed
+func (v *Vehicle) tickSpeed() float64 {
+	if v.Acceleration <= 0 || v.CurrentSpeed >= v.Speed {
+		return v.Speed
 	}
+	return math.Min(v.Speed, v.CurrentSpeed+v.Acceleration)
+}
 
-	isLastIdx := prevIdIndex == len(v.PathIDs)-1
+// SetDeparture sets the departure time of a vehicle that has not started driving yet
+func (v *Vehicle) SetDeparture(departure float64) {
+	v.Departure = departure
+	v.Time = departure
+}
 
-	// if the vehicle is parked, it is at its destination
-	if v.NextID == 0 || isLastIdx || v.IsParked {
-		// if vehicle is parked nextID is not 0
-		v.IsParked = true
-		return 0
+// AdvanceToNext moves the vehicle to NextID. At its destination the vehicle looks for parking, otherwise the
+// vertex after it becomes NextID and the vehicle is marked for deletion if that vertex is on another leaf.
+func (v *Vehicle) AdvanceToNext() {
+	if vertex, ok := v.Route.Advance(); ok {
+		v.PrevID = vertex
 	}
-
-	nextID := v.PathIDs[prevIdIndex+1]
-	vertexExistsOnCurrentGraph := v.StreetGraph.VertexExists(nextID)
-	if !vertexExistsOnCurrentGraph {
-		log.Debug().Msgf("Deletion causing ID for %s -> %d", v.ID, nextID)
-		// III.9.2
-		v.MarkedForDeletion = true
-		//return -
+	if v.AtDestination() {
+		v.NextID = 0
+		v.FindParking(v.PrevID)
+		return
 	}
+	v.NextID, _ = v.Peek()
+	v.MarkedForDeletion = v.NextIsForeign()
+}
 
-	return nextID
+// NextIsForeign reports whether NextID is not on the graph of the vehicle, it is then handed over to another leaf
+func (v *Vehicle) NextIsForeign() bool {
+	return v.NextID != 0 && v.StreetGraph != nil && !v.StreetGraph.VertexExists(v.NextID)
 }
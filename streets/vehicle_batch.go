@@ -0,0 +1,213 @@
+package streets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchOptions are the thresholds at which the buffered vehicles of a destination are sent
+type BatchOptions struct {
+	// MaxVehicles sends a batch once it holds this many vehicles, 1 sends every vehicle on its own
+	MaxVehicles int
+	// MaxBytes sends a batch once its encoded vehicles reach this size, 0 ignores the size
+	MaxBytes int
+	// MaxDelay sends every batch at least this often
+	MaxDelay time.Duration
+}
+
+// DefaultBatchOptions batch the vehicles crossing between leaves within a few milliseconds
+var DefaultBatchOptions = BatchOptions{MaxVehicles: 32, MaxBytes: 64 << 10, MaxDelay: 2 * time.Millisecond}
+
+// Validate checks that every vehicle is eventually sent
+func (o BatchOptions) Validate() error {
+	if o.MaxVehicles < 1 {
+		return errors.New("a batch must hold at least one vehicle")
+	}
+	if o.MaxBytes < 0 {
+		return errors.New("the batch size must not be negative")
+	}
+	if o.MaxVehicles > 1 && o.MaxDelay <= 0 {
+		// a vehicle could wait in a batch that never fills up
+		return errors.New("batches of several vehicles need a positive delay")
+	}
+	return nil
+}
+
+// EncodeVehicleBatch frames encoded vehicles as one message
+func EncodeVehicleBatch(vehicles [][]byte) []byte {
+	return seal(MsgVehicleBatch, func(e *encoder) {
+		e.u32(uint32(len(vehicles)))
+		for _, v := range vehicles {
+			e.bytes(v)
+		}
+	})
+}
+
+// splitVehicleBatch returns the encoded vehicles of a batch
+func splitVehicleBatch(data []byte) ([][]byte, error) {
+	d, err := openEnvelope(data, MsgVehicleBatch)
+	if err != nil {
+		return nil, err
+	}
+	count := d.count(4)
+	vehicles := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		vehicles = append(vehicles, d.bytes())
+	}
+	if err := d.close(); err != nil {
+		return nil, fmt.Errorf("malformed vehicle batch: %w", err)
+	}
+	return vehicles, nil
+}
+
+// BatchError is returned with the vehicles of a batch that could be decoded if others could not
+type BatchError struct {
+	// Lost are the IDs of the vehicles that could not be decoded, empty if not even the ID could be read
+	Lost []string
+	Err  error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d vehicles of the batch cannot be decoded: %v", len(e.Lost), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeVehicleBatch unpacks all vehicles of a batch. A vehicle that cannot be decoded does not keep the
+// others of the batch from being returned, it is reported in a *BatchError.
+func DecodeVehicleBatch(data []byte) ([]Vehicle, error) {
+	encoded, err := splitVehicleBatch(data)
+	if err != nil {
+		return nil, err
+	}
+	vehicles := make([]Vehicle, 0, len(encoded))
+	var batchErr *BatchError
+	for _, e := range encoded {
+		vehicle, err := UnmarshalVehicle(e)
+		if err != nil {
+			if batchErr == nil {
+				batchErr = &BatchError{Err: err}
+			}
+			batchErr.Lost = append(batchErr.Lost, vehicleID(e))
+			continue
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+	if batchErr != nil {
+		return vehicles, batchErr
+	}
+	return vehicles, nil
+}
+
+// pendingBatch are the encoded vehicles waiting for a destination
+type pendingBatch struct {
+	vehicles [][]byte
+	bytes    int
+}
+
+// VehicleBatcher buffers encoded vehicles per destination rank and sends them as batches.
+// It is safe for concurrent use.
+type VehicleBatcher struct {
+	opts BatchOptions
+	send func(dest int, batch []byte)
+
+	mu      sync.Mutex
+	pending map[int]*pendingBatch
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewVehicleBatcher returns a batcher that hands every full batch to send
+func NewVehicleBatcher(opts BatchOptions, send func(dest int, batch []byte)) (*VehicleBatcher, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	b := &VehicleBatcher{
+		opts:    opts,
+		send:    send,
+		pending: make(map[int]*pendingBatch),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if opts.MaxVehicles > 1 {
+		go b.flushPeriodically()
+	} else {
+		close(b.done)
+	}
+	return b, nil
+}
+
+func (b *VehicleBatcher) flushPeriodically() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.MaxDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add buffers an encoded vehicle for dest and sends the batch if it has reached a threshold
+func (b *VehicleBatcher) Add(dest int, vehicle []byte) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errors.New("vehicle batcher is closed")
+	}
+	p, ok := b.pending[dest]
+	if !ok {
+		p = &pendingBatch{}
+		b.pending[dest] = p
+	}
+	p.vehicles = append(p.vehicles, vehicle)
+	p.bytes += len(vehicle)
+	var full [][]byte
+	if len(p.vehicles) >= b.opts.MaxVehicles || (b.opts.MaxBytes > 0 && p.bytes >= b.opts.MaxBytes) {
+		full = p.vehicles
+		delete(b.pending, dest)
+	}
+	b.mu.Unlock()
+
+	// sends can block, other goroutines keep adding in the meantime
+	if full != nil {
+		b.send(dest, EncodeVehicleBatch(full))
+	}
+	return nil
+}
+
+// Flush sends the buffered vehicles of every destination
+func (b *VehicleBatcher) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[int]*pendingBatch)
+	b.mu.Unlock()
+
+	for dest, p := range pending {
+		b.send(dest, EncodeVehicleBatch(p.vehicles))
+	}
+}
+
+// Close sends the buffered vehicles and stops the periodic flush, vehicles cannot be added afterwards
+func (b *VehicleBatcher) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.done
+	b.Flush()
+}
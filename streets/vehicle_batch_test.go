@@ -0,0 +1,116 @@
+package streets
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVehicleBatch_RoundTrip(t *testing.T) {
+	a := Vehicle{ID: "a", Route: NewRoute([]int{1, 2, 3}), NextID: 2, Speed: 5}
+	b := Vehicle{ID: "b", Route: NewRoute([]int{3, 4}), Time: 12.5, Leaves: []int{1}}
+	aBytes, err := a.Marshal()
+	assert.NoError(t, err)
+	bBytes, err := b.Marshal()
+	assert.NoError(t, err)
+
+	vehicles, err := DecodeVehicleBatch(EncodeVehicleBatch([][]byte{aBytes, bBytes}))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(vehicles))
+	assert.Equal(t, "a", vehicles[0].ID)
+	assert.Equal(t, []int{1, 2, 3}, vehicles[0].PathIDs)
+	assert.Equal(t, 12.5, vehicles[1].Time)
+
+	empty, err := DecodeVehicleBatch(EncodeVehicleBatch(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(empty))
+
+	batch := EncodeVehicleBatch([][]byte{aBytes})
+	for name, data := range map[string][]byte{
+		"stop marker": stopMarker,
+		"truncated":   batch[:len(batch)-1],
+		"trailing":    append(append([]byte{}, batch...), 1),
+		"count":       {byte(MsgVehicleBatch), CodecVersion, 4, 0, 0, 0, 5, 0, 0, 0},
+	} {
+		if _, err := DecodeVehicleBatch(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// the vehicles that can be decoded are returned with the IDs of the others
+	vehicles, err = DecodeVehicleBatch(EncodeVehicleBatch([][]byte{aBytes, bBytes[:len(bBytes)-1], {0xff}}))
+	var batchErr *BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, []string{"b", ""}, batchErr.Lost)
+	}
+	assert.ErrorIs(t, err, ErrMalformedInput)
+	assert.Equal(t, 1, len(vehicles))
+	assert.Equal(t, "a", vehicles[0].ID)
+}
+
+// sentBatches records the batches of a batcher per destination
+type sentBatches struct {
+	mu      sync.Mutex
+	batches map[int][]int
+}
+
+func (s *sentBatches) send(dest int, batch []byte) {
+	encoded, err := splitVehicleBatch(batch)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[dest] = append(s.batches[dest], len(encoded))
+}
+
+func (s *sentBatches) get(dest int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int{}, s.batches[dest]...)
+}
+
+func TestVehicleBatcher_Thresholds(t *testing.T) {
+	sent := &sentBatches{batches: make(map[int][]int)}
+	b, err := NewVehicleBatcher(BatchOptions{MaxVehicles: 3, MaxBytes: 10, MaxDelay: time.Hour}, sent.send)
+	assert.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, b.Add(1, []byte{byte(i)}))
+	}
+	assert.NoError(t, b.Add(2, make([]byte, 4)))
+	assert.NoError(t, b.Add(2, make([]byte, 6)))
+	assert.NoError(t, b.Add(3, []byte{1}))
+	assert.Equal(t, []int{3, 3}, sent.get(1))
+	assert.Equal(t, []int{2}, sent.get(2))
+	assert.Empty(t, sent.get(3))
+
+	b.Close()
+	assert.Equal(t, []int{3, 3, 1}, sent.get(1))
+	assert.Equal(t, []int{1}, sent.get(3))
+	assert.Error(t, b.Add(1, []byte{1}))
+}
+
+func TestVehicleBatcher_Delay(t *testing.T) {
+	sent := &sentBatches{batches: make(map[int][]int)}
+	b, err := NewVehicleBatcher(BatchOptions{MaxVehicles: 100, MaxDelay: time.Millisecond}, sent.send)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, b.Add(1, []byte{1}))
+	assert.NoError(t, b.Add(1, []byte{2}))
+	assert.Eventually(t, func() bool {
+		return len(sent.get(1)) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []int{2}, sent.get(1))
+}
+
+func TestBatchOptions_Validate(t *testing.T) {
+	assert.NoError(t, DefaultBatchOptions.Validate())
+	assert.NoError(t, BatchOptions{MaxVehicles: 1}.Validate())
+	assert.Error(t, BatchOptions{}.Validate())
+	assert.Error(t, BatchOptions{MaxVehicles: 8}.Validate())
+	assert.Error(t, BatchOptions{MaxVehicles: 8, MaxBytes: -1, MaxDelay: time.Millisecond}.Validate())
+}
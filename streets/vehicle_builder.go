@@ -2,28 +2,49 @@ package streets
 
 import (
 	"errors"
+	"fmt"
 	"github.com/aidarkhanov/nanoid"
 	"github.com/rs/zerolog/log"
 	"strings"
 )
 
+// vehicleIDLength is the length of generated vehicle IDs
+const vehicleIDLength = 20
+
+// vehicleIDAlphabet is the nanoid alphabet without '_' and '-'
+var vehicleIDAlphabet = strings.NewReplacer("_", "", "-", "").Replace(nanoid.DefaultAlphabet)
+
 type VehicleBuilder struct {
+	id      string
 	speed   float64
 	pathIDs []int
 
-	delta    float64
-	isParked bool
+	delta     float64
+	isParked  bool
+	departure float64
+
+	class        string
+	acceleration float64
+	maxSpeed     float64
 
 	prevID int
 	nextID int
 
 	graph *StreetGraph
+
+	// err is the first error of the builder chain, it is returned by Build
+	err error
 }
 
 func NewVehicleBuilder() *VehicleBuilder {
 	return &VehicleBuilder{}
 }
 
+func (vb *VehicleBuilder) WithID(id string) *VehicleBuilder {
+	vb.id = id
+	return vb
+}
+
 func (vb *VehicleBuilder) WithSpeed(speed float64) *VehicleBuilder {
 	vb.speed = speed
 	return vb
@@ -59,23 +80,52 @@ func (vb *VehicleBuilder) WithIsParked(isParked bool) *VehicleBuilder {
 	return vb
 }
 
-func (vb *VehicleBuilder) FromJsonBytes(jsonBytes []byte) (*VehicleBuilder, error) {
+func (vb *VehicleBuilder) WithDeparture(departure float64) *VehicleBuilder {
+	vb.departure = departure
+	return vb
+}
+
+// WithClass sets the class of the vehicle, its speed is capped to the max speed of the class
+func (vb *VehicleBuilder) WithClass(class VehicleClass) *VehicleBuilder {
+	vb.class = class.Name
+	vb.acceleration = class.Acceleration
+	vb.maxSpeed = class.MaxSpeed
+	return vb
+}
+
+// FromJsonBytes sets the fields of an encoded vehicle, Build returns ErrMalformedInput if it cannot be decoded
+func (vb *VehicleBuilder) FromJsonBytes(jsonBytes []byte) *VehicleBuilder {
 	v, err := UnmarshalVehicle(jsonBytes)
 	if err != nil {
-		return &VehicleBuilder{}, nil
+		log.Error().Err(err).Msg("Failed to unmarshal vehicle.")
+		if vb.err == nil {
+			vb.err = fmt.Errorf("%w: %w", ErrMalformedInput, err)
+		}
+		return vb
 	}
 
+	vb.id = v.ID
 	vb.speed = v.Speed
 	vb.pathIDs = v.PathIDs
 	vb.delta = v.Delta
 	vb.isParked = v.IsParked
 	vb.prevID = v.PrevID
 	vb.nextID = v.NextID
+	vb.departure = v.Departure
+	vb.class = v.Class
+	vb.acceleration = v.Acceleration
+	// the max speed is not encoded, it is the one of the class
+	if class, ok := v.VehicleClass(); ok {
+		vb.maxSpeed = class.MaxSpeed
+	}
 
-	return vb, nil
+	return vb
 }
 
 func (vb *VehicleBuilder) check() (*VehicleBuilder, error) {
+	if vb.err != nil {
+		return nil, vb.err
+	}
 	if vb.speed == 0. {
 		err := errors.New("speed is not set")
 		log.Error().Err(err).Msg("Failed to build vehicle.")
@@ -106,37 +156,45 @@ func (vb *VehicleBuilder) Build() (Vehicle, error) {
 		return Vehicle{}, err
 	}
 
-	newAlphabet := nanoid.DefaultAlphabet
-	newAlphabet = strings.Replace(newAlphabet, "_", "", -1)
-	newAlphabet = strings.Replace(newAlphabet, "-", "", -1)
-	vid, err := nanoid.Generate(newAlphabet, 20)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate vehicle ID.")
-		return Vehicle{}, err
+	vid := vb.id
+	if vid == "" {
+		vid, err = nanoid.Generate(vehicleIDAlphabet, vehicleIDLength)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate vehicle ID.")
+			return Vehicle{}, err
+		}
+	}
+
+	speed := vb.speed
+	if vb.maxSpeed > 0 && speed > vb.maxSpeed {
+		speed = vb.maxSpeed
 	}
 
 	vehicle := Vehicle{
 		ID:                vid,
-		PathIDs:           vb.pathIDs,
-		Speed:             vb.speed,
+		Route:             NewRoute(vb.pathIDs),
+		Speed:             speed,
+		Class:             vb.class,
+		Acceleration:      vb.acceleration,
 		Delta:             vb.delta,
 		NextID:            vb.nextID,
 		PrevID:            vb.prevID,
 		IsParked:          vb.isParked,
+		Departure:         vb.departure,
+		Time:              vb.departure,
+		Origin:            vb.pathIDs[0],
+		Destination:       vb.pathIDs[len(vb.pathIDs)-1],
 		DistanceRemaining: 0.0, // default value
 		StreetGraph:       vb.graph,
 	}
 
 	// ensure nextID is set
-	id := vehicle.GetNextID(vehicle.PathIDs[0])
-	if id == 0 {
+	if vehicle.NextID == 0 || vehicle.IsParked {
 		vehicle.IsParked = true
 		vehicle.NextID = 0
-	} else if id == -1 {
-		// TODO: this should never happen
-		panic("vehicle.GetNextID returned -1 at initialization")
 	} else {
-		vehicle.NextID = id
+		vehicle.NextID, _ = vehicle.Peek()
+		vehicle.MarkedForDeletion = vehicle.NextIsForeign()
 	}
 
 	return vehicle, nil
@@ -0,0 +1,141 @@
+package streets
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// VehicleClass holds the physical and behavioural parameters shared by a kind of vehicle
+type VehicleClass struct {
+	Name string `json:"name"`
+	// Length in meters, the share of an edge covered by vehicles is measured with it
+	Length float64 `json:"length"`
+	// MaxSpeed in meters per second, the speed of a vehicle is capped to it
+	MaxSpeed float64 `json:"max_speed"`
+	// Acceleration in meters per second squared, vehicles start from standstill
+	Acceleration float64 `json:"acceleration"`
+	// RoadClasses are the highway types the class may drive on, empty allows all.
+	// Edges without a highway type are open to every class.
+	RoadClasses []string `json:"road_classes"`
+}
+
+// majorRoads are the highway types open to heavy vehicles
+var majorRoads = []string{
+	"motorway", "motorway_link", "trunk", "trunk_link", "primary", "primary_link",
+	"secondary", "secondary_link", "tertiary", "tertiary_link", "unclassified",
+}
+
+// DefaultVehicleClasses are the built-in vehicle classes
+var DefaultVehicleClasses = map[string]VehicleClass{
+	"car":        {Name: "car", Length: 4.5, MaxSpeed: 36.1, Acceleration: 2.6},
+	"truck":      {Name: "truck", Length: 12, MaxSpeed: 25, Acceleration: 1, RoadClasses: majorRoads},
+	"bus":        {Name: "bus", Length: 12, MaxSpeed: 22.2, Acceleration: 1.2, RoadClasses: append([]string{"residential", "busway"}, majorRoads...)},
+	"motorcycle": {Name: "motorcycle", Length: 2.2, MaxSpeed: 36.1, Acceleration: 4},
+	"tram":       {Name: "tram", Length: 30, MaxSpeed: 19.4, Acceleration: 1},
+}
+
+// DefaultVehicleLength is the length of a vehicle without a class, the one of a car
+const DefaultVehicleLength = 4.5
+
+// Allows reports whether the class may drive on a road of the highway type
+func (c *VehicleClass) Allows(highway string) bool {
+	if highway == "" || len(c.RoadClasses) == 0 {
+		return true
+	}
+	for _, roadClass := range c.RoadClasses {
+		if roadClass == highway {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassMix is the share of each vehicle class in the population
+type ClassMix struct {
+	Classes []VehicleClass
+	Weights []float64
+}
+
+// ParseClassMix parses a mix like "car:0.8,truck:0.1,bus:0.05,motorcycle:0.05" of the default classes.
+// The weights do not need to sum up to 1.
+func ParseClassMix(s string) (*ClassMix, error) {
+	mix := &ClassMix{}
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		name, weight, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("class mix entry %q is not of the form class:weight", part)
+		}
+		class, ok := DefaultVehicleClasses[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown vehicle class %q", name)
+		}
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("weight of %s: %w", name, err)
+		}
+		if w < 0 {
+			return nil, fmt.Errorf("weight of %s must not be negative", name)
+		}
+		mix.Classes = append(mix.Classes, class)
+		mix.Weights = append(mix.Weights, w)
+		total += w
+	}
+	if total <= 0 {
+		return nil, errors.New("class mix needs a positive weight")
+	}
+	return mix, nil
+}
+
+// Sample draws a class according to the weights
+func (m *ClassMix) Sample(r *rand.Rand) VehicleClass {
+	total := 0.0
+	for _, w := range m.Weights {
+		total += w
+	}
+	x := r.Float64() * total
+	for i, w := range m.Weights {
+		if x < w {
+			return m.Classes[i]
+		}
+		x -= w
+	}
+	return m.Classes[len(m.Classes)-1]
+}
+
+// VehicleClass returns the class of the vehicle, false if it has none
+func (v *Vehicle) VehicleClass() (VehicleClass, bool) {
+	class, ok := DefaultVehicleClasses[v.Class]
+	return class, ok
+}
+
+// Length returns the length of the vehicle in meters from its class
+func (v *Vehicle) Length() float64 {
+	if class, ok := v.VehicleClass(); ok {
+		return class.Length
+	}
+	return DefaultVehicleLength
+}
+
+// pickClass draws the class of a new vehicle, nil if the graph has no class mix
+func (g *StreetGraph) pickClass() *VehicleClass {
+	if g.Classes == nil {
+		return nil
+	}
+	class := g.Classes.Sample(g.random().Class)
+	return &class
+}
+
+// ShortestPathFor returns the shortest path on the roads the class may drive on, all roads for a nil class
+func (g *StreetGraph) ShortestPathFor(src, dest int, class *VehicleClass) ([]int, error) {
+	if class == nil || len(class.RoadClasses) == 0 {
+		return g.ShortestPath(src, dest)
+	}
+	return g.shortestPath(src, dest, func(from, to int) bool {
+		data, err := g.edgeData(from, to)
+		return err == nil && class.Allows(data.Highway)
+	})
+}
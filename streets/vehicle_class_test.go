@@ -0,0 +1,78 @@
+package streets
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClassMix(t *testing.T) {
+	mix, err := ParseClassMix("car:0.75, truck:0.25")
+	assert.NoError(t, err)
+	assert.Len(t, mix.Classes, 2)
+
+	r := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[mix.Sample(r).Name]++
+	}
+	assert.InDelta(t, 3000, counts["car"], 150)
+	assert.InDelta(t, 1000, counts["truck"], 150)
+
+	for _, s := range []string{"car", "plane:1", "car:x", "car:-1", "car:0"} {
+		_, err := ParseClassMix(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestVehicle_Acceleration(t *testing.T) {
+	g := lineGraph(t)
+	car := DefaultVehicleClasses["car"]
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(50).
+		WithLastID(1).WithNextID(2).WithClass(car).Build()
+	assert.NoError(t, err)
+	assert.Equal(t, car.MaxSpeed, v.Speed)
+	assert.Equal(t, "car", v.Class)
+
+	// from standstill the car needs more ticks than one at full speed
+	v.Drive()
+	assert.Equal(t, 30., v.Distance)
+	assert.Greater(t, v.Time, 2.)
+	assert.Greater(t, v.CurrentSpeed, car.Acceleration)
+
+	b, err := v.Marshal()
+	assert.NoError(t, err)
+	u, err := UnmarshalVehicle(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "car", u.Class)
+	assert.Equal(t, v.CurrentSpeed, u.CurrentSpeed)
+	assert.Equal(t, car.Length, u.Length())
+}
+
+func TestStreetGraph_ShortestPathFor(t *testing.T) {
+	vertices := []JVertex{{ID: 1, X: 1, Y: 1}, {ID: 2, X: 2, Y: 1}, {ID: 3, X: 2, Y: 2}, {ID: 4, X: 3, Y: 1}}
+	edges := []JEdge{
+		{From: 1, To: 4, Length: 10, Highway: "residential"},
+		{From: 1, To: 2, Length: 10, Highway: "primary"},
+		{From: 2, To: 3, Length: 10},
+		{From: 3, To: 4, Length: 10, Highway: "primary"},
+	}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truck := DefaultVehicleClasses["truck"]
+	path, err := g.ShortestPathFor(1, 4, &truck)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, path)
+
+	path, err = g.ShortestPathFor(1, 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 4}, path)
+
+	assert.Error(t, g.validatePath([]int{1, 4}, &truck))
+	assert.NoError(t, g.validatePath([]int{1, 4}, nil))
+}
@@ -0,0 +1,103 @@
+package streets
+
+import (
+	"errors"
+	"math"
+)
+
+// Position is the place of a vehicle on an edge
+type Position struct {
+	From, To int
+	// Offset is the distance driven on the edge, between 0 and Length
+	Offset float64
+	Length float64
+	X, Y   float64
+}
+
+// edgeData returns the data of an edge, edges between two leaves are looked up in the root graph
+func (g *StreetGraph) edgeData(from, to int) (Data, error) {
+	edge, err := g.Graph.Edge(from, to)
+	if err != nil && g.RootGraph != nil {
+		edge, err = g.RootGraph.Graph.Edge(from, to)
+	}
+	if err != nil {
+		return Data{}, err
+	}
+	data, ok := edge.Properties.Data.(Data)
+	if !ok {
+		return Data{}, errors.New("edge data is not of type Data")
+	}
+	return data, nil
+}
+
+// Position returns the edge the vehicle is on, how far it has driven on it and the interpolated coordinates.
+// After a step the vehicle has Delta left to drive on the edge it stepped on, before its first step it waits
+// at the start of its first edge and once parked it is at the end of its last edge.
+func (v *Vehicle) Position() (Position, error) {
+	if v.StreetGraph == nil {
+		return Position{}, errors.New("vehicle is not on a graph")
+	}
+
+	from, to := v.EdgeFrom, v.EdgeTo
+	if to == 0 {
+		from, to = v.PrevID, v.NextID
+	}
+	if v.IsParked && len(v.PathIDs) >= 2 {
+		from, to = v.PathIDs[len(v.PathIDs)-2], v.PathIDs[len(v.PathIDs)-1]
+	}
+
+	data, err := v.StreetGraph.edgeData(from, to)
+	if err != nil {
+		return Position{}, err
+	}
+
+	offset := 0.0
+	switch {
+	case v.IsParked:
+		offset = data.Length
+	case v.EdgeTo != 0:
+		offset = math.Max(0, math.Min(data.Length, data.Length-v.Delta))
+	}
+
+	p := Position{From: from, To: to, Offset: offset, Length: data.Length}
+	p.X, p.Y, err = v.StreetGraph.interpolate(from, to, data, offset)
+	return p, err
+}
+
+// interpolate returns the coordinates at offset along the edge, following its geometry if there is one
+func (g *StreetGraph) interpolate(from, to int, data Data, offset float64) (float64, float64, error) {
+	points := data.Geometry
+	if len(points) < 2 {
+		a, err := g.vertex(from)
+		if err != nil {
+			return 0, 0, err
+		}
+		b, err := g.vertex(to)
+		if err != nil {
+			return 0, 0, err
+		}
+		points = [][2]float64{{a.X, a.Y}, {b.X, b.Y}}
+	}
+
+	fraction := 0.0
+	if data.Length > 0 {
+		fraction = offset / data.Length
+	}
+
+	// the polyline is in coordinates, the edge length in meters, so the fraction is applied to the polyline length
+	total := 0.0
+	for i := 1; i < len(points); i++ {
+		total += math.Hypot(points[i][0]-points[i-1][0], points[i][1]-points[i-1][1])
+	}
+	remaining := fraction * total
+	for i := 1; i < len(points); i++ {
+		segment := math.Hypot(points[i][0]-points[i-1][0], points[i][1]-points[i-1][1])
+		if remaining <= segment && segment > 0 {
+			t := remaining / segment
+			return points[i-1][0] + t*(points[i][0]-points[i-1][0]), points[i-1][1] + t*(points[i][1]-points[i-1][1]), nil
+		}
+		remaining -= segment
+	}
+	last := points[len(points)-1]
+	return last[0], last[1], nil
+}
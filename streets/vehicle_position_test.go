@@ -0,0 +1,53 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVehicle_Position(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+
+	p, err := v.Position()
+	assert.NoError(t, err)
+	assert.Equal(t, Position{From: 1, To: 2, Offset: 0, Length: 10, X: 1, Y: 1}, p)
+
+	// 9 of 10 meters are driven, 1 is carried to the next edge
+	v.Step()
+	p, err = v.Position()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.From)
+	assert.Equal(t, 2, p.To)
+	assert.InDelta(t, 9, p.Offset, 1e-9)
+	assert.InDelta(t, 1.9, p.X, 1e-9)
+
+	v.Drive()
+	p, err = v.Position()
+	assert.NoError(t, err)
+	assert.Equal(t, Position{From: 3, To: 4, Offset: 10, Length: 10, X: 4, Y: 1}, p)
+}
+
+func TestVehicle_PositionGeometry(t *testing.T) {
+	vertices := []JVertex{{ID: 1, X: 1, Y: 1}, {ID: 2, X: 3, Y: 1}}
+	edges := []JEdge{{From: 1, To: 2, Length: 40, Geometry: [][2]float64{{1, 1}, {1, 2}, {3, 2}, {3, 1}}}}
+	b := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	g, err := b.NumberOfRects(1).DivideGraphsIntoRects().PickRect(0).IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2}).WithSpeed(100).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+
+	// half way along the polyline of length 4 is the middle of the top segment
+	v.EdgeFrom, v.EdgeTo, v.Delta = 1, 2, 20
+	p, err := v.Position()
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, p.X, 1e-9)
+	assert.InDelta(t, 2, p.Y, 1e-9)
+}
@@ -0,0 +1,65 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVehicle_StepMissingEdge(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(3).Build()
+	assert.NoError(t, err)
+
+	// there is no edge 1 -> 3
+	assert.ErrorIs(t, v.Step(), ErrMissingEdge)
+	assert.ErrorIs(t, v.Drive(), ErrMissingEdge)
+	assert.False(t, v.IsParked)
+}
+
+func TestVehicle_CrossEdge(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).WithDeparture(5).Build()
+	assert.NoError(t, err)
+
+	// the edge is driven like one of the graph, it takes three ticks of 3 and leaves a delta of 1
+	enter, exit := v.CrossEdge(10)
+	assert.Equal(t, 5., enter)
+	assert.Equal(t, 8., exit)
+	assert.Equal(t, 8., v.Time)
+	assert.Equal(t, 1., v.Delta)
+	assert.Equal(t, 10., v.Distance)
+	assert.Equal(t, 1, v.Edges)
+	assert.Equal(t, 1, v.EdgeFrom)
+	assert.Equal(t, 2, v.EdgeTo)
+}
+
+func TestVehicleBuilder_FromJsonBytes(t *testing.T) {
+	g := lineGraph(t)
+	v, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+	vBytes, err := v.Marshal()
+	assert.NoError(t, err)
+
+	decoded, err := NewVehicleBuilder().FromJsonBytes(vBytes).WithGraph(g).Build()
+	assert.NoError(t, err)
+	assert.Equal(t, v.ID, decoded.ID)
+
+	_, err = NewVehicleBuilder().FromJsonBytes(vBytes[:len(vBytes)-1]).WithGraph(g).Build()
+	assert.ErrorIs(t, err, ErrMalformedInput)
+
+	// the speed of a decoded vehicle is still capped to its class
+	truck, err := NewVehicleBuilder().WithGraph(g).WithPathIDs([]int{1, 2, 3, 4}).WithSpeed(3).
+		WithClass(DefaultVehicleClasses["truck"]).WithLastID(1).WithNextID(2).Build()
+	assert.NoError(t, err)
+	tBytes, err := truck.Marshal()
+	assert.NoError(t, err)
+	decoded, err = NewVehicleBuilder().FromJsonBytes(tBytes).WithSpeed(100).WithGraph(g).Build()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultVehicleClasses["truck"].MaxSpeed, decoded.Speed)
+	_, err = g.AddVehicleFromJson([]byte{0xff})
+	assert.ErrorIs(t, err, ErrMalformedInput)
+}
@@ -0,0 +1,155 @@
+package streets
+
+func UnmarshalVehicle(data []byte) (Vehicle, error) {
+	d, err := openEnvelope(data, MsgVehicle)
+	if err != nil {
+		return Vehicle{}, err
+	}
+	r := d.vehicle()
+	return r.vehicle(), d.close()
+}
+
+// vehicleID reads the ID of an encoded vehicle that may be cut short, empty if not even the ID can be decoded
+func vehicleID(data []byte) string {
+	if len(data) < envelopeSize || MessageType(data[0]) != MsgVehicle || data[1] != CodecVersion {
+		return ""
+	}
+	d := &decoder{data: data[envelopeSize:]}
+	id := d.str()
+	if d.err != nil {
+		return ""
+	}
+	return id
+}
+
+// vehicle converts the wire format back to a vehicle, which is not placed on any graph
+func (r *rawVehicle) vehicle() Vehicle {
+	return Vehicle{
+		ID:                r.ID,
+		Route:             NewRoute(r.PathIDs),
+		Speed:             r.Speed,
+		Class:             r.Class,
+		Acceleration:      r.Acceleration,
+		CurrentSpeed:      r.CurrentSpeed,
+		Delta:             r.Delta,
+		NextID:            r.NextID,
+		PrevID:            r.PrevID,
+		EdgeFrom:          r.EdgeFrom,
+		EdgeTo:            r.EdgeTo,
+		IsParked:          r.IsParked,
+		Departure:         r.Departure,
+		Time:              r.Time,
+		Origin:            r.Origin,
+		Destination:       r.Destination,
+		Distance:          r.Distance,
+		Edges:             r.Edges,
+		Leaves:            r.Leaves,
+		Handoffs:          r.Handoffs,
+		DistanceRemaining: r.DistanceRemaining,
+		Line:              r.Line,
+		Stops:             r.Stops,
+		NextStop:          r.NextStop,
+		Parking:           r.Parking,
+		Stopped:           r.Stopped,
+		StreetGraph:       nil,
+		MarkedForDeletion: false,
+	}
+}
+
+// raw converts a vehicle to its wire format, which only holds the route from PrevID on
+func (v *Vehicle) raw() rawVehicle {
+	return rawVehicle{
+		ID:                v.ID,
+		PathIDs:           v.Remaining(),
+		Speed:             v.Speed,
+		Class:             v.Class,
+		Acceleration:      v.Acceleration,
+		CurrentSpeed:      v.CurrentSpeed,
+		Delta:             v.Delta,
+		NextID:            v.NextID,
+		PrevID:            v.PrevID,
+		EdgeFrom:          v.EdgeFrom,
+		EdgeTo:            v.EdgeTo,
+		IsParked:          v.IsParked,
+		Departure:         v.Departure,
+		Time:              v.Time,
+		Origin:            v.Origin,
+		Destination:       v.Destination,
+		Distance:          v.Distance,
+		Edges:             v.Edges,
+		Leaves:            v.Leaves,
+		Handoffs:          v.Handoffs,
+		DistanceRemaining: v.DistanceRemaining,
+		Line:              v.Line,
+		Stops:             v.Stops,
+		NextStop:          v.NextStop,
+		Parking:           v.Parking,
+		Stopped:           v.Stopped,
+	}
+}
+
+func (v *Vehicle) Marshal() ([]byte, error) {
+	rawVehicle := v.raw()
+	return seal(MsgVehicle, func(e *encoder) { e.vehicle(&rawVehicle) }), nil
+}
+
+// rawVehicle is the state of a vehicle that is sent between ranks and kept in checkpoint files
+type rawVehicle struct {
+	ID                string        `json:"id"`
+	PathIDs           []int         `json:"path_ids"`
+	Speed             float64       `json:"speed"`
+	Class             string        `json:"class"`
+	Acceleration      float64       `json:"acceleration"`
+	CurrentSpeed      float64       `json:"current_speed"`
+	Delta             float64       `json:"delta"`
+	NextID            int           `json:"next_id"`
+	PrevID            int           `json:"prev_id"`
+	EdgeFrom          int           `json:"edge_from"`
+	EdgeTo            int           `json:"edge_to"`
+	IsParked          bool          `json:"is_parked"`
+	Departure         float64       `json:"departure"`
+	Time              float64       `json:"time"`
+	Origin            int           `json:"origin"`
+	Destination       int           `json:"destination"`
+	Distance          float64       `json:"distance"`
+	Edges             int           `json:"edges"`
+	Leaves            []int         `json:"leaves"`
+	Handoffs          int           `json:"handoffs"`
+	DistanceRemaining float64       `json:"distance_remaining"`
+	Line              string        `json:"line"`
+	Stops             []StopTime    `json:"stops"`
+	NextStop          int           `json:"next_stop"`
+	Parking           ParkingSearch `json:"parking"`
+	Stopped           bool          `json:"stopped"`
+}
+
+type Vehicle struct {
+	ID                string
+	Route             // path of the vehicle and the route cursor
+	Speed             float64
+	Class             string  // name of the vehicle class, empty for vehicles without one
+	Acceleration      float64 // 0 drives at Speed from the start
+	CurrentSpeed      float64 // speed reached while accelerating
+	Delta             float64
+	NextID            int
+	PrevID            int
+	EdgeFrom          int // start of the edge driven last, 0 before the first step
+	EdgeTo            int // end of the edge driven last, 0 before the first step
+	IsParked          bool
+	Departure         float64
+	Time              float64 // simulated time the vehicle has reached
+	Origin            int
+	Destination       int
+	Distance          float64 // distance driven so far
+	Edges             int     // number of edges driven so far
+	Leaves            []int   // leaves the vehicle has been driven on
+	Handoffs          int     // number of times the vehicle was sent to another leaf
+	DistanceRemaining float64
+	Line              string        // transit line, empty for other vehicles
+	Stops             []StopTime    // scheduled and actual arrivals of a transit vehicle
+	NextStop          int           // index of the next stop in Stops
+	Parking           ParkingSearch // search for a parking spot at the end of the path
+	Stopped           bool          // stopped at the Until time of its graph before reaching its destination
+	StreetGraph       *StreetGraph
+	MarkedForDeletion bool
+}
@@ -0,0 +1,119 @@
+package streets
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//go:embed viz.html
+var vizPage []byte
+
+// vizGraph is the street graph as drawn by the visualisation page
+type vizGraph struct {
+	Vertices []JVertex `json:"vertices"`
+	Edges    [][2]int  `json:"edges"`
+	// Rects are the rectangles of the leaves, index i belongs to rank i+1
+	Rects []Bounds `json:"rects"`
+}
+
+// VizServer serves a web page that draws the street graph and streams the vehicle positions
+type VizServer struct {
+	board    *PositionBoard
+	graph    []byte
+	interval time.Duration
+}
+
+// NewVizServer returns a server for the graph divided into the leaves, pushing positions rate times per second
+func NewVizServer(g *StreetGraph, leaves []*StreetGraph, board *PositionBoard, rate float64) (*VizServer, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive, got %f", rate)
+	}
+
+	ids, err := g.GetVertices()
+	if err != nil {
+		return nil, err
+	}
+	vg := vizGraph{Vertices: make([]JVertex, 0, len(ids)), Edges: make([][2]int, 0), Rects: make([]Bounds, 0, len(leaves))}
+	for _, id := range ids {
+		vertex, err := g.Graph.Vertex(id)
+		if err != nil {
+			return nil, err
+		}
+		vg.Vertices = append(vg.Vertices, vertex)
+	}
+	adjacency, err := g.successors()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		for _, next := range adjacency[id] {
+			vg.Edges = append(vg.Edges, [2]int{id, next})
+		}
+	}
+	for _, leaf := range leaves {
+		vg.Rects = append(vg.Rects, leaf.Bounds)
+	}
+
+	gBytes, err := json.Marshal(vg)
+	if err != nil {
+		return nil, err
+	}
+	return &VizServer{board: board, graph: gBytes, interval: time.Duration(float64(time.Second) / rate)}, nil
+}
+
+// Handler returns the page at /, the graph at /graph and the positions as server-sent events at /positions
+func (s *VizServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(vizPage)
+	})
+	mux.HandleFunc("/graph", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(s.graph)
+	})
+	mux.HandleFunc("/positions", s.streamPositions)
+	return mux
+}
+
+// streamPositions pushes a snapshot of all positions every interval until the client disconnects
+func (s *VizServer) streamPositions(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		pBytes, err := json.Marshal(s.board.Snapshot())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", pBytes); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeViz serves the visualisation on addr until the server fails
+func ServeViz(addr string, s *VizServer) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
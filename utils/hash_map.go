@@ -48,14 +48,14 @@ func (m *HashMap[T, U]) GetOrInsert(key T, value U) (val U, loaded bool) {
 	m.Lock()
 	defer m.Unlock()
 
-	if m.Has(key) {
-		val, _ := m.Get(key)
+	// the map is accessed directly, the methods would lock the mutex again
+	if val, ok := m.m[key]; ok {
 		return val, true
 	}
 
-	m.Set(key, value)
+	m.m[key] = value
 
-	return val, false
+	return value, false
 }
 
 func (m *HashMap[T, U]) Has(key T) bool {
@@ -2,7 +2,7 @@ package utils
 
 import "math/rand"
 
-// RandomFloat64 returns a random float64 between min and max
-func RandomFloat64(min, max float64) float64 {
-	return min + rand.Float64()*(max-min)
+// RandomFloat64 returns a random float64 between min and max drawn from r
+func RandomFloat64(r *rand.Rand, min, max float64) float64 {
+	return min + r.Float64()*(max-min)
 }
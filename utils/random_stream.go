@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// splitMix64 is the SplitMix64 finalizer, used to spread nearby seeds over the whole seed space
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// NewRandStream returns a deterministic random number generator for a seed, rank and purpose.
+// Streams of different ranks or purposes are independent of each other.
+func NewRandStream(seed int64, rank int, purpose string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(purpose))
+
+	x := splitMix64(uint64(seed))
+	x = splitMix64(x ^ uint64(rank))
+	x = splitMix64(x ^ h.Sum64())
+
+	return rand.New(rand.NewSource(int64(x)))
+}
+
+// RandomID returns a random string of length n made of the characters in alphabet
+func RandomID(r *rand.Rand, alphabet string, n int) string {
+	chars := []rune(alphabet)
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteRune(chars[r.Intn(len(chars))])
+	}
+	return sb.String()
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// DefaultShards is the number of shards of a ShardedMap if none is given
+const DefaultShards = 32
+
+// stringSeed seeds HashString, it is random per process
+var stringSeed = maphash.MakeSeed()
+
+// HashString hashes string keys of a ShardedMap
+func HashString(s string) uint64 {
+	return maphash.String(stringSeed, s)
+}
+
+// HashInt hashes int keys of a ShardedMap, e.g. vertex IDs
+func HashInt(i int) uint64 {
+	return splitMix64(uint64(i))
+}
+
+// mapShard is a part of a ShardedMap with its own lock
+type mapShard[K comparable, V any] struct {
+	sync.RWMutex
+	m map[K]V
+	// keeps the locks of neighbouring shards on different cache lines
+	_ [32]byte
+}
+
+// ShardedMap is a concurrent map whose keys are spread over shards with a lock each, so that
+// goroutines working on different keys rarely wait for each other, e.g. per-edge vehicle registries
+// keyed by vehicle ID with few shards each. The functions passed to GetOrInsert, Compute, Update
+// and DeleteIf run while the shard of the key is locked, they must not use the map.
+type ShardedMap[K comparable, V any] struct {
+	shards []mapShard[K, V]
+	mask   uint64
+	hash   func(K) uint64
+}
+
+// NewShardedMap creates a map with shards rounded up to a power of two, DefaultShards if shards is not positive
+func NewShardedMap[K comparable, V any](shards int, hash func(K) uint64) *ShardedMap[K, V] {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	sm := &ShardedMap[K, V]{shards: make([]mapShard[K, V], n), mask: uint64(n - 1), hash: hash}
+	for i := range sm.shards {
+		sm.shards[i].m = make(map[K]V)
+	}
+	return sm
+}
+
+func (sm *ShardedMap[K, V]) shard(key K) *mapShard[K, V] {
+	return &sm.shards[sm.hash(key)&sm.mask]
+}
+
+// Get returns the value of key
+func (sm *ShardedMap[K, V]) Get(key K) (V, bool) {
+	s := sm.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	value, ok := s.m[key]
+	return value, ok
+}
+
+// Has reports whether key is in the map
+func (sm *ShardedMap[K, V]) Has(key K) bool {
+	_, ok := sm.Get(key)
+	return ok
+}
+
+// Set stores the value of key
+func (sm *ShardedMap[K, V]) Set(key K, value V) {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	s.m[key] = value
+}
+
+// Delete removes key
+func (sm *ShardedMap[K, V]) Delete(key K) {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.m, key)
+}
+
+// GetOrInsert returns the value of key if it is present, otherwise it stores value and returns it.
+// loaded reports whether the value was present.
+func (sm *ShardedMap[K, V]) GetOrInsert(key K, value V) (actual V, loaded bool) {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if actual, ok := s.m[key]; ok {
+		return actual, true
+	}
+	s.m[key] = value
+	return value, false
+}
+
+// Compute replaces the value of key by the result of fn, which gets the current value and whether it is
+// present. If fn returns keep false the key is deleted. Compute returns the new value and whether it is present.
+func (sm *ShardedMap[K, V]) Compute(key K, fn func(value V, ok bool) (newValue V, keep bool)) (V, bool) {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.m[key]
+	value, keep := fn(value, ok)
+	if !keep {
+		delete(s.m, key)
+		var zero V
+		return zero, false
+	}
+	s.m[key] = value
+	return value, true
+}
+
+// Update replaces the value of a present key by the result of fn. It returns the new value and
+// whether the key was present.
+func (sm *ShardedMap[K, V]) Update(key K, fn func(value V) V) (V, bool) {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.m[key]
+	if !ok {
+		return value, false
+	}
+	value = fn(value)
+	s.m[key] = value
+	return value, true
+}
+
+// DeleteIf removes key if its value satisfies pred and reports whether it was removed
+func (sm *ShardedMap[K, V]) DeleteIf(key K, pred func(value V) bool) bool {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	value, ok := s.m[key]
+	if !ok || !pred(value) {
+		return false
+	}
+	delete(s.m, key)
+	return true
+}
+
+// Len returns the number of keys
+func (sm *ShardedMap[K, V]) Len() int {
+	n := 0
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.RLock()
+		n += len(s.m)
+		s.RUnlock()
+	}
+	return n
+}
+
+// snapshot copies the map while all shards are locked, so that it holds the state of a single point in time
+func (sm *ShardedMap[K, V]) snapshot() ([]K, []V) {
+	for i := range sm.shards {
+		sm.shards[i].RLock()
+	}
+	defer func() {
+		for i := range sm.shards {
+			sm.shards[i].RUnlock()
+		}
+	}()
+
+	n := 0
+	for i := range sm.shards {
+		n += len(sm.shards[i].m)
+	}
+	keys := make([]K, 0, n)
+	values := make([]V, 0, n)
+	for i := range sm.shards {
+		for key, value := range sm.shards[i].m {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+	}
+	return keys, values
+}
+
+// Range calls fn for every key of a snapshot of the map until fn returns false. The snapshot is taken
+// at a single point in time, fn may use the map.
+func (sm *ShardedMap[K, V]) Range(fn func(key K, value V) bool) {
+	keys, values := sm.snapshot()
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			return
+		}
+	}
+}
+
+// ToList returns the values of a snapshot of the map
+func (sm *ShardedMap[K, V]) ToList() []V {
+	_, values := sm.snapshot()
+	return values
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashMap_GetOrInsert(t *testing.T) {
+	m := NewMap[string, int]()
+
+	value, loaded := m.GetOrInsert("a", 1)
+	assert.False(t, loaded)
+	assert.Equal(t, 1, value)
+
+	value, loaded = m.GetOrInsert("a", 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, value)
+}
+
+func TestShardedMap(t *testing.T) {
+	sm := NewShardedMap[string, int](3, HashString)
+	assert.Equal(t, 4, len(sm.shards))
+
+	sm.Set("a", 1)
+	value, ok := sm.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.False(t, sm.Has("b"))
+
+	value, loaded := sm.GetOrInsert("b", 2)
+	assert.False(t, loaded)
+	assert.Equal(t, 2, value)
+	value, loaded = sm.GetOrInsert("b", 3)
+	assert.True(t, loaded)
+	assert.Equal(t, 2, value)
+
+	value, ok = sm.Update("b", func(v int) int { return v * 10 })
+	assert.True(t, ok)
+	assert.Equal(t, 20, value)
+	_, ok = sm.Update("c", func(v int) int { return v * 10 })
+	assert.False(t, ok)
+	assert.False(t, sm.Has("c"))
+
+	value, ok = sm.Compute("c", func(v int, ok bool) (int, bool) { return v + 5, true })
+	assert.True(t, ok)
+	assert.Equal(t, 5, value)
+	_, ok = sm.Compute("c", func(v int, ok bool) (int, bool) { return 0, false })
+	assert.False(t, ok)
+	assert.False(t, sm.Has("c"))
+
+	assert.False(t, sm.DeleteIf("a", func(v int) bool { return v > 1 }))
+	assert.True(t, sm.DeleteIf("b", func(v int) bool { return v > 1 }))
+	assert.Equal(t, 1, sm.Len())
+	sm.Delete("a")
+	assert.Equal(t, 0, sm.Len())
+}
+
+func TestShardedMap_ConcurrentCompute(t *testing.T) {
+	sm := NewShardedMap[int, int](8, HashInt)
+
+	var wg sync.WaitGroup
+	var inserted atomic.Int64
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := i % 50
+				sm.Compute(key, func(v int, ok bool) (int, bool) { return v + 1, true })
+				if _, loaded := sm.GetOrInsert(1000+key, 0); !loaded {
+					inserted.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for key := 0; key < 50; key++ {
+		value, _ := sm.Get(key)
+		assert.Equal(t, 16*1000/50, value)
+	}
+	// exactly one goroutine inserts every key
+	assert.Equal(t, int64(50), inserted.Load())
+}
+
+func TestShardedMap_RangeSnapshot(t *testing.T) {
+	// writers move units between keys, every snapshot must hold the total
+	sm := NewShardedMap[int, int](16, HashInt)
+	const keys, total = 64, 64 * 100
+	for key := 0; key < keys; key++ {
+		sm.Set(key, 100)
+	}
+
+	var writers sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		g := g
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				from, to := (i+g)%keys, (i*7+g+1)%keys
+				if from == to {
+					continue
+				}
+				// both keys change together, a shard lock each would let Range see one change only
+				moveUnit(sm, from, to)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		sum := 0
+		sm.Range(func(key, value int) bool {
+			sum += value
+			return true
+		})
+		if sum != total {
+			t.Errorf("snapshot holds %d units, expected %d", sum, total)
+			break
+		}
+	}
+	close(stop)
+	writers.Wait()
+
+	visited := 0
+	sm.Range(func(key, value int) bool {
+		visited++
+		return visited < 3
+	})
+	assert.Equal(t, 3, visited)
+	assert.Equal(t, keys, len(sm.ToList()))
+}
+
+// moveUnit moves one unit from one key to another while holding the locks of both shards
+func moveUnit(sm *ShardedMap[int, int], from, to int) {
+	first, second := sm.hash(from)&sm.mask, sm.hash(to)&sm.mask
+	if first > second {
+		first, second = second, first
+	}
+	sm.shards[first].Lock()
+	defer sm.shards[first].Unlock()
+	if second != first {
+		sm.shards[second].Lock()
+		defer sm.shards[second].Unlock()
+	}
+	sm.shard(from).m[from]--
+	sm.shard(to).m[to]++
+}
+
+func BenchmarkShardedMap(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "vehicle-" + strconv.Itoa(i)
+	}
+
+	b.Run("sharded/read-mostly", func(b *testing.B) {
+		sm := NewShardedMap[string, int](0, HashString)
+		for _, key := range keys {
+			sm.Set(key, 0)
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				key := keys[i%len(keys)]
+				if i%10 == 0 {
+					sm.Set(key, i)
+				} else {
+					sm.Get(key)
+				}
+			}
+		})
+	})
+	b.Run("sync.Map/read-mostly", func(b *testing.B) {
+		var m sync.Map
+		for _, key := range keys {
+			m.Store(key, 0)
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				key := keys[i%len(keys)]
+				if i%10 == 0 {
+					m.Store(key, i)
+				} else {
+					m.Load(key)
+				}
+			}
+		})
+	})
+
+	// vehicles entering and leaving edges
+	b.Run("sharded/insert-delete", func(b *testing.B) {
+		sm := NewShardedMap[string, int](0, HashString)
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				key := keys[i%len(keys)]
+				if _, loaded := sm.GetOrInsert(key, i); loaded {
+					sm.Delete(key)
+				}
+			}
+		})
+	})
+	b.Run("sync.Map/insert-delete", func(b *testing.B) {
+		var m sync.Map
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				key := keys[i%len(keys)]
+				if _, loaded := m.LoadOrStore(key, i); loaded {
+					m.Delete(key)
+				}
+			}
+		})
+	})
+}